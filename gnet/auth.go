@@ -0,0 +1,132 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// 配置了Authenticator但未设置AuthTimeout时的认证期限
+const DefaultAuthTimeout = 10 * time.Second
+
+var ErrAuthTimeout = errors.New("auth timeout")
+
+// Identity 认证得到的连接身份
+type Identity struct {
+	// 不为空时自动绑定到连接, 可用GetConnByKey查找, 已绑定到其他连接时认证失败
+	Key string
+	// 业务自定义数据, 如账号信息
+	Data interface{}
+}
+
+// Authenticator 连接的第一帧用于认证, 认证通过后才调用HandlerFactory创建ConnHandler.
+// 返回错误时以CloseReasonUnauthorized断开连接, 第一帧不会交给ConnHandler
+type Authenticator interface {
+	Authenticate(conn Conn, data []byte) (*Identity, error)
+}
+
+// WSAuthenticator Authenticator可选实现, WebSocket连接在升级前使用请求头或query认证,
+// 失败时返回401, 不再使用第一帧认证. HTTP网关的请求体即消息, 只能用它认证每个请求
+type WSAuthenticator interface {
+	Authenticator
+	AuthenticateRequest(r *http.Request) (*Identity, error)
+}
+
+// GetIdentity 按连接id获取认证得到的身份
+func GetIdentity(id int64) (*Identity, bool) {
+	return registry.getIdentity(id)
+}
+
+// 按配置创建连接的ConnHandler, 配置了Authenticator时认证通过后才调用factory
+func newConnHandler(config *Config, conn Conn, factory HandlerFactory) ConnHandler {
+	if config.Authenticator == nil {
+		return wrapDispatch(config, conn, factory(conn))
+	}
+	h := &authHandler{
+		config:  config,
+		conn:    conn,
+		factory: factory,
+	}
+	h.timer = time.AfterFunc(config.AuthTimeout, func() {
+		_ = CloseConn(conn, CloseReasonUnauthorized, ErrAuthTimeout.Error())
+	})
+	return h
+}
+
+// 连接上线后记录身份并绑定key.
+// key绑定在等待恢复的会话上时不绑定到连接, 由恢复会话时校验身份
+func attachIdentity(conn Conn, identity *Identity) error {
+	if identity == nil {
+		return nil
+	}
+	if identity.Key != "" {
+		if err := registry.bind(conn.Id(), identity.Key); err != nil && !(err == ErrKeyBound && registry.keyHeldBySession(identity.Key)) {
+			return err
+		}
+	}
+	registry.setIdentity(conn.Id(), identity)
+	return nil
+}
+
+// 认证阶段的ConnHandler, 只在连接的接收goroutine中调用
+type authHandler struct {
+	config  *Config
+	conn    Conn
+	factory HandlerFactory
+	timer   *time.Timer
+	handler ConnHandler
+}
+
+func (h *authHandler) OnData(data []byte) error {
+	if h.handler != nil {
+		return h.handler.OnData(data)
+	}
+	h.timer.Stop()
+	identity, err := h.config.Authenticator.Authenticate(h.conn, data)
+	if err == nil {
+		err = attachIdentity(h.conn, identity)
+	}
+	if err != nil {
+		return &CloseError{Reason: CloseReasonUnauthorized, Err: err}
+	}
+	h.handler = wrapDispatch(h.config, h.conn, h.factory(h.conn))
+	return nil
+}
+
+func (h *authHandler) OnIdle() {
+	if handler, ok := h.handler.(IdleHandler); ok {
+		handler.OnIdle()
+	}
+}
+
+// 认证前断开的连接没有ConnHandler, 不回调OnClose
+func (h *authHandler) OnClose(err error) {
+	h.timer.Stop()
+	if h.handler != nil {
+		h.handler.OnClose(err)
+	}
+}
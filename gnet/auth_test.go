@@ -0,0 +1,206 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mafei198/glib/packet"
+)
+
+type tokenAuthenticator struct{}
+
+func (tokenAuthenticator) Authenticate(conn Conn, data []byte) (*Identity, error) {
+	if string(data) == "bad" {
+		return nil, errors.New("invalid token")
+	}
+	return &Identity{Key: "player_" + string(data), Data: len(data)}, nil
+}
+
+// 记录收到的数据, OnClose关闭closed
+type dataRecorder struct {
+	data   chan string
+	closed chan error
+}
+
+func (h *dataRecorder) OnData(data []byte) error {
+	h.data <- string(data)
+	return nil
+}
+
+func (h *dataRecorder) OnClose(err error) { h.closed <- err }
+
+// 启动带认证的TCP连接, 断开时发送关闭原因, 返回客户端及factory创建的handler
+func startAuthConn(t *testing.T, timeout time.Duration) (net.Conn, *TCPConn, chan *dataRecorder) {
+	handlers := make(chan *dataRecorder, 1)
+	client, conn := startAuthConnWith(timeout, func(Conn) ConnHandler {
+		h := &dataRecorder{data: make(chan string, 4), closed: make(chan error, 1)}
+		handlers <- h
+		return h
+	})
+	return client, conn, handlers
+}
+
+func startAuthConnWith(timeout time.Duration, factory HandlerFactory) (net.Conn, *TCPConn) {
+	client, server := net.Pipe()
+	conn := NewTcpConn(server)
+	conn.config = defaultConfig()
+	conn.config.Authenticator = tokenAuthenticator{}
+	conn.config.AuthTimeout = timeout
+	conn.config.CloseFrame = func(reason CloseReason) []byte {
+		return []byte(reason)
+	}
+	conn.delegate = newConnHandler(conn.config, conn, factory)
+	go conn.Start()
+	return client, conn
+}
+
+func writeFrames(t *testing.T, client net.Conn, conn *TCPConn, frames ...string) {
+	for _, frame := range frames {
+		data, _ := conn.config.Codec.AppendFrame(nil, []byte(frame))
+		if _, err := client.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAuthAccept(t *testing.T) {
+	saved := mgr
+	mgr = &Mgr{enableAcceptConn: true, enableAcceptMsg: true}
+	defer func() { mgr = saved }()
+
+	client, conn, handlers := startAuthConn(t, time.Second)
+	writeFrames(t, client, conn, "1001", "hello")
+	h := <-handlers
+	if got := <-h.data; got != "hello" {
+		t.Fatalf("got %q, auth frame delivered to handler", got)
+	}
+	identity, ok := GetIdentity(conn.Id())
+	if !ok || identity.Key != "player_1001" || identity.Data != 4 {
+		t.Fatalf("unexpected identity %+v", identity)
+	}
+	if bound, ok := GetConnByKey("player_1001"); !ok || bound.Id() != conn.Id() {
+		t.Fatal("identity key not bound")
+	}
+	client.Close()
+	<-h.closed
+	<-conn.closed
+	// 接收循环退出后才从注册表移除
+	for i := 0; i < 100; i++ {
+		if _, ok = GetIdentity(conn.Id()); !ok {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("identity not removed after close")
+}
+
+func TestAuthReject(t *testing.T) {
+	saved := mgr
+	mgr = &Mgr{enableAcceptConn: true, enableAcceptMsg: true}
+	defer func() { mgr = saved }()
+
+	for _, c := range []struct {
+		frames  []string
+		timeout time.Duration
+	}{
+		{[]string{"bad"}, time.Second},
+		{nil, 20 * time.Millisecond},
+	} {
+		client, conn, handlers := startAuthConn(t, c.timeout)
+		writeFrames(t, client, conn, c.frames...)
+		_ = client.SetReadDeadline(time.Now().Add(time.Second))
+		data, err := conn.config.Codec.ReadFrame(bufio.NewReader(client), MaxIncomingPacket)
+		if err != nil || string(data) != string(CloseReasonUnauthorized) {
+			t.Fatalf("frames %q: got %q, %v", c.frames, data, err)
+		}
+		select {
+		case <-handlers:
+			t.Fatal("handler created for rejected conn")
+		default:
+		}
+		client.Close()
+		<-conn.closed
+	}
+}
+
+// 认证后创建的可恢复会话继承连接的身份, 只有同一身份的连接可以恢复
+func TestAuthResume(t *testing.T) {
+	saved := mgr
+	mgr = &Mgr{enableAcceptConn: true, enableAcceptMsg: true}
+	defer func() { mgr = saved }()
+
+	factory := NewResumableFactory(func(Conn) ConnHandler { return nopHandler{} }, &ResumeConfig{})
+	readFrame := func(client net.Conn, conn *TCPConn) []byte {
+		_ = client.SetReadDeadline(time.Now().Add(time.Second))
+		data, err := conn.config.Codec.ReadFrame(bufio.NewReader(client), MaxIncomingPacket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	client, conn := startAuthConnWith(time.Second, factory)
+	writeFrames(t, client, conn, "2001", string([]byte{ResumeOpNew}))
+	reader := packet.Reader(readFrame(client, conn)[1:])
+	token, _ := reader.ReadString()
+	session, ok := GetConnByKey("player_2001")
+	if !ok || session.Id() == conn.Id() {
+		t.Fatal("identity key not moved to the session")
+	}
+	defer session.Close("test done")
+	if identity, ok := GetIdentity(session.Id()); !ok || identity.Key != "player_2001" {
+		t.Fatalf("session identity %+v", identity)
+	}
+
+	resume := string(resumeFrameOf(ResumeOpResume, token, 0))
+	other, otherConn := startAuthConnWith(time.Second, factory)
+	writeFrames(t, other, otherConn, "2002", resume)
+	if data := readFrame(other, otherConn); data[0] != ResumeOpReject {
+		t.Fatalf("resume with another identity got op %d", data[0])
+	}
+	if data := readFrame(other, otherConn); string(data) != string(CloseReasonUnauthorized) {
+		t.Fatalf("unexpected close frame %q", data)
+	}
+	other.Close()
+	<-otherConn.closed
+
+	again, againConn := startAuthConnWith(time.Second, factory)
+	writeFrames(t, again, againConn, "2001", resume)
+	if data := readFrame(again, againConn); data[0] != ResumeOpResume {
+		t.Fatalf("resume with the same identity got op %d", data[0])
+	}
+	if bound, ok := GetConnByKey("player_2001"); !ok || bound != session {
+		t.Fatal("key binding lost after resume")
+	}
+	client.Close()
+	again.Close()
+	<-conn.closed
+	<-againConn.closed
+}
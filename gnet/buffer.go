@@ -0,0 +1,72 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"encoding/binary"
+	"github.com/mafei198/glib/packet"
+	"io"
+)
+
+// 分帧时预留的最大帧头长度
+const maxFrameHeader = binary.MaxVarintLen64
+
+// ReleaseFrame OnData收到的数据处理完后可调用, 归还到packet的buffer池.
+// 调用后不能再使用data及其切片, 需要保留的数据须先拷贝; 不调用时由GC回收
+func ReleaseFrame(data []byte) {
+	packet.PutBuffer(data)
+}
+
+// 使用池中buffer编码一帧, 写出后调用packet.PutBuffer归还
+func appendFrame(codec FrameCodec, data []byte) ([]byte, error) {
+	buf := packet.GetBuffer(len(data) + maxFrameHeader)
+	frame, err := codec.AppendFrame(buf[:0], data)
+	if err != nil {
+		packet.PutBuffer(buf)
+	}
+	return frame, err
+}
+
+// 将WebSocket消息读入池中buffer, 长度由websocket.Conn.SetReadLimit限制
+func readMessage(r io.Reader) ([]byte, error) {
+	buf := packet.GetBuffer(512)[:0]
+	for {
+		if len(buf) == cap(buf) {
+			grown := packet.GetBuffer(cap(buf) * 2)[:len(buf)]
+			copy(grown, buf)
+			packet.PutBuffer(buf)
+			buf = grown
+		}
+		n, err := r.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if err == io.EOF {
+			return buf, nil
+		}
+		if err != nil {
+			packet.PutBuffer(buf)
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,99 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestReadMessage(t *testing.T) {
+	for _, size := range []int{0, 100, 512, 5000} {
+		data := bytes.Repeat([]byte{'x'}, size)
+		got, err := readMessage(bytes.NewReader(data))
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("size %d: got %d bytes, err %v", size, len(got), err)
+		}
+		ReleaseFrame(got)
+	}
+}
+
+func TestSameBuffer(t *testing.T) {
+	frame := make([]byte, 10)
+	if !sameBuffer(frame[1:], frame) || sameBuffer(make([]byte, 9), frame) {
+		t.Fatal("sameBuffer mismatch")
+	}
+}
+
+// 循环读取同一帧, 对比归还与不归还buffer时每条消息的分配
+func benchmarkReadFrame(b *testing.B, release bool) {
+	codec := NewLengthPrefixCodec(Packet, binary.BigEndian)
+	frame, _ := codec.AppendFrame(nil, make([]byte, 1024))
+	r := bytes.NewReader(nil)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r.Reset(frame)
+		data, err := codec.ReadFrame(r, MaxIncomingPacket)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if release {
+			ReleaseFrame(data)
+		}
+	}
+}
+
+func BenchmarkReadFrame(b *testing.B) {
+	benchmarkReadFrame(b, false)
+}
+
+func BenchmarkReadFrameRelease(b *testing.B) {
+	benchmarkReadFrame(b, true)
+}
+
+func BenchmarkAppendFrame(b *testing.B) {
+	codec := NewLengthPrefixCodec(Packet, binary.BigEndian)
+	data := make([]byte, 1024)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := codec.AppendFrame(make([]byte, 0, len(data)+maxFrameHeader), data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkAppendFramePooled(b *testing.B) {
+	codec := NewLengthPrefixCodec(Packet, binary.BigEndian)
+	data := make([]byte, 1024)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		frame, err := appendFrame(codec, data)
+		if err != nil {
+			b.Fatal(err)
+		}
+		ReleaseFrame(frame)
+	}
+}
@@ -0,0 +1,266 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mafei198/glib/logger"
+	"github.com/mafei198/glib/pbmsg"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrCaptureDisabled = errors.New("capture disabled")
+	ErrCaptureClosed   = errors.New("capture conn closed")
+)
+
+// 录制文件中帧的方向
+const (
+	CaptureIn    = "in"
+	CaptureOut   = "out"
+	CaptureClose = "close"
+)
+
+// CaptureConfig 连接收发帧的录制配置, 每个会话写一个JSON Lines文件,
+// 第一行为CaptureHeader, 之后每行一个CaptureFrame.
+// 录制的是解密解压后交给OnData及传给SendData的数据, 可用Replay重放
+type CaptureConfig struct {
+	// 录制文件目录, 不存在时自动创建
+	Dir string
+	// 为true时录制所有连接, 否则只录制调用StartCapture的连接
+	All bool
+	// 解析帧的消息类型名, 默认使用pbmsg.TypeName
+	TypeName func(data []byte) string
+}
+
+// CaptureHeader 录制文件头
+type CaptureHeader struct {
+	Conn     int64     `json:"conn"`
+	Protocol string    `json:"protocol"`
+	Remote   string    `json:"remote"`
+	Start    time.Time `json:"start"`
+}
+
+// CaptureFrame 录制的一帧, Dir为CaptureClose时记录连接的关闭原因
+type CaptureFrame struct {
+	// 距录制开始的时间
+	Time   time.Duration `json:"t"`
+	Dir    string        `json:"dir"`
+	Type   string        `json:"type,omitempty"`
+	Data   []byte        `json:"data,omitempty"`
+	Reason CloseReason   `json:"reason,omitempty"`
+}
+
+// StartCapture 开始录制指定连接, 须配置Config.Capture
+func StartCapture(id int64) error {
+	capture, err := getCapture(id)
+	if err != nil {
+		return err
+	}
+	conn, _ := registry.get(id)
+	return capture.begin(conn)
+}
+
+// StopCapture 停止录制指定连接, 已写入的数据保留
+func StopCapture(id int64) error {
+	capture, err := getCapture(id)
+	if err != nil {
+		return err
+	}
+	capture.stop()
+	return nil
+}
+
+// 支持录制的连接
+type captureConn interface {
+	captureState() *connCapture
+}
+
+func getCapture(id int64) (*connCapture, error) {
+	conn, ok := registry.get(id)
+	if !ok {
+		return nil, ErrConnNotFound
+	}
+	cc, ok := conn.(captureConn)
+	if !ok || cc.captureState() == nil {
+		return nil, ErrCaptureDisabled
+	}
+	return cc.captureState(), nil
+}
+
+func captureTypeName(data []byte) string {
+	name, _ := pbmsg.TypeName(data)
+	return name
+}
+
+// 单个连接的录制状态, 未配置Config.Capture时为nil, 所有方法可在nil上调用
+type connCapture struct {
+	config   *CaptureConfig
+	protocol string
+	active   int32
+	mutex    sync.Mutex
+	file     *os.File
+	encoder  *json.Encoder
+	start    time.Time
+	closed   bool
+}
+
+func newConnCapture(config *Config, protocol string) *connCapture {
+	if config.Capture == nil {
+		return nil
+	}
+	return &connCapture{config: config.Capture, protocol: protocol}
+}
+
+// 连接上线时调用, 配置了All时开始录制
+func (c *connCapture) open(conn Conn) {
+	if c == nil || !c.config.All {
+		return
+	}
+	if err := c.begin(conn); err != nil {
+		logger.WARN("gnet capture start failed: ", err)
+	}
+}
+
+func (c *connCapture) begin(conn Conn) error {
+	if c == nil {
+		return ErrCaptureDisabled
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return ErrCaptureClosed
+	}
+	if c.file != nil {
+		return nil
+	}
+	if err := os.MkdirAll(c.config.Dir, 0755); err != nil {
+		return err
+	}
+	start := time.Now()
+	name := fmt.Sprintf("%s_%d_%d.jsonl", c.protocol, conn.Id(), start.UnixNano())
+	file, err := os.Create(filepath.Join(c.config.Dir, name))
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	header := &CaptureHeader{
+		Conn:     conn.Id(),
+		Protocol: c.protocol,
+		Remote:   conn.RemoteAddr().String(),
+		Start:    start,
+	}
+	if err = encoder.Encode(header); err != nil {
+		_ = file.Close()
+		return err
+	}
+	c.file = file
+	c.encoder = encoder
+	c.start = start
+	atomic.StoreInt32(&c.active, 1)
+	return nil
+}
+
+func (c *connCapture) in(data []byte) {
+	c.record(CaptureIn, data)
+}
+
+func (c *connCapture) out(data []byte) {
+	c.record(CaptureOut, data)
+}
+
+func (c *connCapture) record(dir string, data []byte) {
+	if c == nil || atomic.LoadInt32(&c.active) == 0 {
+		return
+	}
+	typeName := c.config.TypeName
+	if typeName == nil {
+		typeName = captureTypeName
+	}
+	frame := &CaptureFrame{
+		Dir:  dir,
+		Type: typeName(data),
+		Data: data,
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.file == nil {
+		return
+	}
+	frame.Time = time.Since(c.start)
+	if err := c.encoder.Encode(frame); err != nil {
+		logger.WARN("gnet capture write failed: ", err)
+		c.release()
+	}
+}
+
+func (c *connCapture) stop() {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	c.release()
+	c.mutex.Unlock()
+}
+
+// 连接断开时调用, 写入关闭原因并结束录制
+func (c *connCapture) close(reason CloseReason) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.closed = true
+	if c.file == nil {
+		return
+	}
+	frame := &CaptureFrame{
+		Time:   time.Since(c.start),
+		Dir:    CaptureClose,
+		Reason: reason,
+	}
+	if err := c.encoder.Encode(frame); err != nil {
+		logger.WARN("gnet capture write failed: ", err)
+	}
+	c.release()
+}
+
+func (c *connCapture) release() {
+	if c.file == nil {
+		return
+	}
+	atomic.StoreInt32(&c.active, 0)
+	if err := c.file.Close(); err != nil {
+		logger.WARN("gnet capture close failed: ", err)
+	}
+	c.file = nil
+	c.encoder = nil
+}
@@ -0,0 +1,157 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/mafei198/glib/pbmsg"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 原样返回收到的数据, 忽略OnClose
+type replyHandler struct {
+	conn Conn
+}
+
+func (h *replyHandler) OnData(data []byte) error {
+	return h.conn.SendData(data)
+}
+
+func (h *replyHandler) OnClose(error) {}
+
+func TestCaptureAndReplay(t *testing.T) {
+	saved := mgr
+	mgr = &Mgr{enableAcceptConn: true, enableAcceptMsg: true}
+	defer func() { mgr = saved }()
+
+	dir, err := ioutil.TempDir("", "gnet_capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := NewTcpConn(server)
+	conn.config = defaultConfig()
+	conn.config.Capture = &CaptureConfig{Dir: dir, All: true}
+	conn.capture = newConnCapture(conn.config, ProtocolTCP)
+	conn.delegate = &replyHandler{conn: conn}
+	go conn.Start()
+
+	frames := []string{"hello", "world"}
+	reply := make([]byte, 64)
+	for _, value := range frames {
+		data, err := pbmsg.Encode(&wrappers.StringValue{Value: value})
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeFrames(t, client, conn, string(data))
+		if _, err = io.ReadAtLeast(client, reply, Packet+len(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if _, ok := GetConn(conn.Id()); ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err = Kick(conn.Id(), "test"); err != nil {
+		t.Fatal(err)
+	}
+	<-conn.closed
+
+	paths, _ := filepath.Glob(filepath.Join(dir, "tcp_*.jsonl"))
+	if len(paths) != 1 {
+		t.Fatalf("expect one recording, got %v", paths)
+	}
+	rec, err := LoadRecording(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Header.Conn != conn.Id() || rec.Header.Protocol != ProtocolTCP {
+		t.Fatalf("unexpected header %+v", rec.Header)
+	}
+	dirs := []string{CaptureIn, CaptureOut, CaptureIn, CaptureOut, CaptureClose}
+	if len(rec.Frames) != len(dirs) {
+		t.Fatalf("expect %d frames, got %d", len(dirs), len(rec.Frames))
+	}
+	for i, frame := range rec.Frames {
+		if frame.Dir != dirs[i] {
+			t.Fatalf("frame %d: expect dir %s, got %s", i, dirs[i], frame.Dir)
+		}
+		if frame.Dir != CaptureClose && frame.Type != "StringValue" {
+			t.Fatalf("frame %d: unexpected type %q", i, frame.Type)
+		}
+	}
+	if reason, ok := rec.CloseReason(); !ok || reason != CloseReasonKick {
+		t.Fatalf("unexpected close reason %v", reason)
+	}
+
+	replayed, err := Replay(rec, func(conn Conn) ConnHandler {
+		return &replyHandler{conn: conn}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := replayed.Sent()
+	if len(sent) != len(frames) {
+		t.Fatalf("expect %d replies, got %d", len(frames), len(sent))
+	}
+	for i, data := range sent {
+		msg, err := pbmsg.Decode(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value := msg.(*wrappers.StringValue).Value; value != frames[i] {
+			t.Fatalf("reply %d: expect %s, got %s", i, frames[i], value)
+		}
+	}
+	if reason := CloseReasonOf(replayed.CloseError()); reason != CloseReasonKick {
+		t.Fatalf("unexpected replay close reason %v", reason)
+	}
+	if _, ok := GetConn(replayed.Id()); ok {
+		t.Fatal("replay conn not removed from registry")
+	}
+}
+
+func TestStartCaptureDisabled(t *testing.T) {
+	conn := newReplayConn("127.0.0.1:1")
+	registry.add(conn)
+	defer registry.del(conn.Id())
+	if err := StartCapture(conn.Id()); err != ErrCaptureDisabled {
+		t.Fatalf("expect ErrCaptureDisabled, got %v", err)
+	}
+	if err := StartCapture(-1); err != ErrConnNotFound {
+		t.Fatalf("expect ErrConnNotFound, got %v", err)
+	}
+}
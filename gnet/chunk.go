@@ -0,0 +1,157 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/mafei198/glib/packet"
+	"sync/atomic"
+	"time"
+)
+
+// 启用分片时重组后单条消息的默认最大长度
+const DefaultMaxMessageSize = 4 << 20
+
+// 每个连接同时重组中的消息数上限
+const maxChunkStreams = 4
+
+// 超过该时间仍未收齐的消息在收到新分片时丢弃, 释放其buffer
+const chunkStreamTimeout = 30 * time.Second
+
+// 重组buffer的初始容量上限, 之后随收到的分片增长
+const maxChunkPrealloc = 64 << 10
+
+// 分片帧在标志位之后的头部: [id uint32][seq uint32][total uint32],
+// total为整条消息(压缩后)的长度, 同一消息的分片按seq从0依次发送
+const chunkHeaderLen = 12
+
+// 标志位、分片头部及AES-GCM tag的长度
+const chunkOverhead = 1 + chunkHeaderLen + 16
+
+var ErrInvalidChunk = errors.New("invalid chunk frame")
+
+// 拆分发送大消息, 先整体压缩再拆分, 各分片单独加密.
+// 每个分片单独写出, 期间其他消息可以穿插发送
+func (t *transformer) sendChunks(data []byte, sendFrame func([]byte) error) error {
+	if t.handshakePending() {
+		return ErrHandshakePending
+	}
+	if len(data) > t.config.MaxMessageSize {
+		return frameTooLarge(uint64(len(data)))
+	}
+	data, flags, err := t.compress(data)
+	if err != nil {
+		return err
+	}
+	id := atomic.AddUint32(&t.chunkSeq, 1)
+	chunk := packet.GetBuffer(chunkHeaderLen + t.config.ChunkSize)
+	defer packet.PutBuffer(chunk)
+	binary.BigEndian.PutUint32(chunk[0:], id)
+	binary.BigEndian.PutUint32(chunk[8:], uint32(len(data)))
+	for seq := 0; len(data) > 0; seq++ {
+		n := len(data)
+		if n > t.config.ChunkSize {
+			n = t.config.ChunkSize
+		}
+		binary.BigEndian.PutUint32(chunk[4:], uint32(seq))
+		frame := append(chunk[:chunkHeaderLen], data[:n]...)
+		if err = t.seal(frame, flags|FlagChunk, sendFrame); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// 重组中的消息
+type chunkStream struct {
+	flags byte
+	seq   uint32
+	total int
+	data  []byte
+	start time.Time
+}
+
+// 单连接的分片重组, 只在读goroutine中使用
+type chunkAssembler struct {
+	maxSize int
+	streams map[uint32]*chunkStream
+}
+
+func newChunkAssembler(maxSize int) *chunkAssembler {
+	return &chunkAssembler{maxSize: maxSize, streams: map[uint32]*chunkStream{}}
+}
+
+// 加入一个已解密的分片, 消息完整时返回(压缩的)消息数据及标志位
+func (a *chunkAssembler) add(flags byte, frame []byte) ([]byte, bool, error) {
+	if len(frame) <= chunkHeaderLen {
+		return nil, false, ErrInvalidChunk
+	}
+	id := binary.BigEndian.Uint32(frame[0:])
+	seq := binary.BigEndian.Uint32(frame[4:])
+	total := binary.BigEndian.Uint32(frame[8:])
+	payload := frame[chunkHeaderLen:]
+	if uint64(total) > uint64(a.maxSize) {
+		return nil, false, frameTooLarge(uint64(total))
+	}
+	now := time.Now()
+	a.dropStale(now)
+	stream, ok := a.streams[id]
+	switch {
+	case !ok && seq == 0:
+		if len(a.streams) >= maxChunkStreams {
+			return nil, false, ErrInvalidChunk
+		}
+		size := int(total)
+		if size > maxChunkPrealloc {
+			size = maxChunkPrealloc
+		}
+		stream = &chunkStream{flags: flags, total: int(total), data: packet.GetBuffer(size)[:0], start: now}
+		a.streams[id] = stream
+	case !ok || seq != stream.seq || flags != stream.flags || int(total) != stream.total:
+		return nil, false, ErrInvalidChunk
+	}
+	if len(stream.data)+len(payload) > stream.total {
+		return nil, false, ErrInvalidChunk
+	}
+	stream.data = append(stream.data, payload...)
+	stream.seq++
+	if len(stream.data) < stream.total {
+		return nil, false, nil
+	}
+	delete(a.streams, id)
+	return stream.data, true, nil
+}
+
+// 对端开始发送后长时间不发完的消息不再占用内存, 之后的分片视为无效
+func (a *chunkAssembler) dropStale(now time.Time) {
+	for id, stream := range a.streams {
+		if now.Sub(stream.start) > chunkStreamTimeout {
+			packet.PutBuffer(stream.data)
+			delete(a.streams, id)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func newChunkConfig(t *testing.T) *Config {
+	config := &Config{ChunkSize: 1024, Compression: CompressSnappy, CompressThreshold: 64}
+	if err := config.init(); err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func randomPayload(size int) []byte {
+	data := make([]byte, size)
+	_, _ = rand.Read(data)
+	return data
+}
+
+// 两条大消息的分片与普通消息穿插到达, 各自完整交付一次
+func TestChunkInterleaved(t *testing.T) {
+	config := newChunkConfig(t)
+	ct, st := newClientTransformer(config), newTransformer(config)
+	first, second := randomPayload(10*1024+7), randomPayload(5000)
+
+	sinks := make([]*frameSink, 3)
+	for i, data := range [][]byte{first, second, []byte("ping")} {
+		sinks[i] = &frameSink{}
+		if err := ct.send(data, sinks[i].send); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(sinks[0].frames) != 11 || len(sinks[1].frames) != 5 || len(sinks[2].frames) != 1 {
+		t.Fatalf("unexpected chunk count %d %d %d", len(sinks[0].frames), len(sinks[1].frames), len(sinks[2].frames))
+	}
+
+	var delivered [][]byte
+	feed := func(frame []byte) {
+		data, ok, err := st.onFrame(nil, frame, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			delivered = append(delivered, data)
+		}
+	}
+	for i := 0; i < len(sinks[0].frames); i++ {
+		feed(sinks[0].frames[i])
+		if i < len(sinks[1].frames) {
+			feed(sinks[1].frames[i])
+		}
+		if i == 2 {
+			feed(sinks[2].frames[0])
+		}
+	}
+	expects := [][]byte{[]byte("ping"), second, first}
+	if len(delivered) != len(expects) {
+		t.Fatalf("expect %d messages, got %d", len(expects), len(delivered))
+	}
+	for i, data := range delivered {
+		if !bytes.Equal(data, expects[i]) {
+			t.Fatalf("message %d mismatch", i)
+		}
+	}
+}
+
+func chunkFrame(id, seq, total uint32, payload []byte) []byte {
+	frame := []byte{FlagChunk}
+	frame = append(frame, make([]byte, chunkHeaderLen)...)
+	binary.BigEndian.PutUint32(frame[1:], id)
+	binary.BigEndian.PutUint32(frame[5:], seq)
+	binary.BigEndian.PutUint32(frame[9:], total)
+	return append(frame, payload...)
+}
+
+func TestChunkInvalid(t *testing.T) {
+	config := newChunkConfig(t)
+	config.MaxMessageSize = 100
+	cases := []struct {
+		name   string
+		frames [][]byte
+		err    error
+	}{
+		{"too large", [][]byte{chunkFrame(1, 0, 101, []byte("a"))}, ErrFrameTooLarge},
+		{"missing first", [][]byte{chunkFrame(1, 1, 10, []byte("a"))}, ErrInvalidChunk},
+		{"out of order", [][]byte{chunkFrame(1, 0, 10, []byte("a")), chunkFrame(1, 2, 10, []byte("b"))}, ErrInvalidChunk},
+		{"overflow", [][]byte{chunkFrame(1, 0, 2, []byte("abc"))}, ErrInvalidChunk},
+		{"empty", [][]byte{chunkFrame(1, 0, 2, nil)}, ErrInvalidChunk},
+		{"too many streams", [][]byte{
+			chunkFrame(1, 0, 10, []byte("a")), chunkFrame(2, 0, 10, []byte("a")),
+			chunkFrame(3, 0, 10, []byte("a")), chunkFrame(4, 0, 10, []byte("a")),
+			chunkFrame(5, 0, 10, []byte("a")),
+		}, ErrInvalidChunk},
+	}
+	for _, c := range cases {
+		st := newTransformer(config)
+		var err error
+		for _, frame := range c.frames {
+			if _, _, err = st.onFrame(nil, frame, nil); err != nil {
+				break
+			}
+		}
+		if !errors.Is(err, c.err) {
+			t.Fatalf("%s: expect %v, got %v", c.name, c.err, err)
+		}
+	}
+
+	// 超时未收齐的消息被丢弃, 不再占用重组名额, 之后的分片无效
+	st := newTransformer(config)
+	for id := uint32(1); id <= maxChunkStreams; id++ {
+		if _, _, err := st.onFrame(nil, chunkFrame(id, 0, 10, []byte("a")), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, stream := range st.chunks.streams {
+		stream.start = stream.start.Add(-chunkStreamTimeout - time.Second)
+	}
+	if _, _, err := st.onFrame(nil, chunkFrame(5, 0, 10, []byte("a")), nil); err != nil {
+		t.Fatalf("stale streams not dropped: %v", err)
+	}
+	if len(st.chunks.streams) != 1 {
+		t.Fatalf("%d streams left", len(st.chunks.streams))
+	}
+	if _, _, err := st.onFrame(nil, chunkFrame(1, 1, 10, []byte("b")), nil); !errors.Is(err, ErrInvalidChunk) {
+		t.Fatalf("chunk of a dropped stream: %v", err)
+	}
+
+	// 未启用分片的连接不接受分片帧
+	plain := &Config{Compression: CompressSnappy}
+	_ = plain.init()
+	if _, _, err := newTransformer(plain).onFrame(nil, chunkFrame(1, 0, 1, []byte("a")), nil); err != ErrInvalidFlags {
+		t.Fatalf("expect ErrInvalidFlags, got %v", err)
+	}
+	if err := newTransformer(config).send(randomPayload(2000), (&frameSink{}).send); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("expect ErrFrameTooLarge, got %v", err)
+	}
+}
+
+// 超过MaxFrameSize的消息经TCP连接收发
+func TestTCPConnChunked(t *testing.T) {
+	saved := mgr
+	mgr = &Mgr{enableAcceptConn: true, enableAcceptMsg: true}
+	defer func() { mgr = saved }()
+
+	client, server := net.Pipe()
+	conn := NewTcpConn(server)
+	conn.config = newChunkConfig(t)
+	conn.transformer = newTransformer(conn.config)
+	conn.delegate = &replyHandler{conn: conn}
+	go conn.Start()
+
+	payload := randomPayload(3 * conn.config.MaxFrameSize)
+	ct := newClientTransformer(conn.config)
+	go func() {
+		_ = ct.send(payload, func(frame []byte) error {
+			data, _ := conn.config.Codec.AppendFrame(nil, frame)
+			_, err := client.Write(data)
+			return err
+		})
+	}()
+	reader := bufio.NewReader(client)
+	for {
+		frame, err := conn.config.Codec.ReadFrame(reader, conn.config.MaxFrameSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, ok, err := ct.onFrame(nil, frame, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			if !bytes.Equal(data, payload) {
+				t.Fatal("echoed message mismatch")
+			}
+			break
+		}
+	}
+	client.Close()
+	<-conn.closed
+}
@@ -0,0 +1,229 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"bufio"
+	"github.com/golang/protobuf/proto"
+	"github.com/mafei198/glib/logger"
+	"github.com/mafei198/glib/packet"
+	"github.com/mafei198/glib/pbmsg"
+	"net"
+	"sync"
+	"time"
+)
+
+// 处理服务器的推送及请求, 请求的返回值作为响应发回服务器
+type ClientHandler func(env *pbmsg.Envelope) (proto.Message, error)
+
+// 使用pbmsg.Envelope协议的TCP客户端, 用于机器人、压测及服务器间调用
+type Client struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	config *Config
+	// 与服务器相同的压缩/加密配置, 均未启用时为nil
+	transformer *transformer
+	handler     ClientHandler
+	calls       *pbmsg.Calls
+	wmutex      sync.Mutex
+	closed      chan struct{}
+	closeOnce   sync.Once
+	closeErr    error
+}
+
+func Dial(address string, handler ClientHandler, configs ...*Config) (*Client, error) {
+	config := defaultConfig()
+	if len(configs) > 0 {
+		config = configs[0]
+		if err := config.init(); err != nil {
+			return nil, err
+		}
+	}
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	client := &Client{
+		conn:        conn,
+		reader:      bufio.NewReader(conn),
+		config:      config,
+		transformer: newClientTransformer(config),
+		handler:     handler,
+		calls:       pbmsg.NewCalls(),
+		closed:      make(chan struct{}),
+	}
+	if config.ClientKeyExchange != nil {
+		if err = client.handshake(); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	go client.readLoop()
+	return client, nil
+}
+
+// 发送请求并等待响应
+func (c *Client) Call(msg proto.Message, timeout time.Duration) (proto.Message, error) {
+	env, err := c.calls.Call(c.SendData, msg, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return env.Msg, nil
+}
+
+// 发送不需要响应的消息
+func (c *Client) Send(msg proto.Message) error {
+	return c.send(&pbmsg.Envelope{Kind: pbmsg.KindPush, Msg: msg})
+}
+
+func (c *Client) SendData(data []byte) error {
+	if c.transformer != nil {
+		return c.transformer.send(data, c.sendFrame)
+	}
+	return c.sendFrame(data)
+}
+
+func (c *Client) sendFrame(data []byte) error {
+	frame, err := appendFrame(c.config.Codec, data)
+	if err != nil {
+		return err
+	}
+	defer packet.PutBuffer(frame)
+	c.wmutex.Lock()
+	defer c.wmutex.Unlock()
+	_, err = c.conn.Write(frame)
+	return err
+}
+
+func (c *Client) Close() error {
+	c.shutdown(nil)
+	return c.conn.Close()
+}
+
+// 连接断开后关闭
+func (c *Client) Done() <-chan struct{} {
+	return c.closed
+}
+
+// 连接断开的原因
+func (c *Client) Err() error {
+	select {
+	case <-c.closed:
+		return c.closeErr
+	default:
+		return nil
+	}
+}
+
+func (c *Client) send(env *pbmsg.Envelope) error {
+	data, err := pbmsg.EncodeEnvelope(env)
+	if err != nil {
+		return err
+	}
+	return c.SendData(data)
+}
+
+// 发送Hello并等待服务器回复, 在IdleTimeout内完成
+func (c *Client) handshake() error {
+	exchange := c.config.ClientKeyExchange()
+	hello, err := exchange.Hello()
+	if err != nil {
+		return err
+	}
+	if err = c.sendFrame(append([]byte{0}, hello...)); err != nil {
+		return err
+	}
+	if err = c.conn.SetReadDeadline(time.Now().Add(c.config.IdleTimeout)); err != nil {
+		return err
+	}
+	var reply []byte
+	// 跳过服务器的ping帧
+	for len(reply) == 0 {
+		if reply, err = c.config.Codec.ReadFrame(c.reader, c.config.MaxFrameSize); err != nil {
+			return err
+		}
+	}
+	if reply[0] != 0 {
+		return ErrInvalidFlags
+	}
+	secret, err := exchange.Finish(reply[1:])
+	if err != nil {
+		return err
+	}
+	if err = c.conn.SetReadDeadline(time.Time{}); err != nil {
+		return err
+	}
+	return c.transformer.setSecret(secret)
+}
+
+func (c *Client) shutdown(err error) {
+	c.closeOnce.Do(func() {
+		c.closeErr = err
+		close(c.closed)
+		c.calls.Close(err)
+	})
+}
+
+func (c *Client) readLoop() {
+	defer c.conn.Close()
+	for {
+		data, err := c.config.Codec.ReadFrame(c.reader, c.config.MaxFrameSize)
+		if err != nil {
+			c.shutdown(err)
+			return
+		}
+		// 0长度帧为服务器的ping
+		if len(data) == 0 {
+			continue
+		}
+		if c.transformer != nil {
+			var ok bool
+			if data, ok, err = c.transformer.onFrame(nil, data, c.sendFrame); err != nil {
+				c.shutdown(err)
+				return
+			} else if !ok {
+				continue
+			}
+		}
+		env, err := pbmsg.DecodeEnvelope(data)
+		if err != nil {
+			c.shutdown(err)
+			return
+		}
+		if c.calls.Dispatch(env) || env.Kind == pbmsg.KindResponse {
+			continue
+		}
+		if c.handler == nil {
+			continue
+		}
+		reply, err := c.handler(env)
+		if env.Kind != pbmsg.KindRequest {
+			continue
+		}
+		if err = c.send(pbmsg.NewResponse(env.Id, reply, err)); err != nil {
+			logger.WARN("gnet client send response failed: ", err)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"errors"
+	"github.com/gorilla/websocket"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 连接关闭原因
+type CloseReason string
+
+const (
+	CloseReasonClosed        CloseReason = "closed"          // 服务器调用Close关闭
+	CloseReasonClientClosed  CloseReason = "client_closed"   // 客户端关闭
+	CloseReasonTimeout       CloseReason = "timeout"         // 读超时或心跳超时
+	CloseReasonFrameTooLarge CloseReason = "frame_too_large" // 帧超过MaxFrameSize
+	CloseReasonKick          CloseReason = "kick"            // 被Kick踢下线
+	CloseReasonShutdown      CloseReason = "shutdown"        // 服务器停止
+	CloseReasonRateLimited   CloseReason = "rate_limited"    // 超出限流
+	CloseReasonProtocolError CloseReason = "protocol_error"  // 分帧/解密/握手等协议错误
+	CloseReasonUnauthorized  CloseReason = "unauthorized"    // 认证失败或超时
+	CloseReasonSendQueueFull CloseReason = "send_queue_full" // 客户端接收过慢, 发送队列已满
+	CloseReasonError         CloseReason = "error"           // 网络错误或OnData返回错误
+)
+
+var (
+	// 服务器停止后接收循环退出
+	ErrShutdown = errors.New("server shutdown")
+	// 事件循环模式下超过IdleTimeout未收到数据
+	ErrIdleTimeout = errors.New("idle timeout")
+)
+
+// 主动关闭后发送最后一帧及close控制帧的超时
+const closeNotifyTimeout = time.Second
+
+// 写超时, 开始关闭后不超过closeNotifyTimeout, 在写锁内设置deadline
+func writeTimeout(config *Config, closing *int32) time.Duration {
+	timeout := config.WriteTimeout
+	if atomic.LoadInt32(closing) == 1 && (timeout <= 0 || timeout > closeNotifyTimeout) {
+		timeout = closeNotifyTimeout
+	}
+	return timeout
+}
+
+// CloseError 传给OnClose的错误, 可用errors.Is/As检查底层错误
+type CloseError struct {
+	Reason CloseReason
+	Err    error
+}
+
+func (e *CloseError) Error() string {
+	if e.Err == nil {
+		return string(e.Reason)
+	}
+	return string(e.Reason) + ": " + e.Err.Error()
+}
+
+func (e *CloseError) Unwrap() error {
+	return e.Err
+}
+
+// ReasonCloser 可指定关闭原因的连接, TCP/WebSocket/RUDP连接均已实现
+type ReasonCloser interface {
+	Conn
+	CloseWithReason(reason CloseReason, msg string) error
+}
+
+// CloseConn 按指定原因关闭连接, 连接不支持时退化为Close
+func CloseConn(conn Conn, reason CloseReason, msg string) error {
+	if closer, ok := conn.(ReasonCloser); ok {
+		return closer.CloseWithReason(reason, msg)
+	}
+	return conn.Close(msg)
+}
+
+// CloseReasonOf 返回OnClose收到的错误对应的关闭原因
+func CloseReasonOf(err error) CloseReason {
+	var closeErr *CloseError
+	var wsErr *websocket.CloseError
+	switch {
+	case err == nil:
+		return CloseReasonClosed
+	case errors.As(err, &closeErr):
+		return closeErr.Reason
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, ErrRUDPClosed),
+		errors.As(err, &wsErr):
+		return CloseReasonClientClosed
+	case isTimeout(err), errors.Is(err, ErrIdleTimeout), errors.Is(err, ErrRUDPTimeout), errors.Is(err, ErrRUDPDead), errors.Is(err, ErrResumeExpired):
+		return CloseReasonTimeout
+	case errors.Is(err, ErrShutdown):
+		return CloseReasonShutdown
+	case errors.Is(err, ErrAuthTimeout):
+		return CloseReasonUnauthorized
+	case errors.Is(err, ErrRateLimited):
+		return CloseReasonRateLimited
+	case errors.Is(err, ErrRUDPSendQueue):
+		return CloseReasonSendQueueFull
+	case errors.Is(err, ErrFrameTooLarge), errors.Is(err, websocket.ErrReadLimit):
+		return CloseReasonFrameTooLarge
+	case errors.Is(err, ErrInvalidFrame), errors.Is(err, ErrInvalidFlags), errors.Is(err, ErrHandshakePending),
+		errors.Is(err, ErrDecrypt), errors.Is(err, ErrInvalidChunk):
+		return CloseReasonProtocolError
+	}
+	return CloseReasonError
+}
+
+func newCloseError(err error) *CloseError {
+	if closeErr, ok := err.(*CloseError); ok {
+		return closeErr
+	}
+	return &CloseError{Reason: CloseReasonOf(err), Err: err}
+}
+
+// 客户端关闭以外的原因由服务器发起, 可通知客户端
+func (r CloseReason) serverSide() bool {
+	return r != CloseReasonClientClosed
+}
+
+// WebSocket close控制帧使用的状态码
+func (r CloseReason) wsCode() int {
+	switch r {
+	case CloseReasonShutdown:
+		return websocket.CloseGoingAway
+	case CloseReasonProtocolError:
+		return websocket.CloseProtocolError
+	case CloseReasonFrameTooLarge:
+		return websocket.CloseMessageTooBig
+	case CloseReasonRateLimited, CloseReasonSendQueueFull:
+		return websocket.ClosePolicyViolation
+	case CloseReasonError:
+		return websocket.CloseInternalServerErr
+	case CloseReasonKick:
+		return WSCloseKick
+	case CloseReasonTimeout:
+		return WSCloseTimeout
+	case CloseReasonUnauthorized:
+		return WSCloseUnauthorized
+	}
+	return websocket.CloseNormalClosure
+}
+
+// 私有范围的WebSocket状态码
+const (
+	WSCloseKick         = 4000
+	WSCloseTimeout      = 4001
+	WSCloseUnauthorized = 4002
+)
+
+// 记录连接的关闭原因, 只保留第一次设置的原因
+type closeState struct {
+	mutex  sync.Mutex
+	reason CloseReason
+}
+
+// 首次设置时返回true, 由调用方负责通知客户端
+func (s *closeState) set(reason CloseReason) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.reason != "" {
+		return false
+	}
+	s.reason = reason
+	return true
+}
+
+// 接收循环退出时调用, 未主动关闭时按err判断原因
+func (s *closeState) done(err error) (*CloseError, bool) {
+	reason := CloseReasonOf(err)
+	notify := s.set(reason)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return &CloseError{Reason: s.reason, Err: err}, notify
+}
+
+// Config.CloseFrame返回的数据, 客户端关闭或未配置时为nil
+func closeFrame(config *Config, reason CloseReason) []byte {
+	if config.CloseFrame == nil || !reason.serverSide() {
+		return nil
+	}
+	return config.CloseFrame(reason)
+}
@@ -0,0 +1,169 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCloseReasonOf(t *testing.T) {
+	cases := []struct {
+		err    error
+		reason CloseReason
+	}{
+		{nil, CloseReasonClosed},
+		{io.EOF, CloseReasonClientClosed},
+		{ErrRUDPClosed, CloseReasonClientClosed},
+		{ErrRUDPTimeout, CloseReasonTimeout},
+		{ErrShutdown, CloseReasonShutdown},
+		{ErrRateLimited, CloseReasonRateLimited},
+		{ErrRUDPSendQueue, CloseReasonSendQueueFull},
+		{fmt.Errorf("read: %w", ErrFrameTooLarge), CloseReasonFrameTooLarge},
+		{ErrDecrypt, CloseReasonProtocolError},
+		{errors.New("handler failed"), CloseReasonError},
+		{&CloseError{Reason: CloseReasonKick, Err: io.EOF}, CloseReasonKick},
+	}
+	for _, c := range cases {
+		if got := CloseReasonOf(c.err); got != c.reason {
+			t.Errorf("%v: got %s, want %s", c.err, got, c.reason)
+		}
+	}
+}
+
+// 记录OnClose收到的错误
+type closeRecorder struct {
+	closed chan error
+}
+
+func (h *closeRecorder) OnData([]byte) error { return nil }
+func (h *closeRecorder) OnClose(err error)   { h.closed <- err }
+
+// 被踢时客户端先收到CloseFrame, OnClose收到带原因的CloseError, 且只通知一次
+func TestTCPConnCloseWithReason(t *testing.T) {
+	saved := mgr
+	mgr = &Mgr{enableAcceptConn: true, enableAcceptMsg: true}
+	defer func() { mgr = saved }()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := NewTcpConn(server)
+	conn.config = defaultConfig()
+	conn.config.CloseFrame = func(reason CloseReason) []byte {
+		return []byte(reason)
+	}
+	handler := &closeRecorder{closed: make(chan error, 1)}
+	conn.delegate = handler
+	go conn.Start()
+
+	frames := make(chan []byte, 2)
+	go func() {
+		reader := bufio.NewReader(client)
+		for {
+			data, err := conn.config.Codec.ReadFrame(reader, MaxIncomingPacket)
+			if err != nil {
+				close(frames)
+				return
+			}
+			frames <- data
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		if _, ok := GetConn(conn.Id()); ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := Kick(conn.Id(), "test"); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	select {
+	case err = <-handler.closed:
+	case <-time.After(time.Second):
+		t.Fatal("OnClose not called")
+	}
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) || closeErr.Reason != CloseReasonKick {
+		t.Fatalf("unexpected close error %v", err)
+	}
+	var got []string
+	for data := range frames {
+		got = append(got, string(data))
+	}
+	if len(got) != 1 || got[0] != string(CloseReasonKick) {
+		t.Fatalf("unexpected close frames %q", got)
+	}
+	if stats := serverStatsOf(t, ProtocolTCP); stats.CloseReasons[CloseReasonKick] == 0 {
+		t.Fatalf("kick not counted %+v", stats)
+	}
+}
+
+// 对端不读时, Kick不等待CloseFrame写出, 写超时后连接仍会关闭
+func TestTCPConnCloseNotBlocking(t *testing.T) {
+	saved := mgr
+	mgr = &Mgr{enableAcceptConn: true, enableAcceptMsg: true}
+	defer func() { mgr = saved }()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := NewTcpConn(server)
+	conn.config = defaultConfig()
+	conn.config.WriteTimeout = time.Minute
+	conn.config.CloseFrame = func(reason CloseReason) []byte {
+		return []byte(reason)
+	}
+	handler := &closeRecorder{closed: make(chan error, 1)}
+	conn.delegate = handler
+	go conn.Start()
+	for i := 0; i < 100; i++ {
+		if _, ok := GetConn(conn.Id()); ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	start := time.Now()
+	if err := Kick(conn.Id(), "test"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > closeNotifyTimeout/2 {
+		t.Fatalf("Kick blocked for %v", elapsed)
+	}
+	select {
+	case err := <-handler.closed:
+		if reason := CloseReasonOf(err); reason != CloseReasonKick {
+			t.Fatalf("unexpected close reason %s", reason)
+		}
+	case <-time.After(2 * closeNotifyTimeout):
+		t.Fatal("close notice not bounded by closeNotifyTimeout")
+	}
+}
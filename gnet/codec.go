@@ -0,0 +1,181 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/mafei198/glib/packet"
+	"io"
+)
+
+var (
+	ErrFrameTooLarge = errors.New("frame too large")
+	ErrInvalidFrame  = errors.New("invalid frame")
+)
+
+type FrameReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// TCP连接的分帧方式
+type FrameCodec interface {
+	// 读取一帧数据, 超过maxSize时返回ErrFrameTooLarge
+	ReadFrame(r FrameReader, maxSize int) ([]byte, error)
+	// 将编码后的帧追加到dst
+	AppendFrame(dst, data []byte) ([]byte, error)
+}
+
+// 定长长度头 + 数据, 长度头宽度支持1/2/4/8字节
+type LengthPrefixCodec struct {
+	width int
+	order binary.ByteOrder
+}
+
+func NewLengthPrefixCodec(width int, order binary.ByteOrder) *LengthPrefixCodec {
+	switch width {
+	case 1, 2, 4, 8:
+	default:
+		panic(fmt.Sprintln("invalid length prefix width: ", width))
+	}
+	return &LengthPrefixCodec{width: width, order: order}
+}
+
+func (c *LengthPrefixCodec) ReadFrame(r FrameReader, maxSize int) ([]byte, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:c.width]); err != nil {
+		return nil, err
+	}
+	size := c.getSize(header[:c.width])
+	if size > uint64(maxSize) {
+		return nil, frameTooLarge(size)
+	}
+	return readBody(r, int(size))
+}
+
+func (c *LengthPrefixCodec) AppendFrame(dst, data []byte) ([]byte, error) {
+	size := uint64(len(data))
+	if c.width < 8 && size >= 1<<uint(c.width*8) {
+		return nil, frameTooLarge(size)
+	}
+	var header [8]byte
+	switch c.width {
+	case 1:
+		header[0] = byte(size)
+	case 2:
+		c.order.PutUint16(header[:], uint16(size))
+	case 4:
+		c.order.PutUint32(header[:], uint32(size))
+	case 8:
+		c.order.PutUint64(header[:], size)
+	}
+	dst = append(dst, header[:c.width]...)
+	return append(dst, data...), nil
+}
+
+func (c *LengthPrefixCodec) getSize(header []byte) uint64 {
+	switch c.width {
+	case 1:
+		return uint64(header[0])
+	case 2:
+		return uint64(c.order.Uint16(header))
+	case 4:
+		return uint64(c.order.Uint32(header))
+	default:
+		return c.order.Uint64(header)
+	}
+}
+
+// varint长度头 + 数据
+type VarintCodec struct{}
+
+func (c *VarintCodec) ReadFrame(r FrameReader, maxSize int) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if size > uint64(maxSize) {
+		return nil, frameTooLarge(size)
+	}
+	return readBody(r, int(size))
+}
+
+func (c *VarintCodec) AppendFrame(dst, data []byte) ([]byte, error) {
+	var header [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(header[:], uint64(len(data)))
+	dst = append(dst, header[:n]...)
+	return append(dst, data...), nil
+}
+
+// 以换行符分隔的文本帧, 帧内容不包含换行符
+type LineCodec struct{}
+
+func (c *LineCodec) ReadFrame(r FrameReader, maxSize int) ([]byte, error) {
+	line := make([]byte, 0, 64)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if b == '\n' {
+			return line, nil
+		}
+		if len(line) >= maxSize {
+			return nil, frameTooLarge(uint64(len(line) + 1))
+		}
+		line = append(line, b)
+	}
+}
+
+func (c *LineCodec) AppendFrame(dst, data []byte) ([]byte, error) {
+	if bytes.IndexByte(data, '\n') >= 0 {
+		return nil, ErrInvalidFrame
+	}
+	dst = append(dst, data...)
+	return append(dst, '\n'), nil
+}
+
+// 帧数据使用packet的buffer池, 可由ReleaseFrame归还
+func readBody(r io.Reader, size int) ([]byte, error) {
+	data := packet.GetBuffer(size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		packet.PutBuffer(data)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func frameTooLarge(size uint64) error {
+	return fmt.Errorf("%w: %d", ErrFrameTooLarge, size)
+}
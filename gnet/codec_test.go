@@ -0,0 +1,89 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestFrameCodec(t *testing.T) {
+	codecs := map[string]FrameCodec{
+		"prefix1": NewLengthPrefixCodec(1, binary.BigEndian),
+		"prefix2": NewLengthPrefixCodec(2, binary.LittleEndian),
+		"prefix4": NewLengthPrefixCodec(4, binary.BigEndian),
+		"prefix8": NewLengthPrefixCodec(8, binary.LittleEndian),
+		"varint":  &VarintCodec{},
+		"line":    &LineCodec{},
+	}
+	frames := [][]byte{[]byte("hello"), {}, bytes.Repeat([]byte{'x'}, 200)}
+	for name, codec := range codecs {
+		var stream []byte
+		var err error
+		for _, frame := range frames {
+			if stream, err = codec.AppendFrame(stream, frame); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+		reader := bufio.NewReader(bytes.NewReader(stream))
+		for i, want := range frames {
+			frame, err := codec.ReadFrame(reader, 256)
+			if err != nil || !bytes.Equal(frame, want) {
+				t.Fatalf("%s frame %d: got %q %v", name, i, frame, err)
+			}
+		}
+		if _, err := codec.ReadFrame(reader, 256); err != io.EOF {
+			t.Fatalf("%s: got %v at end of stream, want EOF", name, err)
+		}
+		// 超过maxSize的帧在读取数据前拒绝
+		reader = bufio.NewReader(bytes.NewReader(stream))
+		if _, err := codec.ReadFrame(reader, 4); !errors.Is(err, ErrFrameTooLarge) {
+			t.Fatalf("%s: got %v, want ErrFrameTooLarge", name, err)
+		}
+		// 截断的帧
+		reader = bufio.NewReader(bytes.NewReader(stream[:len(stream)-1]))
+		for {
+			if _, err = codec.ReadFrame(reader, 256); err != nil {
+				break
+			}
+		}
+		if err != io.ErrUnexpectedEOF {
+			t.Fatalf("%s: got %v for a truncated frame, want ErrUnexpectedEOF", name, err)
+		}
+	}
+}
+
+func TestFrameCodecInvalid(t *testing.T) {
+	if _, err := NewLengthPrefixCodec(1, binary.BigEndian).AppendFrame(nil, make([]byte, 256)); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("1-byte prefix: got %v, want ErrFrameTooLarge", err)
+	}
+	if _, err := (&LineCodec{}).AppendFrame(nil, []byte("a\nb")); err != ErrInvalidFrame {
+		t.Fatalf("line codec: got %v, want ErrInvalidFrame", err)
+	}
+}
@@ -0,0 +1,206 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/mafei198/glib/pool"
+	"net"
+	"runtime"
+	"time"
+)
+
+type Config struct {
+	// 超过该时间未收到任何数据(包括心跳)视为空闲, 触发OnIdle并断开
+	IdleTimeout time.Duration
+	// 单次发送超时, 0表示不限制
+	WriteTimeout time.Duration
+	// 服务器主动发送ping的间隔, 0表示不发送
+	HeartbeatInterval time.Duration
+	// TCP连接启用0长度帧作为ping, 收到的0长度帧只刷新空闲时间, 不会交给OnData
+	TcpPing bool
+
+	// 单连接每秒消息数/字节数限制, 0表示不限制, Burst小于速率时取速率值
+	MsgPerSecond   int
+	MsgBurst       int
+	BytesPerSecond int
+	BytesBurst     int
+	// 超出限流时的处理, 为nil时断开连接
+	OnRateLimited func(conn Conn) RateLimitAction
+
+	// 全局连接数上限及单IP连接数上限, 0表示不限制
+	MaxConns      int
+	MaxConnsPerIP int
+
+	// TCP连接先解析HAProxy PROXY协议v1/v2头, 以获取真实客户端地址
+	ProxyProtocol bool
+	// 可信代理的IP或CIDR, 来自这些地址的WebSocket请求使用X-Forwarded-For作为客户端地址
+	TrustedProxies []string
+
+	// TCP分帧方式, 默认4字节大端长度头
+	Codec FrameCodec
+	// 接收单帧的最大长度, 默认MaxIncomingPacket
+	MaxFrameSize int
+
+	// 超过CompressThreshold字节的消息使用该方式压缩
+	Compression       Compression
+	CompressThreshold int
+	// 超过ChunkSize字节的消息拆分为多帧发送, 对端收齐后作为一条消息交给OnData, 0表示不拆分.
+	// 收发双方须同时设置, 超过MaxFrameSize减去分片头部的长度时自动调小
+	ChunkSize int
+	// 分片重组后单条消息的最大长度, 默认DefaultMaxMessageSize
+	MaxMessageSize int
+	// 不为nil时, 连接的第一帧用于密钥交换, 之后的帧均使用AES-GCM加密
+	KeyExchange KeyExchanger
+	// Dial使用, 不为nil时连接建立后先与服务器完成密钥交换
+	ClientKeyExchange func() ClientKeyExchanger
+
+	// 不为nil时连接须先通过认证, 才会创建ConnHandler
+	Authenticator Authenticator
+	// 认证期限, 默认DefaultAuthTimeout
+	AuthTimeout time.Duration
+
+	// WebSocket相关配置
+	WS *WSConfig
+	// HTTP/JSON网关相关配置
+	HTTP *HTTPConfig
+
+	// OnData的执行方式, 默认DispatchInline
+	Dispatch DispatchMode
+	// 每个连接待处理消息的上限, 默认DefaultDispatchQueueLen
+	DispatchQueueLen int
+	// DispatchPool模式使用的pool, 须由NewDispatchPool创建
+	Pool *pool.Pool
+	// DispatchGenServer模式使用的GenServer名字, 可由StartDispatchServer启动
+	GenServer string
+
+	// epoll事件循环数, 默认runtime.NumCPU()
+	EpollLoops int
+
+	// RUDP同时存在的会话数上限, 默认DefaultMaxRUDPSessions
+	MaxRUDPSessions int
+
+	// 服务器断开连接前发送该函数返回的数据作为最后一帧, 返回nil不发送.
+	// WebSocket连接另外会发送带状态码的close控制帧
+	CloseFrame func(reason CloseReason) []byte
+
+	// 不为nil时可录制连接收发的帧, 用于重放复现问题
+	Capture *CaptureConfig
+
+	// 周期输出网络状态日志的间隔, 0不输出
+	StatusLogInterval time.Duration
+
+	trustedProxies []*net.IPNet
+}
+
+// IdleHandler ConnHandler可选实现, 连接空闲超时断开前回调
+type IdleHandler interface {
+	OnIdle()
+}
+
+func defaultConfig() *Config {
+	config := &Config{}
+	_ = config.init()
+	return config
+}
+
+// 复制Config及其中的WS/HTTP配置, init不会修改调用方传入的Config
+func (c *Config) clone() *Config {
+	config := *c
+	if c.WS != nil {
+		wsConfig := *c.WS
+		config.WS = &wsConfig
+	}
+	if c.HTTP != nil {
+		httpConfig := *c.HTTP
+		config.HTTP = &httpConfig
+	}
+	return &config
+}
+
+func (c *Config) init() error {
+	if c.IdleTimeout <= 0 {
+		c.IdleTimeout = ReadTimeout
+	}
+	if c.Codec == nil {
+		c.Codec = NewLengthPrefixCodec(Packet, binary.BigEndian)
+	}
+	if c.MaxFrameSize <= 0 {
+		c.MaxFrameSize = MaxIncomingPacket
+	}
+	if c.ChunkSize > 0 {
+		if c.MaxMessageSize <= 0 {
+			c.MaxMessageSize = DefaultMaxMessageSize
+		}
+		if c.ChunkSize > c.MaxFrameSize-chunkOverhead {
+			c.ChunkSize = c.MaxFrameSize - chunkOverhead
+		}
+		if c.ChunkSize <= 0 {
+			return errors.New("gnet: MaxFrameSize too small for ChunkSize")
+		}
+	}
+	if c.DispatchQueueLen <= 0 {
+		c.DispatchQueueLen = DefaultDispatchQueueLen
+	}
+	if c.AuthTimeout <= 0 {
+		c.AuthTimeout = DefaultAuthTimeout
+	}
+	if c.EpollLoops <= 0 {
+		c.EpollLoops = runtime.NumCPU()
+	}
+	if c.MaxRUDPSessions <= 0 {
+		c.MaxRUDPSessions = DefaultMaxRUDPSessions
+	}
+	if c.Dispatch == DispatchPool && c.Pool == nil {
+		return errors.New("gnet: DispatchPool requires Config.Pool")
+	}
+	if c.WS == nil {
+		c.WS = &WSConfig{}
+	}
+	c.WS.init(c.MaxFrameSize)
+	if c.HTTP == nil {
+		c.HTTP = &HTTPConfig{}
+	}
+	c.HTTP.init(c.MaxFrameSize)
+	nets, err := parseTrustedProxies(c.TrustedProxies)
+	if err != nil {
+		return err
+	}
+	c.trustedProxies = nets
+	return nil
+}
+
+func getConfig() *Config {
+	if mgr == nil || mgr.config == nil {
+		return defaultConfig()
+	}
+	return mgr.config
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
@@ -0,0 +1,303 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mafei198/glib/gen_server"
+	"github.com/mafei198/glib/logger"
+	"github.com/mafei198/glib/pool"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+type DispatchMode int
+
+const (
+	DispatchInline DispatchMode = iota // 在读goroutine中直接调用OnData
+	DispatchQueue                      // 每个连接一个处理goroutine
+	DispatchPool                       // 交给Config.Pool处理, 同一连接的消息依次处理
+	// Cast给名为Config.GenServer的GenServer处理, 所有连接的消息由同一个GenServer的goroutine依次处理,
+	// 邮箱容量为gen_server.MsgChannelLen(1024), 吞吐受限于单核, 只适合消息量小且需要全局串行的场景,
+	// 高负载下应使用DispatchPool或DispatchQueue
+	DispatchGenServer
+)
+
+// DispatchQueue模式下每个连接的默认队列长度
+const DefaultDispatchQueueLen = 256
+
+// Pool/GenServer邮箱满时的重试间隔, 每次翻倍
+const (
+	minDispatchRetry = 10 * time.Millisecond
+	maxDispatchRetry = 160 * time.Millisecond
+)
+
+var (
+	ErrDispatchQueueFull = errors.New("dispatch queue full")
+	ErrDispatchPanic     = errors.New("dispatch handler panic")
+)
+
+// 交给Pool或GenServer执行的任务
+type DispatchTask struct {
+	queue *dispatchQueue
+}
+
+func (t *DispatchTask) Run() {
+	t.queue.drain()
+}
+
+// 用于创建DispatchPool模式使用的pool.Pool
+func DispatchTaskHandler(msg interface{}) (interface{}, error) {
+	msg.(*DispatchTask).Run()
+	return nil, nil
+}
+
+func NewDispatchPool(size int) (*pool.Pool, error) {
+	return pool.New(size, DispatchTaskHandler)
+}
+
+// DispatchGenServer模式使用的GenServer, 也可在自定义GenServer的HandleCast中调用DispatchTask.Run
+type DispatchServer struct{}
+
+func StartDispatchServer(name string) (*gen_server.GenServer, error) {
+	return gen_server.Start(name, &DispatchServer{})
+}
+
+func (s *DispatchServer) Init([]interface{}) error {
+	return nil
+}
+
+func (s *DispatchServer) HandleCast(req *gen_server.Request) {
+	if task, ok := req.Msg.(*DispatchTask); ok {
+		task.Run()
+	}
+}
+
+func (s *DispatchServer) HandleCall(*gen_server.Request) (interface{}, error) {
+	return nil, nil
+}
+
+func (s *DispatchServer) Terminate(string) error {
+	return nil
+}
+
+// 按Config.Dispatch包装ConnHandler, 非Inline模式下OnData/OnIdle/OnClose按顺序异步执行
+func wrapDispatch(config *Config, conn Conn, handler ConnHandler) ConnHandler {
+	if config.Dispatch == DispatchInline {
+		return handler
+	}
+	queue := &dispatchQueue{
+		config:  config,
+		conn:    conn,
+		handler: handler,
+	}
+	if config.Dispatch == DispatchQueue {
+		queue.ch = make(chan *dispatchItem, config.DispatchQueueLen)
+		go queue.loop()
+	}
+	return queue
+}
+
+type dispatchItem struct {
+	fn func() error
+	// 连接出错后仍需执行, 用于OnClose
+	force bool
+}
+
+type dispatchQueue struct {
+	config  *Config
+	conn    Conn
+	handler ConnHandler
+	ch      chan *dispatchItem
+	mutex   sync.Mutex
+	items   []*dispatchItem
+	running bool
+	err     error
+}
+
+func (q *dispatchQueue) OnData(data []byte) error {
+	if err := q.getErr(); err != nil {
+		return err
+	}
+	return q.push(&dispatchItem{fn: func() error {
+		return q.handler.OnData(data)
+	}})
+}
+
+func (q *dispatchQueue) OnIdle() {
+	if handler, ok := q.handler.(IdleHandler); ok {
+		_ = q.push(&dispatchItem{fn: func() error {
+			handler.OnIdle()
+			return nil
+		}})
+	}
+}
+
+// OnClose排在所有消息之后执行, 队列满时也要保证送达
+func (q *dispatchQueue) OnClose(err error) {
+	closeTask := &dispatchItem{force: true, fn: func() error {
+		q.handler.OnClose(err)
+		return nil
+	}}
+	if q.ch != nil {
+		go func() {
+			q.ch <- closeTask
+			close(q.ch)
+		}()
+		return
+	}
+	q.mutex.Lock()
+	q.items = append(q.items, closeTask)
+	q.mutex.Unlock()
+	q.schedule()
+}
+
+func (q *dispatchQueue) push(task *dispatchItem) error {
+	if q.ch != nil {
+		select {
+		case q.ch <- task:
+			return nil
+		default:
+			return ErrDispatchQueueFull
+		}
+	}
+	q.mutex.Lock()
+	if len(q.items) >= q.config.DispatchQueueLen {
+		q.mutex.Unlock()
+		return ErrDispatchQueueFull
+	}
+	q.items = append(q.items, task)
+	q.mutex.Unlock()
+	q.schedule()
+	return nil
+}
+
+// 同一连接同时只有一个任务在Pool/GenServer中执行
+func (q *dispatchQueue) schedule() {
+	q.mutex.Lock()
+	if q.running {
+		q.mutex.Unlock()
+		return
+	}
+	q.running = true
+	q.mutex.Unlock()
+
+	if err := q.cast(); err != nil {
+		if q.closing() {
+			// 连接已断开, 剩余任务及OnClose在新goroutine执行, 不占用调用方(可能是epoll事件循环)
+			go q.drain()
+			return
+		}
+		go q.retry(err)
+	}
+}
+
+func (q *dispatchQueue) cast() error {
+	task := &DispatchTask{queue: q}
+	switch q.config.Dispatch {
+	case DispatchPool:
+		return q.config.Pool.Cast(task)
+	case DispatchGenServer:
+		return gen_server.Cast(q.config.GenServer, task)
+	}
+	return nil
+}
+
+// 队列中是否已有OnClose
+func (q *dispatchQueue) closing() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.items) > 0 && q.items[len(q.items)-1].force
+}
+
+// 退避重试, 仍失败时断开连接, 并在当前goroutine执行剩余任务, 保证OnClose送达
+func (q *dispatchQueue) retry(err error) {
+	for delay := minDispatchRetry; delay <= maxDispatchRetry; delay *= 2 {
+		time.Sleep(delay)
+		if err = q.cast(); err == nil {
+			return
+		}
+	}
+	logger.ERR("dispatch cast failed: ", err)
+	q.mutex.Lock()
+	if q.err == nil {
+		q.err = err
+	}
+	q.mutex.Unlock()
+	_ = CloseConn(q.conn, CloseReasonError, err.Error())
+	q.drain()
+}
+
+func (q *dispatchQueue) drain() {
+	for {
+		q.mutex.Lock()
+		if len(q.items) == 0 {
+			q.running = false
+			q.mutex.Unlock()
+			return
+		}
+		task := q.items[0]
+		q.items = q.items[1:]
+		q.mutex.Unlock()
+		q.run(task)
+	}
+}
+
+func (q *dispatchQueue) loop() {
+	for task := range q.ch {
+		q.run(task)
+	}
+}
+
+// 处理出错时断开连接, 之后的消息不再处理
+func (q *dispatchQueue) run(task *dispatchItem) {
+	if !task.force && q.getErr() != nil {
+		return
+	}
+	if err := q.safeRun(task.fn); err != nil && !task.force {
+		q.mutex.Lock()
+		q.err = err
+		q.mutex.Unlock()
+		_ = CloseConn(q.conn, CloseReasonError, err.Error())
+	}
+}
+
+func (q *dispatchQueue) safeRun(task func() error) (err error) {
+	defer func() {
+		if x := recover(); x != nil {
+			logger.ERR("dispatch caught panic: ", x, "\n", string(debug.Stack()))
+			err = fmt.Errorf("%w: %v", ErrDispatchPanic, x)
+		}
+	}()
+	return task()
+}
+
+func (q *dispatchQueue) getErr() error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.err
+}
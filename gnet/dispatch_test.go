@@ -0,0 +1,91 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type countHandler struct {
+	mutex  sync.Mutex
+	data   int
+	closed int
+}
+
+func (h *countHandler) OnData([]byte) error {
+	h.mutex.Lock()
+	h.data++
+	h.mutex.Unlock()
+	return nil
+}
+
+func (h *countHandler) OnClose(error) {
+	h.mutex.Lock()
+	h.closed++
+	h.mutex.Unlock()
+}
+
+func (h *countHandler) counts() (int, int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.data, h.closed
+}
+
+// 等待OnClose执行, 返回此时的计数
+func (h *countHandler) waitClosed(t *testing.T) (int, int) {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if data, closed := h.counts(); closed > 0 {
+			return data, closed
+		}
+	}
+	t.Fatal("OnClose not run")
+	return 0, 0
+}
+
+// GenServer不存在时Cast总是失败, OnClose仍须在另一goroutine执行
+func TestDispatchCastFailRunsOnClose(t *testing.T) {
+	config := &Config{Dispatch: DispatchGenServer, GenServer: "gnet_test_missing"}
+	_ = config.init()
+	handler := &countHandler{}
+	wrapped := wrapDispatch(config, newFakeConn(), handler)
+	wrapped.OnClose(nil)
+	if _, closed := handler.waitClosed(t); closed != 1 {
+		t.Fatal("OnClose not run when cast fails")
+	}
+
+	handler = &countHandler{}
+	wrapped = wrapDispatch(config, newFakeConn(), handler)
+	if err := wrapped.OnData(nil); err != nil {
+		t.Fatal(err)
+	}
+	// 重试全部失败后断开连接, 未执行的消息丢弃
+	time.Sleep(4 * maxDispatchRetry)
+	wrapped.OnClose(nil)
+	if data, closed := handler.waitClosed(t); data != 0 || closed != 1 {
+		t.Fatalf("unexpected counts data=%d closed=%d", data, closed)
+	}
+}
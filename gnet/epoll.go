@@ -0,0 +1,154 @@
+//go:build linux
+// +build linux
+
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"github.com/mafei198/glib/logger"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 检查空闲超时及发送心跳的间隔
+var epollSweepInterval = time.Second
+
+// 单个epoll事件循环, 连接的读取、分帧及OnData均在循环goroutine中执行
+type epollLoop struct {
+	epfd   int
+	wake   [2]int // 用于唤醒epoll_wait的管道
+	mutex  sync.Mutex
+	conns  map[int]*EpollConn
+	buffer []byte
+	done   chan struct{}
+}
+
+func newEpollLoop() (*epollLoop, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	l := &epollLoop{
+		epfd:   epfd,
+		conns:  map[int]*EpollConn{},
+		buffer: make([]byte, MaxIncomingPacket+1),
+		done:   make(chan struct{}),
+	}
+	if err = syscall.Pipe2(l.wake[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+		_ = syscall.Close(epfd)
+		return nil, err
+	}
+	event := &syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(l.wake[0])}
+	if err = syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, l.wake[0], event); err != nil {
+		l.closeFds()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *epollLoop) closeFds() {
+	_ = syscall.Close(l.wake[0])
+	_ = syscall.Close(l.wake[1])
+	_ = syscall.Close(l.epfd)
+}
+
+func (l *epollLoop) add(c *EpollConn) error {
+	l.mutex.Lock()
+	l.conns[c.fd] = c
+	l.mutex.Unlock()
+	event := &syscall.EpollEvent{Events: syscall.EPOLLIN | syscall.EPOLLRDHUP, Fd: int32(c.fd)}
+	if err := syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_ADD, c.fd, event); err != nil {
+		l.del(c)
+		return err
+	}
+	return nil
+}
+
+// 须在关闭fd前调用, 关闭后fd可能被新连接复用
+func (l *epollLoop) del(c *EpollConn) {
+	_ = syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_DEL, c.fd, nil)
+	l.mutex.Lock()
+	if l.conns[c.fd] == c {
+		delete(l.conns, c.fd)
+	}
+	l.mutex.Unlock()
+}
+
+func (l *epollLoop) get(fd int) *EpollConn {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.conns[fd]
+}
+
+func (l *epollLoop) snapshot() []*EpollConn {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	conns := make([]*EpollConn, 0, len(l.conns))
+	for _, c := range l.conns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+func (l *epollLoop) run() {
+	defer close(l.done)
+	defer l.closeFds()
+	events := make([]syscall.EpollEvent, 256)
+	timeout := int(epollSweepInterval / time.Millisecond)
+	lastSweep := time.Now()
+	for {
+		n, err := syscall.EpollWait(l.epfd, events, timeout)
+		if err != nil && err != syscall.EINTR {
+			logger.ERR("epoll wait failed: ", err)
+			return
+		}
+		for i := 0; i < n; i++ {
+			fd := int(events[i].Fd)
+			if fd == l.wake[0] {
+				for _, c := range l.snapshot() {
+					c.teardown(ErrShutdown)
+				}
+				return
+			}
+			if c := l.get(fd); c != nil {
+				c.onReadable(l.buffer)
+			}
+		}
+		if now := time.Now(); now.Sub(lastSweep) >= epollSweepInterval {
+			lastSweep = now
+			for _, c := range l.snapshot() {
+				c.sweep(now)
+			}
+		}
+	}
+}
+
+// 断开所有连接并退出事件循环, 等待退出后返回
+func (l *epollLoop) stop() {
+	_, _ = syscall.Write(l.wake[1], []byte{0})
+	<-l.done
+}
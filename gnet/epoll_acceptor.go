@@ -0,0 +1,396 @@
+//go:build linux
+// +build linux
+
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"bytes"
+	"errors"
+	"github.com/mafei198/glib/logger"
+	"github.com/mafei198/glib/packet"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// EpollAcceptor 由少量epoll事件循环读取所有连接, 空闲连接不占用goroutine.
+// OnData在事件循环中执行, 处理耗时的handler应配合Config.Dispatch使用
+type EpollAcceptor struct {
+	listener   net.Listener
+	factory    HandlerFactory
+	loops      []*epollLoop
+	next       uint32
+	closed     chan struct{}
+	acceptDone chan struct{}
+}
+
+func init() {
+	RegisterAcceptors(ProtocolEpoll, &EpollAcceptor{})
+}
+
+func (acceptor *EpollAcceptor) Start(port string, factory HandlerFactory) error {
+	acceptor.factory = factory
+	config := getConfig()
+	loops := make([]*epollLoop, 0, config.EpollLoops)
+	for i := 0; i < config.EpollLoops; i++ {
+		loop, err := newEpollLoop()
+		if err != nil {
+			for _, loop := range loops {
+				loop.closeFds()
+			}
+			return err
+		}
+		loops = append(loops, loop)
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort("", port))
+	if err != nil {
+		for _, loop := range loops {
+			loop.closeFds()
+		}
+		return err
+	}
+	acceptor.listener = listener
+	acceptor.loops = loops
+	acceptor.closed = make(chan struct{})
+	acceptor.acceptDone = make(chan struct{})
+	for _, loop := range loops {
+		go loop.run()
+	}
+
+	go acceptor.startAcceptLoop()
+
+	return nil
+}
+
+func (acceptor *EpollAcceptor) PrintInfo() {
+	AgentPort = strconv.Itoa(acceptor.listener.Addr().(*net.TCPAddr).Port)
+	logger.INFO("EpollAgent lis: ", AgentPort)
+}
+
+// Close 停止accept并断开所有连接, 事件循环退出后返回
+func (acceptor *EpollAcceptor) Close() error {
+	close(acceptor.closed)
+	err := acceptor.listener.Close()
+	<-acceptor.acceptDone
+	for _, loop := range acceptor.loops {
+		loop.stop()
+	}
+	return err
+}
+
+func (acceptor *EpollAcceptor) isClosed() bool {
+	select {
+	case <-acceptor.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (acceptor *EpollAcceptor) startAcceptLoop() {
+	logger.INFO("Game EpollConn started!")
+	defer close(acceptor.acceptDone)
+	var delay time.Duration
+	for {
+		conn, err := acceptor.listener.Accept()
+		if err != nil {
+			if acceptor.isClosed() || !mgr.enableAcceptConn {
+				break
+			}
+			delay = acceptBackoff(delay)
+			logger.ERR("EpollAcceptor accept failed: ", err, ", retrying in ", delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+
+		if !mgr.enableAcceptConn {
+			_ = conn.Close()
+			break
+		}
+
+		// 读取PROXY头部可能阻塞, 不能占用accept循环
+		go acceptor.handleConn(conn)
+	}
+
+	_ = acceptor.listener.Close()
+}
+
+func (acceptor *EpollAcceptor) handleConn(conn net.Conn) {
+	conn, ip, ok := admitConn(getConfig(), conn)
+	if !ok {
+		return
+	}
+	loop := acceptor.loops[atomic.AddUint32(&acceptor.next, 1)%uint32(len(acceptor.loops))]
+	epollConn, err := newEpollConn(loop, conn, ip)
+	if err != nil {
+		logger.ERR("EpollAcceptor init conn failed: ", err)
+		connLimits.release(ip)
+		_ = conn.Close()
+		return
+	}
+	epollConn.tcp.delegate = newConnHandler(epollConn.tcp.config, epollConn, acceptor.factory)
+	epollConn.start()
+}
+
+// EpollConn 由epoll事件循环驱动的TCP连接, 发送逻辑与TCPConn相同
+type EpollConn struct {
+	tcp      *TCPConn
+	loop     *epollLoop
+	sock     *net.TCPConn
+	raw      syscall.RawConn
+	fd       int
+	ip       string
+	ping     []byte
+	inbound  []byte
+	reader   bytes.Reader
+	lastRecv time.Time
+	lastPing time.Time
+}
+
+func newEpollConn(loop *epollLoop, conn net.Conn, ip string) (*EpollConn, error) {
+	c := &EpollConn{
+		tcp:      newTCPConn(conn, ProtocolEpoll),
+		loop:     loop,
+		ip:       ip,
+		lastRecv: time.Now(),
+		lastPing: time.Now(),
+	}
+	// 读取PROXY头部时多读的数据
+	if pc, ok := conn.(*proxyConn); ok {
+		conn = pc.Conn
+		if n := pc.reader.Buffered(); n > 0 {
+			buffered, _ := pc.reader.Peek(n)
+			c.appendInbound(buffered)
+		}
+	}
+	sock, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil, errors.New("epoll: not a tcp conn")
+	}
+	raw, err := sock.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	if err = raw.Control(func(fd uintptr) {
+		c.fd = int(fd)
+	}); err != nil {
+		return nil, err
+	}
+	if c.tcp.config.HeartbeatInterval > 0 && c.tcp.config.TcpPing {
+		if c.ping, err = c.tcp.config.Codec.AppendFrame(nil, nil); err != nil {
+			return nil, err
+		}
+	}
+	c.sock = sock
+	c.raw = raw
+	return c, nil
+}
+
+func (c *EpollConn) Id() int64 {
+	return c.tcp.id
+}
+
+func (c *EpollConn) LocalAddr() net.Addr {
+	return c.tcp.LocalAddr()
+}
+
+func (c *EpollConn) RemoteAddr() net.Addr {
+	return c.tcp.RemoteAddr()
+}
+
+func (c *EpollConn) SendData(data []byte) error {
+	return c.tcp.SendData(data)
+}
+
+func (c *EpollConn) Close(reason string) error {
+	return c.CloseWithReason(CloseReasonClosed, reason)
+}
+
+// 只关闭读端, 事件循环读到EOF后完成清理并回调OnClose
+func (c *EpollConn) CloseWithReason(reason CloseReason, msg string) error {
+	logger.WARN("epoll_conn disconnected: ", reason, " ", msg)
+	if c.tcp.closer.set(reason) && closeFrame(c.tcp.config, reason) != nil {
+		go func() {
+			c.tcp.notifyClose(reason)
+			_ = c.sock.CloseRead()
+		}()
+		return nil
+	}
+	return c.sock.CloseRead()
+}
+
+// 连接统计快照
+func (c *EpollConn) Stats() *ConnStats {
+	return c.tcp.Stats()
+}
+
+func (c *EpollConn) captureState() *connCapture {
+	return c.tcp.capture
+}
+
+func (c *EpollConn) start() {
+	atomic.AddInt32(&OnlinePlayers, 1)
+	c.tcp.stats.connect()
+	registry.add(c)
+	c.tcp.capture.open(c)
+	// 处理PROXY头部之后已到达的数据, 此时还未加入事件循环
+	if len(c.inbound) > 0 {
+		if err := c.process(); err != nil {
+			c.teardown(err)
+			return
+		}
+	}
+	if err := c.loop.add(c); err != nil {
+		c.teardown(err)
+	}
+}
+
+// 由事件循环调用, 每次最多读取len(buffer)字节, 剩余数据在下一轮事件中读取
+func (c *EpollConn) onReadable(buffer []byte) {
+	var n int
+	var readErr error
+	err := c.raw.Read(func(fd uintptr) bool {
+		n, readErr = syscall.Read(int(fd), buffer)
+		return true
+	})
+	if err == nil {
+		err = readErr
+	}
+	if err == syscall.EAGAIN || err == syscall.EINTR {
+		return
+	}
+	if err == nil && n == 0 {
+		err = io.EOF
+	}
+	if err != nil {
+		c.teardown(err)
+		return
+	}
+	c.lastRecv = time.Now()
+	c.appendInbound(buffer[:n])
+	if err = c.process(); err != nil {
+		c.teardown(err)
+	}
+}
+
+// 从已读取的数据中解出完整的帧, 不完整的帧留待下次读取
+func (c *EpollConn) process() error {
+	config := c.tcp.config
+	for len(c.inbound) > 0 {
+		if !mgr.enableAcceptMsg {
+			return ErrShutdown
+		}
+		c.reader.Reset(c.inbound)
+		data, err := config.Codec.ReadFrame(&c.reader, config.MaxFrameSize)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		c.inbound = c.inbound[len(c.inbound)-c.reader.Len():]
+		if err = c.tcp.handleFrame(c, data); err != nil {
+			return err
+		}
+	}
+	c.compactInbound()
+	return nil
+}
+
+// 读缓冲使用packet的buffer池
+func (c *EpollConn) appendInbound(data []byte) {
+	if cap(c.inbound)-len(c.inbound) < len(data) {
+		grown := packet.GetBuffer(len(c.inbound) + len(data))[:len(c.inbound)]
+		copy(grown, c.inbound)
+		c.releaseInbound()
+		c.inbound = grown
+	}
+	c.inbound = append(c.inbound, data...)
+}
+
+// 数据全部处理完时归还读缓冲, 空闲连接不占用内存
+func (c *EpollConn) compactInbound() {
+	if len(c.inbound) == 0 {
+		c.releaseInbound()
+		return
+	}
+	remain := packet.GetBuffer(len(c.inbound))
+	copy(remain, c.inbound)
+	c.releaseInbound()
+	c.inbound = remain
+}
+
+func (c *EpollConn) releaseInbound() {
+	if c.inbound != nil {
+		packet.PutBuffer(c.inbound)
+		c.inbound = nil
+	}
+}
+
+// 由事件循环定时调用, 检查空闲超时并发送心跳
+func (c *EpollConn) sweep(now time.Time) {
+	if !mgr.enableAcceptMsg {
+		c.teardown(ErrShutdown)
+		return
+	}
+	if now.Sub(c.lastRecv) >= c.tcp.config.IdleTimeout {
+		c.tcp.onIdle()
+		c.teardown(ErrIdleTimeout)
+		return
+	}
+	if c.ping != nil && now.Sub(c.lastPing) >= c.tcp.config.HeartbeatInterval {
+		c.lastPing = now
+		// 写可能阻塞, 不能占用事件循环
+		go func() {
+			if err := c.tcp.write(c.ping); err != nil {
+				logger.WARN("epoll_conn send ping failed: ", err)
+			}
+		}()
+	}
+}
+
+// 在事件循环中执行, 移出事件循环后关闭连接并回调OnClose
+func (c *EpollConn) teardown(err error) {
+	c.loop.del(c)
+	closeErr, notify := c.tcp.closer.done(err)
+	if notify {
+		c.tcp.notifyClose(closeErr.Reason)
+	}
+	_ = c.tcp.conn.Close()
+	close(c.tcp.closed)
+	c.releaseInbound()
+	registry.del(c.Id())
+	atomic.AddInt32(&OnlinePlayers, -1)
+	connLimits.release(c.ip)
+	c.tcp.stats.disconnect(closeErr)
+	c.tcp.capture.close(closeErr.Reason)
+	c.tcp.onClose(closeErr)
+}
@@ -0,0 +1,196 @@
+//go:build linux
+// +build linux
+
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// 原样返回收到的数据
+type echoHandler struct {
+	conn   Conn
+	closed chan error
+}
+
+func (h *echoHandler) OnData(data []byte) error {
+	err := h.conn.SendData(data)
+	ReleaseFrame(data)
+	return err
+}
+
+func (h *echoHandler) OnClose(err error) {
+	if h.closed != nil {
+		h.closed <- err
+	}
+}
+
+// 启动acceptor, 返回监听地址及停止函数
+func startTestAcceptor(tb testing.TB, acceptor Acceptor, factory HandlerFactory) (string, func()) {
+	saved := mgr
+	mgr = &Mgr{config: defaultConfig(), enableAcceptConn: true, enableAcceptMsg: true}
+	if err := acceptor.Start("0", factory); err != nil {
+		tb.Fatal(err)
+	}
+	switch a := acceptor.(type) {
+	case *TcpAcceptor:
+		// TcpAcceptor不能停止, 连接随客户端关闭退出
+		return a.listener.Addr().String(), func() {}
+	case *EpollAcceptor:
+		return a.listener.Addr().String(), func() {
+			_ = a.Close()
+			mgr = saved
+		}
+	case *RUDPAcceptor:
+		return a.conn.LocalAddr().String(), func() {
+			_ = a.Close()
+			mgr = saved
+		}
+	}
+	tb.Fatalf("unexpected acceptor %T", acceptor)
+	return "", nil
+}
+
+func TestEpollAcceptorEcho(t *testing.T) {
+	handlers := make(chan *echoHandler, 1)
+	addr, stop := startTestAcceptor(t, &EpollAcceptor{}, func(conn Conn) ConnHandler {
+		h := &echoHandler{conn: conn, closed: make(chan error, 1)}
+		handlers <- h
+		return h
+	})
+	defer stop()
+	client, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	codec := NewLengthPrefixCodec(Packet, binary.BigEndian)
+	var stream []byte
+	for _, msg := range []string{"hello", "epoll", ""} {
+		stream, _ = codec.AppendFrame(stream, []byte(msg))
+	}
+	// 分多次写入, 帧头和帧体被拆开
+	for _, chunk := range [][]byte{stream[:2], stream[2:7], stream[7:]} {
+		if _, err := client.Write(chunk); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	reader := bufio.NewReader(client)
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	for _, want := range []string{"hello", "epoll", ""} {
+		data, err := codec.ReadFrame(reader, MaxIncomingPacket)
+		if err != nil || string(data) != want {
+			t.Fatalf("got %q, %v, want %q", data, err, want)
+		}
+	}
+
+	h := <-handlers
+	if err := Kick(h.conn.Id(), "test"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err = <-h.closed:
+	case <-time.After(time.Second):
+		t.Fatal("OnClose not called")
+	}
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) || closeErr.Reason != CloseReasonKick {
+		t.Fatalf("unexpected close error %v", err)
+	}
+	if _, err := codec.ReadFrame(reader, MaxIncomingPacket); err != io.EOF {
+		t.Fatalf("client not closed: %v", err)
+	}
+	if _, ok := GetConn(h.conn.Id()); ok {
+		t.Fatal("conn still registered")
+	}
+}
+
+// 建立conns个连接后对比每连接的goroutine数和内存占用, 再测量echo的吞吐
+func benchmarkAcceptor(b *testing.B, acceptor Acceptor, conns int) {
+	addr, stop := startTestAcceptor(b, acceptor, func(conn Conn) ConnHandler {
+		return &echoHandler{conn: conn}
+	})
+	defer stop()
+	var before runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	goroutines := runtime.NumGoroutine()
+
+	clients := make([]net.Conn, conns)
+	readers := make([]*bufio.Reader, conns)
+	for i := range clients {
+		client, err := net.Dial("tcp", addr)
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer client.Close()
+		clients[i] = client
+		readers[i] = bufio.NewReader(client)
+	}
+	for i := 0; i < 100 && runtime.NumGoroutine()-goroutines < conns; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	var after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	goroutinesPerConn := float64(runtime.NumGoroutine()-goroutines) / float64(conns)
+	heapPerConn := float64(int64(after.HeapInuse)-int64(before.HeapInuse)) / float64(conns)
+
+	codec := NewLengthPrefixCodec(Packet, binary.BigEndian)
+	frame, _ := codec.AppendFrame(nil, make([]byte, 256))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		j := i % conns
+		if _, err := clients[j].Write(frame); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := codec.ReadFrame(readers[j], MaxIncomingPacket); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	b.ReportMetric(goroutinesPerConn, "goroutines/conn")
+	b.ReportMetric(heapPerConn, "heap-B/conn")
+}
+
+func BenchmarkTcpAcceptor(b *testing.B) {
+	benchmarkAcceptor(b, &TcpAcceptor{}, 1000)
+}
+
+func BenchmarkEpollAcceptor(b *testing.B) {
+	benchmarkAcceptor(b, &EpollAcceptor{}, 1000)
+}
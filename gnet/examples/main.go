@@ -33,39 +33,56 @@ import (
 type Agent struct {
 	uuid        string
 	conn        gnet.Conn
-	authed      bool
 	accountId   string
 	closed      bool
 	closeReason error
 }
 
+// 第一帧为accountId
+type accountAuthenticator struct{}
+
+func (accountAuthenticator) Authenticate(conn gnet.Conn, data []byte) (*gnet.Identity, error) {
+	// TODO verify token
+	logger.INFO("auth connection")
+	return &gnet.Identity{Key: string(data)}, nil
+}
+
 func main() {
-	gnet.Start(gnet.ProtocolTCP, "3000", NewAgent)
+	_, err := gnet.StartE(gnet.ProtocolTCP, "3000", NewAgent, &gnet.Config{
+		Authenticator: accountAuthenticator{},
+	})
+	if err != nil {
+		logger.ERR("start net server failed: ", err)
+		return
+	}
 	logger.INFO("Agent started!")
 	misc.WaitForStopSignal(func() {
 		logger.INFO("Shutting down net server...")
 	})
 }
 
+// 认证通过后才创建Agent
 func NewAgent(conn gnet.Conn) gnet.ConnHandler {
-	return &Agent{
+	agent := &Agent{
 		uuid: xid.New().String(),
 		conn: conn,
 	}
+	if identity, ok := gnet.GetIdentity(conn.Id()); ok {
+		agent.accountId = identity.Key
+	}
+	return agent
 }
 
 func (a *Agent) OnData(data []byte) error {
-	if !a.authed {
-		// TODO auth connection
-		a.authed = true
-		logger.INFO("auth connection")
-		return nil
-	}
 	// TODO handle message
 	logger.INFO("data: ", data)
 	return nil
 }
 
+func (a *Agent) OnIdle() {
+	logger.INFO("agent idle: ", a.uuid)
+}
+
 func (a *Agent) OnClose(err error) {
 	a.closed = true
 	a.closeReason = err
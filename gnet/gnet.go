@@ -25,21 +25,22 @@ THE SOFTWARE.
 package gnet
 
 import (
+	"fmt"
 	"github.com/mafei198/glib/logger"
+	"net"
 	"time"
 )
 
-var (
-	Requested int64
-	Responsed int64
-)
-
 var OnlinePlayers int32
 var AgentPort string
 
 type Network struct{}
 
 type Conn interface {
+	Id() int64
+	LocalAddr() net.Addr
+	RemoteAddr() net.Addr
+	// 返回后不再引用data, 调用方可复用或归还到buffer池
 	SendData(data []byte) error
 	Close(reason string) error
 }
@@ -52,9 +53,11 @@ type ConnHandler interface {
 type HandlerFactory func(conn Conn) ConnHandler
 
 type Mgr struct {
+	config           *Config
 	factory          HandlerFactory
 	enableAcceptConn bool
 	enableAcceptMsg  bool
+	stopped          chan struct{}
 }
 
 var mgr *Mgr
@@ -66,8 +69,12 @@ type Acceptor interface {
 }
 
 const (
-	ProtocolTCP = "tcp"
-	ProtocolWS  = "ws"
+	ProtocolTCP  = "tcp"
+	ProtocolWS   = "ws"
+	ProtocolRUDP = "rudp"
+	ProtocolHTTP = "http"
+	// 基于epoll事件循环的TCP, 仅支持Linux
+	ProtocolEpoll = "epoll"
 
 	Packet      = 4
 	ReadTimeout = 60 * time.Second
@@ -77,30 +84,60 @@ func RegisterAcceptors(protocol string, acceptor Acceptor) {
 	acceptors[protocol] = acceptor
 }
 
-func Start(protocol, port string, factory HandlerFactory) *Mgr {
+// Start 启动指定协议的acceptor, 失败时记录错误并返回nil, 需要处理错误时使用StartE
+func Start(protocol, port string, factory HandlerFactory, configs ...*Config) *Mgr {
+	m, err := StartE(protocol, port, factory, configs...)
+	if err != nil {
+		logger.ERR("gnet start ", protocol, " on ", port, " failed: ", err)
+		return nil
+	}
+	return m
+}
+
+// StartE 同Start, 返回配置及监听错误; config被复制后使用, 之后修改不影响已启动的服务
+func StartE(protocol, port string, factory HandlerFactory, configs ...*Config) (*Mgr, error) {
+	config := defaultConfig()
+	if len(configs) > 0 && configs[0] != nil {
+		config = configs[0].clone()
+		if err := config.init(); err != nil {
+			return nil, err
+		}
+	}
+	acceptor, ok := acceptors[protocol]
+	if !ok {
+		return nil, fmt.Errorf("gnet: unknown protocol %q", protocol)
+	}
+	if mgr != nil {
+		mgr.stopStats()
+	}
 	mgr = &Mgr{
+		config:           config,
 		factory:          factory,
 		enableAcceptConn: true,
 		enableAcceptMsg:  true,
+		stopped:          make(chan struct{}),
 	}
-	acceptor := acceptors[protocol]
 	if err := acceptor.Start(port, factory); err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	go func() {
-		for {
-			time.Sleep(5 * time.Second)
-			logger.INFO("Network Status CCU: ", OnlinePlayers, " Requested: ", Requested, " Responsed: ", Responsed)
-		}
-	}()
+	go statsLoop(config, mgr.stopped)
 
-	return mgr
+	return mgr, nil
 }
 
 func Stop() {
 	if mgr != nil {
 		mgr.enableAcceptConn = false
 		mgr.enableAcceptMsg = false
+		mgr.stopStats()
+	}
+}
+
+func (m *Mgr) stopStats() {
+	select {
+	case <-m.stopped:
+	default:
+		close(m.stopped)
 	}
 }
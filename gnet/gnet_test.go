@@ -0,0 +1,81 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"errors"
+	"testing"
+)
+
+type startAcceptor struct {
+	config *Config
+	err    error
+}
+
+func (a *startAcceptor) Start(port string, factory HandlerFactory) error {
+	a.config = getConfig()
+	return a.err
+}
+
+func TestStart(t *testing.T) {
+	saved := mgr
+	defer func() { mgr = saved }()
+	acceptor := &startAcceptor{}
+	RegisterAcceptors("gnet_test", acceptor)
+	defer delete(acceptors, "gnet_test")
+
+	// 使用复制的Config, 调用方的Config不被修改
+	config := &Config{WS: &WSConfig{}}
+	m, err := StartE("gnet_test", "0", nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Stop()
+	if m != mgr || acceptor.config == config || acceptor.config.WS == config.WS {
+		t.Fatal("config not copied")
+	}
+	if config.MaxFrameSize != 0 || config.WS.Path != "" || acceptor.config.MaxFrameSize != MaxIncomingPacket {
+		t.Fatal("caller config modified")
+	}
+
+	if _, err := StartE("gnet_test", "0", nil, &Config{ChunkSize: 1, MaxFrameSize: 1}); err == nil {
+		t.Fatal("invalid config accepted")
+	}
+	if _, err := StartE("gnet_unknown", "0", nil); err == nil {
+		t.Fatal("unknown protocol accepted")
+	}
+	acceptor.err = errors.New("listen failed")
+	if _, err := StartE("gnet_test", "0", nil); err != acceptor.err {
+		t.Fatalf("expect acceptor error, got %v", err)
+	}
+	// Start保持原有签名, 出错时返回nil
+	if m := Start("gnet_test", "0", nil); m != nil {
+		t.Fatal("failed start returned a manager")
+	}
+	acceptor.err = nil
+	if m := Start("gnet_test", "0", nil); m == nil || m != mgr {
+		t.Fatal("Start did not return the manager")
+	}
+}
@@ -0,0 +1,402 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/mafei198/glib/logger"
+	"github.com/mafei198/glib/pbmsg"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrHTTPConnClosed    = errors.New("http conn closed")
+	ErrHTTPAuthenticator = errors.New("HTTPAcceptor requires Config.Authenticator to implement WSAuthenticator")
+)
+
+type HTTPConfig struct {
+	// 为nil时使用独立的ServeMux
+	Mux *http.ServeMux
+	// 路由前缀, 默认"/", 不以"/"结尾时自动补上, 请求路径为Prefix + 消息名
+	Prefix string
+	// 不为空时要求请求头 Authorization: Bearer <AuthToken>, 为空时只监听127.0.0.1
+	AuthToken string
+	// 处理器使用pbmsg.Envelope协议(router.UseEnvelope)时开启
+	Envelope bool
+	// 请求体最大长度, 默认Config.MaxFrameSize
+	MaxBodySize int64
+}
+
+// 调试及GM工具使用的HTTP/JSON网关, 每个请求使用一个新的ConnHandler,
+// 请求体按jsonpb转换为protobuf后交给OnData, OnData期间发送的消息作为响应返回.
+// 与其他协议一样经过连接数限制、限流及Config.Dispatch,
+// 非Inline模式下等待OnClose执行完才返回响应.
+// 配置了Config.Authenticator时须实现WSAuthenticator, 每个请求先用AuthenticateRequest认证,
+// 请求处理期间连接在注册表中, 可用GetIdentity获取身份
+type HTTPAcceptor struct {
+	host     string
+	port     string
+	listener net.Listener
+	factory  HandlerFactory
+	config   *Config
+	limiters *ipLimiters
+}
+
+type httpReply struct {
+	Type  string          `json:"type,omitempty"`
+	Push  bool            `json:"push,omitempty"`
+	Code  int32           `json:"code,omitempty"`
+	Error string          `json:"error,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty"`
+}
+
+type httpResult struct {
+	Replies []*httpReply `json:"replies"`
+	Error   string       `json:"error,omitempty"`
+}
+
+func init() {
+	RegisterAcceptors(ProtocolHTTP, &HTTPAcceptor{})
+}
+
+func (acceptor *HTTPAcceptor) Start(port string, factory HandlerFactory) error {
+	acceptor.factory = factory
+	acceptor.config = getConfig()
+	if _, ok := acceptor.config.Authenticator.(WSAuthenticator); acceptor.config.Authenticator != nil && !ok {
+		return ErrHTTPAuthenticator
+	}
+	acceptor.limiters = newIPLimiters()
+	acceptor.host = ""
+	if acceptor.config.HTTP.AuthToken == "" {
+		logger.WARN("HTTPAcceptor AuthToken not set, listen on loopback only")
+		acceptor.host = "127.0.0.1"
+	}
+	acceptor.port = port
+	listener, err := net.Listen("tcp", net.JoinHostPort(acceptor.host, port))
+	if err != nil {
+		return err
+	}
+	acceptor.listener = listener
+
+	go acceptor.startAcceptLoop()
+	return nil
+}
+
+func (acceptor *HTTPAcceptor) PrintInfo() {
+	AgentPort = strconv.Itoa(acceptor.listener.Addr().(*net.TCPAddr).Port)
+	logger.INFO("HTTPAgent lis: ", AgentPort)
+}
+
+func (acceptor *HTTPAcceptor) startAcceptLoop() {
+	mux := acceptor.config.HTTP.Mux
+	if mux == nil {
+		mux = http.NewServeMux()
+	}
+	mux.HandleFunc(acceptor.config.HTTP.Prefix, acceptor.httpHandler)
+	if err := http.Serve(acceptor.listener, mux); err != nil {
+		logger.ERR("start HTTPConn failed: ", err)
+		panic(err)
+	}
+}
+
+func (acceptor *HTTPAcceptor) httpHandler(w http.ResponseWriter, r *http.Request) {
+	config := acceptor.config
+	if !mgr.enableAcceptConn {
+		http.Error(w, "server stopped", http.StatusServiceUnavailable)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !acceptor.checkToken(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	remoteAddr := forwardedAddr(config, r)
+	ip := addrIP(remoteAddr)
+	if !connLimits.acquire(config, ip) {
+		logger.WARN("HTTPAcceptor reject request, too many connections: ", ip)
+		http.Error(w, "too many connections", http.StatusServiceUnavailable)
+		return
+	}
+	defer connLimits.release(ip)
+
+	name := strings.TrimPrefix(r.URL.Path, config.HTTP.Prefix)
+	msg, err := pbmsg.NewMessage(name)
+	if err != nil {
+		http.Error(w, "unknown message: "+name, http.StatusNotFound)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, config.HTTP.MaxBodySize))
+	if err != nil {
+		if int64(len(body)) >= config.HTTP.MaxBodySize {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, "read body failed: "+err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	if err = jsonpb.Unmarshal(bytes.NewReader(body), msg); err != nil && err != io.EOF {
+		http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	data, err := acceptor.encode(msg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !acceptor.limiters.allow(config, ip, len(data)) {
+		http.Error(w, ErrRateLimited.Error(), http.StatusTooManyRequests)
+		return
+	}
+
+	conn := newHTTPConn(r, remoteAddr)
+	if config.Authenticator != nil {
+		if err = acceptor.authenticate(conn, r); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		defer registry.del(conn.Id())
+	}
+	done := make(chan struct{})
+	conn.stats.connect()
+	conn.stats.recv(len(data))
+	handler := wrapDispatch(config, conn, &httpRequestHandler{handler: acceptor.factory(conn), conn: conn, done: done})
+	if err = handler.OnData(data); err != nil {
+		conn.finish(err)
+	}
+	handler.OnClose(newCloseError(err))
+	select {
+	case <-done:
+	case <-r.Context().Done():
+		// 客户端已断开, 处理函数在后台继续执行
+		conn.stats.disconnect(r.Context().Err())
+		return
+	}
+	conn.stats.disconnect(conn.err())
+
+	result := &httpResult{Replies: make([]*httpReply, 0)}
+	if err := conn.err(); err != nil {
+		result.Error = err.Error()
+	}
+	for _, data := range conn.replies() {
+		result.Replies = append(result.Replies, acceptor.decode(data))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		logger.ERR("HTTPAcceptor write response failed: ", err)
+	}
+}
+
+// 按请求认证, 与其他协议一样记录身份并绑定key
+func (acceptor *HTTPAcceptor) authenticate(conn *HTTPConn, r *http.Request) error {
+	wsAuth, ok := acceptor.config.Authenticator.(WSAuthenticator)
+	if !ok {
+		return ErrHTTPAuthenticator
+	}
+	identity, err := wsAuth.AuthenticateRequest(r)
+	if err != nil {
+		return err
+	}
+	registry.add(conn)
+	if err = attachIdentity(conn, identity); err != nil {
+		registry.del(conn.Id())
+		return err
+	}
+	return nil
+}
+
+func (acceptor *HTTPAcceptor) checkToken(r *http.Request) bool {
+	token := acceptor.config.HTTP.AuthToken
+	if token == "" {
+		return true
+	}
+	expect := "Bearer " + token
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(expect)) == 1
+}
+
+// 处理完请求后不再收集发送的数据, OnClose执行后通知httpHandler返回响应
+type httpRequestHandler struct {
+	handler ConnHandler
+	conn    *HTTPConn
+	done    chan struct{}
+}
+
+func (h *httpRequestHandler) OnData(data []byte) (err error) {
+	defer func() {
+		h.conn.finish(err)
+	}()
+	return h.handler.OnData(data)
+}
+
+func (h *httpRequestHandler) OnClose(err error) {
+	defer close(h.done)
+	h.conn.finish(nil)
+	h.handler.OnClose(err)
+}
+
+func (acceptor *HTTPAcceptor) encode(msg proto.Message) ([]byte, error) {
+	if acceptor.config.HTTP.Envelope {
+		return pbmsg.EncodeEnvelope(&pbmsg.Envelope{Kind: pbmsg.KindRequest, Id: 1, Msg: msg})
+	}
+	return pbmsg.Encode(msg)
+}
+
+func (acceptor *HTTPAcceptor) decode(data []byte) *httpReply {
+	reply := &httpReply{}
+	var msg proto.Message
+	if acceptor.config.HTTP.Envelope {
+		env, err := pbmsg.DecodeEnvelope(data)
+		if err != nil {
+			reply.Error = err.Error()
+			return reply
+		}
+		reply.Push = env.Kind == pbmsg.KindPush
+		reply.Code, reply.Error, msg = env.Code, env.Error, env.Msg
+	} else {
+		decoded, err := pbmsg.Decode(data)
+		if err != nil {
+			reply.Error = err.Error()
+			return reply
+		}
+		msg = decoded.(proto.Message)
+	}
+	if msg == nil {
+		return reply
+	}
+	reply.Type = pbmsg.GetType(msg)
+	marshaler := &jsonpb.Marshaler{OrigName: true}
+	content, err := marshaler.MarshalToString(msg)
+	if err != nil {
+		reply.Error = err.Error()
+		return reply
+	}
+	reply.Data = json.RawMessage(content)
+	return reply
+}
+
+func (c *HTTPConfig) init(maxFrameSize int) {
+	// 统一为"/xxx/"形式, ServeMux按子树匹配, 去掉前缀后即为消息名
+	if !strings.HasPrefix(c.Prefix, "/") {
+		c.Prefix = "/" + c.Prefix
+	}
+	if !strings.HasSuffix(c.Prefix, "/") {
+		c.Prefix += "/"
+	}
+	if c.MaxBodySize <= 0 {
+		c.MaxBodySize = int64(maxFrameSize)
+	}
+}
+
+// 单个HTTP请求对应的Conn, 收集OnData期间发送的数据
+type HTTPConn struct {
+	id         int64
+	localAddr  net.Addr
+	remoteAddr net.Addr
+	stats      *connStats
+	mutex      sync.Mutex
+	data       [][]byte
+	closed     bool
+	closeErr   error
+}
+
+func newHTTPConn(r *http.Request, remoteAddr net.Addr) *HTTPConn {
+	conn := &HTTPConn{
+		id:         nextConnId(),
+		remoteAddr: remoteAddr,
+		stats:      newConnStats(ProtocolHTTP),
+	}
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		conn.localAddr = addr
+	}
+	return conn
+}
+
+func (c *HTTPConn) Id() int64 {
+	return c.id
+}
+
+func (c *HTTPConn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+func (c *HTTPConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func (c *HTTPConn) SendData(data []byte) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return ErrHTTPConnClosed
+	}
+	// 调用方可能复用data, 须拷贝
+	c.data = append(c.data, append([]byte(nil), data...))
+	c.stats.send(len(data))
+	return nil
+}
+
+// 处理出错或被踢时结束收集, 原因作为响应的error返回
+func (c *HTTPConn) Close(reason string) error {
+	return c.CloseWithReason(CloseReasonClosed, reason)
+}
+
+func (c *HTTPConn) CloseWithReason(reason CloseReason, msg string) error {
+	c.finish(&CloseError{Reason: reason, Err: errors.New(msg)})
+	return nil
+}
+
+func (c *HTTPConn) finish(err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.closed = true
+	if err != nil && c.closeErr == nil {
+		c.closeErr = err
+	}
+}
+
+func (c *HTTPConn) err() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.closeErr
+}
+
+func (c *HTTPConn) replies() [][]byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.data
+}
@@ -0,0 +1,180 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"errors"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/mafei198/glib/pbmsg"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	pbmsg.Register(func() proto.Message { return &wrappers.StringValue{} })
+}
+
+// 不监听端口, 直接用ServeMux驱动httpHandler, 返回的函数恢复mgr
+func newTestHTTPAcceptor(t *testing.T, config *Config) (http.Handler, func()) {
+	return newTestHTTPAcceptorWith(t, config, func(Conn) ConnHandler { return nopHandler{} })
+}
+
+func newTestHTTPAcceptorWith(t *testing.T, config *Config, factory HandlerFactory) (http.Handler, func()) {
+	if err := config.init(); err != nil {
+		t.Fatal(err)
+	}
+	saved := mgr
+	mgr = &Mgr{config: config, enableAcceptConn: true, enableAcceptMsg: true, stopped: make(chan struct{})}
+	acceptor := &HTTPAcceptor{
+		factory:  factory,
+		config:   config,
+		limiters: newIPLimiters(),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(config.HTTP.Prefix, acceptor.httpHandler)
+	return mux, func() { mgr = saved }
+}
+
+func postHTTP(handler http.Handler, path, remoteAddr string) int {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`"hi"`))
+	r.RemoteAddr = remoteAddr
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w.Code
+}
+
+// 同一IP的多个请求共用限流器, 不同IP互不影响
+func TestHTTPRateLimitPerIP(t *testing.T) {
+	handler, restore := newTestHTTPAcceptor(t, &Config{MsgPerSecond: 2, MsgBurst: 2})
+	defer restore()
+	for i := 0; i < 2; i++ {
+		if code := postHTTP(handler, "/StringValue", "10.0.0.1:1000"); code != http.StatusOK {
+			t.Fatalf("request %d: got %d", i, code)
+		}
+	}
+	if code := postHTTP(handler, "/StringValue", "10.0.0.1:1001"); code != http.StatusTooManyRequests {
+		t.Fatalf("third request from the same ip: got %d, want %d", code, http.StatusTooManyRequests)
+	}
+	if code := postHTTP(handler, "/StringValue", "10.0.0.2:1000"); code != http.StatusOK {
+		t.Fatalf("request from another ip: got %d", code)
+	}
+}
+
+func TestHTTPPrefixWithoutSlash(t *testing.T) {
+	config := &Config{HTTP: &HTTPConfig{Prefix: "/api"}}
+	handler, restore := newTestHTTPAcceptor(t, config)
+	defer restore()
+	if config.HTTP.Prefix != "/api/" {
+		t.Fatalf("prefix not normalized: %q", config.HTTP.Prefix)
+	}
+	if code := postHTTP(handler, "/api/StringValue", "10.0.0.1:1000"); code != http.StatusOK {
+		t.Fatalf("got %d", code)
+	}
+	if code := postHTTP(handler, "/api/Missing", "10.0.0.1:1000"); code != http.StatusNotFound {
+		t.Fatalf("unknown message: got %d", code)
+	}
+}
+
+func TestIPLimitersSweep(t *testing.T) {
+	config := &Config{MsgPerSecond: 10}
+	limiters := newIPLimiters()
+	limiters.allow(config, "a", 1)
+	limiters.allow(config, "b", 1)
+	limiters.limiters["a"].msgs.last = limiters.limiters["a"].msgs.last.Add(-time.Second)
+	limiters.sweep(time.Now())
+	if _, ok := limiters.limiters["a"]; ok {
+		t.Fatal("refilled limiter should be swept")
+	}
+	if _, ok := limiters.limiters["b"]; !ok {
+		t.Fatal("limiter still in use should be kept")
+	}
+}
+
+type requestAuthenticator struct {
+	tokenAuthenticator
+}
+
+func (requestAuthenticator) AuthenticateRequest(r *http.Request) (*Identity, error) {
+	player := r.Header.Get("X-Player")
+	if player == "" {
+		return nil, errors.New("missing player")
+	}
+	return &Identity{Key: "http_" + player}, nil
+}
+
+// 记录请求处理时连接的身份
+type identityRecorder struct {
+	nopHandler
+	conn     Conn
+	identity chan *Identity
+}
+
+func (h *identityRecorder) OnData([]byte) error {
+	identity, _ := GetIdentity(h.conn.Id())
+	h.identity <- identity
+	return nil
+}
+
+// 配置了Authenticator时每个请求先认证, 处理期间可获取身份, 结束后移除
+func TestHTTPAuth(t *testing.T) {
+	identities := make(chan *Identity, 1)
+	var connId int64
+	handler, restore := newTestHTTPAcceptorWith(t, &Config{Authenticator: requestAuthenticator{}}, func(conn Conn) ConnHandler {
+		connId = conn.Id()
+		return &identityRecorder{conn: conn, identity: identities}
+	})
+	defer restore()
+	if code := postHTTP(handler, "/StringValue", "10.0.0.1:1000"); code != http.StatusUnauthorized {
+		t.Fatalf("request without credentials: got %d", code)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/StringValue", strings.NewReader(`"hi"`))
+	r.Header.Set("X-Player", "7")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("authenticated request: got %d %s", w.Code, w.Body)
+	}
+	if identity := <-identities; identity == nil || identity.Key != "http_7" {
+		t.Fatalf("identity during request: %+v", identity)
+	}
+	if _, ok := GetIdentity(connId); ok {
+		t.Fatal("identity kept after request")
+	}
+	if _, ok := GetConnByKey("http_7"); ok {
+		t.Fatal("key kept after request")
+	}
+
+	// 只支持第一帧认证的Authenticator无法用于HTTP
+	saved := mgr
+	mgr = &Mgr{config: &Config{Authenticator: tokenAuthenticator{}, HTTP: &HTTPConfig{}}}
+	defer func() { mgr = saved }()
+	if err := (&HTTPAcceptor{}).Start("0", func(Conn) ConnHandler { return nopHandler{} }); err != ErrHTTPAuthenticator {
+		t.Fatalf("start with a frame-only Authenticator: %v", err)
+	}
+}
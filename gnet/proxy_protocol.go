@@ -0,0 +1,259 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 读取PROXY协议头的超时时间
+const ProxyHeaderTimeout = 5 * time.Second
+
+var (
+	ErrProxyHeader = errors.New("invalid proxy protocol header")
+
+	proxyV1Prefix  = []byte("PROXY ")
+	proxyV2Sig     = []byte("\r\n\r\n\x00\r\nQUIT\n")
+	proxyV1MaxSize = 107
+)
+
+// 解析过PROXY协议头的连接, 对外暴露真实的客户端地址
+type proxyConn struct {
+	net.Conn
+	reader *bufio.Reader
+	local  net.Addr
+	remote net.Addr
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// 读取HAProxy PROXY协议v1/v2头, 返回携带真实地址的连接
+func readProxyHeader(conn net.Conn) (net.Conn, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(ProxyHeaderTimeout)); err != nil {
+		return nil, err
+	}
+	pc := &proxyConn{
+		Conn:   conn,
+		reader: bufio.NewReader(conn),
+		local:  conn.LocalAddr(),
+		remote: conn.RemoteAddr(),
+	}
+	sig, err := pc.reader.Peek(len(proxyV1Prefix))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(sig, proxyV1Prefix) {
+		err = pc.readV1()
+	} else {
+		err = pc.readV2()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pc, conn.SetReadDeadline(time.Time{})
+}
+
+// PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n
+func (c *proxyConn) readV1() error {
+	line := make([]byte, 0, proxyV1MaxSize)
+	for {
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= proxyV1MaxSize {
+			return ErrProxyHeader
+		}
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return ErrProxyHeader
+	}
+	parts := strings.Split(string(line[:len(line)-2]), " ")
+	if len(parts) < 2 {
+		return ErrProxyHeader
+	}
+	switch parts[1] {
+	case "UNKNOWN":
+		return nil
+	case "TCP4", "TCP6":
+	default:
+		return ErrProxyHeader
+	}
+	if len(parts) != 6 {
+		return ErrProxyHeader
+	}
+	src, err := parseTCPAddr(parts[1], parts[2], parts[4])
+	if err != nil {
+		return err
+	}
+	dst, err := parseTCPAddr(parts[1], parts[3], parts[5])
+	if err != nil {
+		return err
+	}
+	c.remote, c.local = src, dst
+	return nil
+}
+
+// 地址须与TCP4/TCP6一致, TCP6使用IPv6文本格式
+func parseTCPAddr(family, host, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil || (family == "TCP4") != (ip.To4() != nil && !strings.Contains(host, ":")) {
+		return nil, ErrProxyHeader
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, ErrProxyHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+func (c *proxyConn) readV2() error {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return err
+	}
+	if !bytes.Equal(header[:12], proxyV2Sig) || header[12]>>4 != 2 {
+		return ErrProxyHeader
+	}
+	command := header[12] & 0x0F
+	family := header[13] >> 4
+	size := binary.BigEndian.Uint16(header[14:16])
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return err
+	}
+	// LOCAL命令为负载均衡器的健康检查, 保留原始地址
+	if command == 0 {
+		return nil
+	}
+	if command != 1 {
+		return ErrProxyHeader
+	}
+	var ipLen int
+	switch family {
+	case 1: // AF_INET
+		ipLen = net.IPv4len
+	case 2: // AF_INET6
+		ipLen = net.IPv6len
+	default: // AF_UNSPEC/AF_UNIX 保留原始地址
+		return nil
+	}
+	if len(payload) < ipLen*2+4 {
+		return ErrProxyHeader
+	}
+	srcIP := net.IP(payload[:ipLen])
+	dstIP := net.IP(payload[ipLen : ipLen*2])
+	srcPort := binary.BigEndian.Uint16(payload[ipLen*2:])
+	dstPort := binary.BigEndian.Uint16(payload[ipLen*2+2:])
+	c.remote = &net.TCPAddr{IP: srcIP, Port: int(srcPort)}
+	c.local = &net.TCPAddr{IP: dstIP, Port: int(dstPort)}
+	return nil
+}
+
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func isTrusted(nets []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// 直连地址为可信代理时, 从X-Forwarded-For中由右向左取第一个不可信地址作为客户端地址
+func forwardedAddr(config *Config, r *http.Request) net.Addr {
+	host, port, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	p, _ := strconv.Atoi(port)
+	remote := &net.TCPAddr{IP: net.ParseIP(host), Port: p}
+	if !isTrusted(config.trustedProxies, remote.IP) {
+		return remote
+	}
+	hops := make([]string, 0)
+	for _, header := range r.Header["X-Forwarded-For"] {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	var client net.IP
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
+		}
+		client = ip
+		if !isTrusted(config.trustedProxies, ip) {
+			break
+		}
+	}
+	if client == nil {
+		return remote
+	}
+	return &net.TCPAddr{IP: client}
+}
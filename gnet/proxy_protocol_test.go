@@ -0,0 +1,132 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"encoding/binary"
+	"net"
+	"net/http/httptest"
+	"testing"
+)
+
+// 写入header及之后的数据, 返回客户端及解析PROXY头后的连接, 调用方负责关闭客户端
+func proxyConnOf(header []byte) (net.Conn, net.Conn, error) {
+	client, server := net.Pipe()
+	go func() {
+		_, _ = client.Write(append(header, "data"...))
+	}()
+	conn, err := readProxyHeader(server)
+	return client, conn, err
+}
+
+func proxyV2Header(command, family byte, payload []byte) []byte {
+	header := append([]byte(nil), proxyV2Sig...)
+	header = append(header, 0x20|command, family<<4|1, 0, 0)
+	binary.BigEndian.PutUint16(header[14:], uint16(len(payload)))
+	return append(header, payload...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	v4 := []byte{192, 168, 0, 1, 10, 0, 0, 1, 0xdc, 0x04, 0x01, 0xbb}
+	cases := []struct {
+		name   string
+		header []byte
+		remote string
+	}{
+		{"v1 tcp4", []byte("PROXY TCP4 192.168.0.1 10.0.0.1 56324 443\r\n"), "192.168.0.1:56324"},
+		{"v1 tcp6", []byte("PROXY TCP6 ::1 ::2 56324 443\r\n"), "[::1]:56324"},
+		{"v1 unknown", []byte("PROXY UNKNOWN\r\n"), "pipe"},
+		{"v2 proxy", proxyV2Header(1, 1, v4), "192.168.0.1:56324"},
+		// 健康检查保留原始地址
+		{"v2 local", proxyV2Header(0, 0, nil), "pipe"},
+	}
+	for _, c := range cases {
+		client, conn, err := proxyConnOf(c.header)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if got := conn.RemoteAddr().String(); got != c.remote {
+			t.Fatalf("%s: remote %s, want %s", c.name, got, c.remote)
+		}
+		// 头部之后的数据不丢失
+		buf := make([]byte, 4)
+		if _, err := conn.Read(buf); err != nil || string(buf) != "data" {
+			t.Fatalf("%s: read %q %v", c.name, buf, err)
+		}
+		_ = client.Close()
+	}
+}
+
+func TestReadProxyHeaderInvalid(t *testing.T) {
+	for name, header := range map[string][]byte{
+		"v1 bad protocol": []byte("PROXY UDP4 192.168.0.1 10.0.0.1 1 2\r\n"),
+		"v1 bad addr":     []byte("PROXY TCP4 host 10.0.0.1 1 2\r\n"),
+		"v1 bad port":     []byte("PROXY TCP4 192.168.0.1 10.0.0.1 65536 2\r\n"),
+		"v1 no crlf":      []byte("PROXY TCP4 192.168.0.1 10.0.0.1 1 2\n"),
+		"v1 tcp4 with v6": []byte("PROXY TCP4 ::1 ::2 1 2\r\n"),
+		"v1 tcp4 mapped":  []byte("PROXY TCP4 ::ffff:192.168.0.1 10.0.0.1 1 2\r\n"),
+		"v1 tcp6 with v4": []byte("PROXY TCP6 192.168.0.1 ::2 1 2\r\n"),
+		"v1 tcp6 mixed":   []byte("PROXY TCP6 ::1 10.0.0.1 1 2\r\n"),
+		"v2 bad command":  proxyV2Header(2, 1, make([]byte, 12)),
+		"v2 short":        proxyV2Header(1, 1, make([]byte, 8)),
+		"no header":       []byte("GET / HTTP/1.1\r\n\r\n"),
+	} {
+		client, _, err := proxyConnOf(header)
+		_ = client.Close()
+		if err == nil {
+			t.Fatalf("%s: accepted", name)
+		}
+	}
+}
+
+func TestForwardedAddr(t *testing.T) {
+	config := &Config{TrustedProxies: []string{"10.0.0.1", "172.16.0.0/12"}}
+	if err := config.init(); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		remote    string
+		forwarded string
+		want      string
+	}{
+		// 直连地址不可信时忽略X-Forwarded-For
+		{"1.2.3.4:1000", "5.6.7.8", "1.2.3.4"},
+		{"10.0.0.1:1000", "5.6.7.8", "5.6.7.8"},
+		// 由右向左跳过可信代理, 伪造的最左地址不被采用
+		{"10.0.0.1:1000", "9.9.9.9, 5.6.7.8, 172.16.0.2", "5.6.7.8"},
+		{"10.0.0.1:1000", "", "10.0.0.1"},
+		{"10.0.0.1:1000", "garbage", "10.0.0.1"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = c.remote
+		if c.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+		if got := addrIP(forwardedAddr(config, r)); got != c.want {
+			t.Fatalf("%s via %q: got %s, want %s", c.remote, c.forwarded, got, c.want)
+		}
+	}
+}
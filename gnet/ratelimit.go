@@ -0,0 +1,267 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"errors"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+var ErrRateLimited = errors.New("rate limited")
+
+type RateLimitAction int
+
+const (
+	RateLimitClose RateLimitAction = iota // 断开连接
+	RateLimitDrop                         // 丢弃当前消息
+)
+
+// 令牌桶, 每秒补充rate个令牌, 最多累积burst个
+type TokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenBucket(rate, burst int) *TokenBucket {
+	if burst < rate {
+		burst = rate
+	}
+	return &TokenBucket{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// 超过burst的请求在桶满时放行并扣除全部令牌, 令牌变为负数,
+// 之后按速率补足欠额才放行下一个请求, 长期平均不超过rate
+func (b *TokenBucket) Allow(n int) bool {
+	b.refill(time.Now())
+	if !b.has(n) {
+		return false
+	}
+	b.take(n)
+	return true
+}
+
+func (b *TokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// 超过burst的请求只要求桶满
+func (b *TokenBucket) has(n int) bool {
+	return b.tokens >= math.Min(float64(n), b.burst)
+}
+
+func (b *TokenBucket) take(n int) {
+	b.tokens -= float64(n)
+}
+
+// 单连接的消息数/字节数限流, 只在读goroutine中使用
+type connLimiter struct {
+	msgs  *TokenBucket
+	bytes *TokenBucket
+}
+
+func newConnLimiter(config *Config) *connLimiter {
+	if config.MsgPerSecond <= 0 && config.BytesPerSecond <= 0 {
+		return nil
+	}
+	limiter := &connLimiter{}
+	if config.MsgPerSecond > 0 {
+		limiter.msgs = NewTokenBucket(config.MsgPerSecond, config.MsgBurst)
+	}
+	if config.BytesPerSecond > 0 {
+		limiter.bytes = NewTokenBucket(config.BytesPerSecond, config.BytesBurst)
+	}
+	return limiter
+}
+
+func (l *connLimiter) allow(size int) bool {
+	if l == nil {
+		return true
+	}
+	// 所有桶都足够时才扣除, 被拒绝的消息不消耗配额
+	now := time.Now()
+	if l.msgs != nil {
+		l.msgs.refill(now)
+		if !l.msgs.has(1) {
+			return false
+		}
+	}
+	if l.bytes != nil {
+		l.bytes.refill(now)
+		if !l.bytes.has(size) {
+			return false
+		}
+	}
+	if l.msgs != nil {
+		l.msgs.take(1)
+	}
+	if l.bytes != nil {
+		l.bytes.take(size)
+	}
+	return true
+}
+
+// 各桶都已补满, 丢弃后重建不改变限流结果
+func (l *connLimiter) full(now time.Time) bool {
+	if l.msgs != nil {
+		l.msgs.refill(now)
+		if l.msgs.tokens < l.msgs.burst {
+			return false
+		}
+	}
+	if l.bytes != nil {
+		l.bytes.refill(now)
+		if l.bytes.tokens < l.bytes.burst {
+			return false
+		}
+	}
+	return true
+}
+
+// 清理空闲限流器的间隔
+const limiterSweepInterval = time.Minute
+
+// 按客户端IP保存的限流器, 用于HTTP这类每个请求一个Conn的协议,
+// 定期清理桶已补满的条目
+type ipLimiters struct {
+	mutex    sync.Mutex
+	limiters map[string]*connLimiter
+	swept    time.Time
+}
+
+func newIPLimiters() *ipLimiters {
+	return &ipLimiters{limiters: map[string]*connLimiter{}, swept: time.Now()}
+}
+
+func (l *ipLimiters) allow(config *Config, ip string, size int) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now()
+	if now.Sub(l.swept) >= limiterSweepInterval {
+		l.sweep(now)
+	}
+	limiter, ok := l.limiters[ip]
+	if !ok {
+		if limiter = newConnLimiter(config); limiter == nil {
+			return true
+		}
+		l.limiters[ip] = limiter
+	}
+	return limiter.allow(size)
+}
+
+func (l *ipLimiters) sweep(now time.Time) {
+	for ip, limiter := range l.limiters {
+		if limiter.full(now) {
+			delete(l.limiters, ip)
+		}
+	}
+	l.swept = now
+}
+
+// 超出限流时的处理, 默认断开连接
+func onRateLimited(config *Config, conn Conn) RateLimitAction {
+	if config.OnRateLimited == nil {
+		return RateLimitClose
+	}
+	return config.OnRateLimited(conn)
+}
+
+// 全局连接数及单IP连接数限制
+type connCounter struct {
+	mutex sync.Mutex
+	total int
+	ips   map[string]int
+}
+
+var connLimits = &connCounter{ips: map[string]int{}}
+
+func (c *connCounter) acquire(config *Config, ip string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if config.MaxConns > 0 && c.total >= config.MaxConns {
+		return false
+	}
+	if config.MaxConnsPerIP > 0 && c.ips[ip] >= config.MaxConnsPerIP {
+		return false
+	}
+	c.total++
+	c.ips[ip]++
+	return true
+}
+
+// PROXY头部读取前按直连地址计数, 读取后转为真实客户端地址
+func (c *connCounter) rekey(config *Config, from, to string) bool {
+	if from == to {
+		return true
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if config.MaxConnsPerIP > 0 && c.ips[to] >= config.MaxConnsPerIP {
+		return false
+	}
+	if c.ips[from] <= 1 {
+		delete(c.ips, from)
+	} else {
+		c.ips[from]--
+	}
+	c.ips[to]++
+	return true
+}
+
+func (c *connCounter) release(ip string) {
+	c.mutex.Lock()
+	c.total--
+	if c.ips[ip] <= 1 {
+		delete(c.ips, ip)
+	} else {
+		c.ips[ip]--
+	}
+	c.mutex.Unlock()
+}
+
+func addrIP(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
@@ -0,0 +1,102 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"testing"
+	"time"
+)
+
+// 超过burst的请求在桶满时放行, 但按实际大小扣除, 欠额补足前不再放行
+func TestTokenBucketLargeRequest(t *testing.T) {
+	b := NewTokenBucket(10, 10)
+	if !b.Allow(100) {
+		t.Fatal("request larger than burst should pass with a full bucket")
+	}
+	if b.Allow(1) {
+		t.Fatal("bucket should be in debt after a large request")
+	}
+	b.last = b.last.Add(-time.Second)
+	if b.Allow(100) {
+		t.Fatal("large request passed before the debt was repaid")
+	}
+	b.last = b.last.Add(-9 * time.Second)
+	if !b.Allow(100) {
+		t.Fatal("large request should pass once the bucket is full again")
+	}
+}
+
+// 持续发送超过burst的帧, 长期吞吐不超过rate
+func TestTokenBucketOversizedThrottled(t *testing.T) {
+	const rate, frame = 1000, 64 * 1024
+	b := NewTokenBucket(rate, rate)
+	start := b.last
+	passed := 0
+	// 模拟60秒, 每10ms尝试发送一帧
+	for i := 1; i <= 6000; i++ {
+		now := start.Add(time.Duration(i) * 10 * time.Millisecond)
+		b.refill(now)
+		if b.has(frame) {
+			b.take(frame)
+			passed += frame
+		}
+	}
+	if limit := rate*60 + frame; passed > limit {
+		t.Fatalf("passed %d bytes in 60s, limit %d", passed, limit)
+	}
+}
+
+func TestConnLimiterRejectKeepsQuota(t *testing.T) {
+	limiter := newConnLimiter(&Config{MsgPerSecond: 5, BytesPerSecond: 100})
+	if !limiter.allow(100) {
+		t.Fatal("first message should pass")
+	}
+	before := limiter.msgs.tokens
+	if limiter.allow(10) {
+		t.Fatal("byte bucket is empty, message should be rejected")
+	}
+	if limiter.msgs.tokens < before {
+		t.Fatalf("rejected message consumed msg quota: %v -> %v", before, limiter.msgs.tokens)
+	}
+}
+
+func TestConnCounterRekey(t *testing.T) {
+	config := &Config{MaxConnsPerIP: 1}
+	c := &connCounter{ips: map[string]int{}}
+	if !c.acquire(config, "lb") || !c.acquire(&Config{}, "lb") {
+		t.Fatal("acquire failed")
+	}
+	if !c.rekey(config, "lb", "1.1.1.1") {
+		t.Fatal("rekey to a free ip should succeed")
+	}
+	if c.rekey(config, "lb", "1.1.1.1") {
+		t.Fatal("rekey should respect MaxConnsPerIP of the real ip")
+	}
+	c.release("lb")
+	c.release("1.1.1.1")
+	if c.total != 0 || len(c.ips) != 0 {
+		t.Fatalf("counter not balanced: %d %v", c.total, c.ips)
+	}
+}
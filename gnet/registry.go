@@ -0,0 +1,311 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"errors"
+	"github.com/mafei198/glib/logger"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	ErrConnNotFound = errors.New("conn not found")
+	ErrKeyBound     = errors.New("key already bound to another conn")
+)
+
+var connIdSeq int64
+
+// 每个连接待发送广播的上限, 超过时视为慢连接并断开
+const BroadcastQueueLen = 256
+
+type Registry struct {
+	mutex      sync.RWMutex
+	conns      map[int64]Conn
+	keys       map[string]int64
+	ids        map[int64]string
+	queues     map[int64]*broadcastQueue
+	identities map[int64]*Identity
+}
+
+var registry = &Registry{
+	conns:      map[int64]Conn{},
+	keys:       map[string]int64{},
+	ids:        map[int64]string{},
+	queues:     map[int64]*broadcastQueue{},
+	identities: map[int64]*Identity{},
+}
+
+func nextConnId() int64 {
+	return atomic.AddInt64(&connIdSeq, 1)
+}
+
+func (r *Registry) add(conn Conn) {
+	r.mutex.Lock()
+	r.conns[conn.Id()] = conn
+	r.mutex.Unlock()
+}
+
+func (r *Registry) del(id int64) {
+	r.mutex.Lock()
+	r.delLocked(id)
+	r.mutex.Unlock()
+}
+
+// 底层连接由会话代表时, 连接的身份及key绑定转移到会话, 并移除连接
+func (r *Registry) handover(from, to int64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	identity, hasIdentity := r.identities[from]
+	key, hasKey := r.ids[from]
+	r.delLocked(from)
+	if _, ok := r.conns[to]; !ok {
+		return
+	}
+	if hasIdentity {
+		r.identities[to] = identity
+	}
+	if hasKey {
+		r.keys[key] = to
+		r.ids[to] = key
+	}
+}
+
+// key是否绑定在可恢复会话上
+func (r *Registry) keyHeldBySession(key string) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	id, ok := r.keys[key]
+	if !ok {
+		return false
+	}
+	_, ok = r.conns[id].(*ResumeSession)
+	return ok
+}
+
+func (r *Registry) delLocked(id int64) {
+	delete(r.conns, id)
+	delete(r.identities, id)
+	if queue, ok := r.queues[id]; ok {
+		delete(r.queues, id)
+		queue.stop()
+	}
+	if key, ok := r.ids[id]; ok {
+		delete(r.ids, id)
+		delete(r.keys, key)
+	}
+}
+
+func (r *Registry) get(id int64) (Conn, bool) {
+	r.mutex.RLock()
+	conn, ok := r.conns[id]
+	r.mutex.RUnlock()
+	return conn, ok
+}
+
+func (r *Registry) getByKey(key string) (Conn, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	id, ok := r.keys[key]
+	if !ok {
+		return nil, false
+	}
+	conn, ok := r.conns[id]
+	return conn, ok
+}
+
+func (r *Registry) bind(id int64, key string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.conns[id]; !ok {
+		return ErrConnNotFound
+	}
+	if boundId, ok := r.keys[key]; ok && boundId != id {
+		return ErrKeyBound
+	}
+	if oldKey, ok := r.ids[id]; ok {
+		delete(r.keys, oldKey)
+	}
+	r.keys[key] = id
+	r.ids[id] = key
+	return nil
+}
+
+func (r *Registry) setIdentity(id int64, identity *Identity) {
+	r.mutex.Lock()
+	if _, ok := r.conns[id]; ok {
+		r.identities[id] = identity
+	}
+	r.mutex.Unlock()
+}
+
+func (r *Registry) getIdentity(id int64) (*Identity, bool) {
+	r.mutex.RLock()
+	identity, ok := r.identities[id]
+	r.mutex.RUnlock()
+	return identity, ok
+}
+
+func (r *Registry) unbind(key string) {
+	r.mutex.Lock()
+	if id, ok := r.keys[key]; ok {
+		delete(r.keys, key)
+		delete(r.ids, id)
+	}
+	r.mutex.Unlock()
+}
+
+func (r *Registry) snapshot() []Conn {
+	r.mutex.RLock()
+	conns := make([]Conn, 0, len(r.conns))
+	for _, conn := range r.conns {
+		conns = append(conns, conn)
+	}
+	r.mutex.RUnlock()
+	return conns
+}
+
+// 返回所有在线连接的广播队列, 没有则创建
+func (r *Registry) broadcastQueues() []*broadcastQueue {
+	r.mutex.Lock()
+	queues := make([]*broadcastQueue, 0, len(r.conns))
+	for id, conn := range r.conns {
+		queue, ok := r.queues[id]
+		if !ok {
+			queue = &broadcastQueue{conn: conn}
+			r.queues[id] = queue
+		}
+		queues = append(queues, queue)
+	}
+	r.mutex.Unlock()
+	return queues
+}
+
+// 单个连接的广播发送队列, 按顺序由独立goroutine写出, 队列为空时goroutine退出
+type broadcastQueue struct {
+	conn    Conn
+	mutex   sync.Mutex
+	items   [][]byte
+	running bool
+	closed  bool
+}
+
+func (q *broadcastQueue) push(data []byte) {
+	q.mutex.Lock()
+	if q.closed {
+		q.mutex.Unlock()
+		return
+	}
+	if len(q.items) >= BroadcastQueueLen {
+		q.closed = true
+		q.items = nil
+		q.mutex.Unlock()
+		logger.WARN("broadcast queue of conn ", q.conn.Id(), " full, close it")
+		go CloseConn(q.conn, CloseReasonSendQueueFull, "broadcast queue full")
+		return
+	}
+	q.items = append(q.items, data)
+	if q.running {
+		q.mutex.Unlock()
+		return
+	}
+	q.running = true
+	q.mutex.Unlock()
+	go q.drain()
+}
+
+func (q *broadcastQueue) drain() {
+	for {
+		q.mutex.Lock()
+		if q.closed || len(q.items) == 0 {
+			q.running = false
+			q.mutex.Unlock()
+			return
+		}
+		data := q.items[0]
+		q.items[0] = nil
+		q.items = q.items[1:]
+		q.mutex.Unlock()
+		if err := q.conn.SendData(data); err != nil {
+			logger.WARN("broadcast to conn ", q.conn.Id(), " failed: ", err)
+			q.stop()
+		}
+	}
+}
+
+func (q *broadcastQueue) stop() {
+	q.mutex.Lock()
+	q.closed = true
+	q.items = nil
+	q.mutex.Unlock()
+}
+
+// GetConn 按连接id查找在线连接
+func GetConn(id int64) (Conn, bool) {
+	return registry.get(id)
+}
+
+// GetConnByKey 按业务key(如认证后的playerId)查找在线连接
+func GetConnByKey(key string) (Conn, bool) {
+	return registry.getByKey(key)
+}
+
+// BindKey 将业务key绑定到连接上, 连接断开时自动解绑
+func BindKey(id int64, key string) error {
+	return registry.bind(id, key)
+}
+
+func UnbindKey(key string) {
+	registry.unbind(key)
+}
+
+func Conns() []Conn {
+	return registry.snapshot()
+}
+
+func Kick(id int64, reason string) error {
+	conn, ok := registry.get(id)
+	if !ok {
+		return ErrConnNotFound
+	}
+	return CloseConn(conn, CloseReasonKick, reason)
+}
+
+func SendTo(id int64, data []byte) error {
+	conn, ok := registry.get(id)
+	if !ok {
+		return ErrConnNotFound
+	}
+	return conn.SendData(data)
+}
+
+// BroadcastAll 向所有在线连接广播, 不等待写出, 慢连接不影响其他连接
+// 每个连接按广播顺序依次发送, 积压超过BroadcastQueueLen的连接会被断开
+func BroadcastAll(data []byte) {
+	data = append([]byte(nil), data...)
+	for _, queue := range registry.broadcastQueues() {
+		queue.push(data)
+	}
+}
@@ -0,0 +1,216 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeConn struct {
+	id     int64
+	mutex  sync.Mutex
+	sent   [][]byte
+	reason string
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{id: nextConnId()}
+}
+
+func (c *fakeConn) Id() int64            { return c.id }
+func (c *fakeConn) LocalAddr() net.Addr  { return nil }
+func (c *fakeConn) RemoteAddr() net.Addr { return nil }
+
+func (c *fakeConn) Close(reason string) error {
+	c.mutex.Lock()
+	c.reason = reason
+	c.mutex.Unlock()
+	return nil
+}
+
+func (c *fakeConn) SendData(data []byte) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.sent = append(c.sent, append([]byte(nil), data...))
+	return nil
+}
+
+func (c *fakeConn) ops() []byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	ops := make([]byte, 0, len(c.sent))
+	for _, data := range c.sent {
+		ops = append(ops, data[0])
+	}
+	return ops
+}
+
+type stuckConn struct {
+	*fakeConn
+	unblock chan struct{}
+	closed  chan CloseReason
+}
+
+func (c *stuckConn) SendData(data []byte) error {
+	<-c.unblock
+	return c.fakeConn.SendData(data)
+}
+
+func (c *stuckConn) Close(reason string) error {
+	return c.CloseWithReason(CloseReasonClosed, reason)
+}
+
+func (c *stuckConn) CloseWithReason(reason CloseReason, msg string) error {
+	c.closed <- reason
+	return nil
+}
+
+func (c *fakeConn) sentCount() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.sent)
+}
+
+// 写阻塞的连接不拖慢广播, 积压超限后被断开, 其他连接按顺序收到全部广播
+func TestBroadcastSlowConn(t *testing.T) {
+	fast := newFakeConn()
+	stuck := &stuckConn{
+		fakeConn: newFakeConn(),
+		unblock:  make(chan struct{}),
+		closed:   make(chan CloseReason, 1),
+	}
+	registry.add(fast)
+	registry.add(stuck)
+	defer registry.del(fast.Id())
+	defer registry.del(stuck.Id())
+	defer close(stuck.unblock)
+
+	// 分两批广播, 正常连接每批都能及时写完, 阻塞连接累计积压超限
+	count := BroadcastQueueLen + 2
+	broadcast := func(from, to int) {
+		done := make(chan struct{})
+		go func() {
+			for i := from; i < to; i++ {
+				BroadcastAll([]byte{byte(i)})
+			}
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("BroadcastAll blocked by slow conn")
+		}
+		deadline := time.Now().Add(time.Second)
+		for fast.sentCount() < to && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	broadcast(0, count/2)
+	broadcast(count/2, count)
+
+	select {
+	case reason := <-stuck.closed:
+		if reason != CloseReasonSendQueueFull {
+			t.Fatalf("slow conn closed with reason %s", reason)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("slow conn not closed")
+	}
+	ops := fast.ops()
+	if len(ops) != count {
+		t.Fatalf("fast conn got %d broadcasts, want %d", len(ops), count)
+	}
+	for i, op := range ops {
+		if op != byte(i) {
+			t.Fatalf("broadcast %d out of order: %d", i, op)
+		}
+	}
+}
+
+func TestKickAndSendTo(t *testing.T) {
+	conn := newFakeConn()
+	registry.add(conn)
+	defer registry.del(conn.Id())
+
+	if err := SendTo(conn.Id(), []byte{1}); err != nil || conn.sentCount() != 1 {
+		t.Fatalf("SendTo: %v sent %d", err, conn.sentCount())
+	}
+	if err := Kick(conn.Id(), "kicked"); err != nil || conn.reason != "kicked" {
+		t.Fatalf("Kick: %v reason %q", err, conn.reason)
+	}
+	missing := nextConnId()
+	if err := SendTo(missing, []byte{1}); err != ErrConnNotFound {
+		t.Fatalf("SendTo missing conn: %v", err)
+	}
+	if err := Kick(missing, "kicked"); err != ErrConnNotFound {
+		t.Fatalf("Kick missing conn: %v", err)
+	}
+}
+
+func TestBindKey(t *testing.T) {
+	a, b := newFakeConn(), newFakeConn()
+	registry.add(a)
+	registry.add(b)
+	defer registry.del(a.Id())
+
+	if err := BindKey(a.Id(), "player"); err != nil {
+		t.Fatal(err)
+	}
+	if conn, ok := GetConnByKey("player"); !ok || conn != a {
+		t.Fatal("GetConnByKey returned wrong conn")
+	}
+	if err := BindKey(b.Id(), "player"); err != ErrKeyBound {
+		t.Fatalf("bind key of another conn: %v", err)
+	}
+	if err := BindKey(nextConnId(), "ghost"); err != ErrConnNotFound {
+		t.Fatalf("bind key to missing conn: %v", err)
+	}
+	// 重新绑定时释放旧key
+	if err := BindKey(a.Id(), "renamed"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := GetConnByKey("player"); ok {
+		t.Fatal("old key still bound after rebind")
+	}
+	UnbindKey("renamed")
+	if _, ok := GetConnByKey("renamed"); ok {
+		t.Fatal("key still bound after UnbindKey")
+	}
+
+	// 连接断开时自动解绑, key可以绑定到其他连接
+	if err := BindKey(b.Id(), "player"); err != nil {
+		t.Fatal(err)
+	}
+	registry.del(b.Id())
+	if _, ok := GetConnByKey("player"); ok {
+		t.Fatal("key still bound after disconnect")
+	}
+	if err := BindKey(a.Id(), "player"); err != nil {
+		t.Fatalf("bind key released by disconnect: %v", err)
+	}
+}
@@ -0,0 +1,227 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+var ErrReplayClosed = errors.New("replay conn closed")
+
+// Recording 由CaptureConfig录制的会话
+type Recording struct {
+	Header CaptureHeader
+	Frames []*CaptureFrame
+}
+
+// LoadRecording 读取录制文件
+func LoadRecording(path string) (*Recording, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadRecording(file)
+}
+
+func ReadRecording(r io.Reader) (*Recording, error) {
+	decoder := json.NewDecoder(r)
+	rec := &Recording{}
+	if err := decoder.Decode(&rec.Header); err != nil {
+		return nil, err
+	}
+	for {
+		frame := &CaptureFrame{}
+		if err := decoder.Decode(frame); err == io.EOF {
+			return rec, nil
+		} else if err != nil {
+			return nil, err
+		}
+		rec.Frames = append(rec.Frames, frame)
+	}
+}
+
+// CloseReason 录制时连接的关闭原因, 中途停止录制时没有
+func (r *Recording) CloseReason() (CloseReason, bool) {
+	for i := len(r.Frames) - 1; i >= 0; i-- {
+		if r.Frames[i].Dir == CaptureClose {
+			return r.Frames[i].Reason, true
+		}
+	}
+	return "", false
+}
+
+type ReplayOptions struct {
+	// 连接配置, 如Authenticator, 为nil时使用默认配置. 重放时OnData总是在当前goroutine中执行
+	Config *Config
+	// 为true时按录制时的间隔发送各帧
+	RealTime bool
+}
+
+// Replay 通过ReplayConn将录制的入站帧依次交给factory创建的ConnHandler, 最后回调OnClose.
+// 重放期间ReplayConn加入在线连接, 可使用GetConn/BindKey等接口
+func Replay(rec *Recording, factory HandlerFactory, options ...*ReplayOptions) (*ReplayConn, error) {
+	opts := &ReplayOptions{}
+	if len(options) > 0 && options[0] != nil {
+		opts = options[0]
+	}
+	config := defaultConfig()
+	if opts.Config != nil {
+		config = opts.Config.clone()
+		if err := config.init(); err != nil {
+			return nil, err
+		}
+	}
+	config.Dispatch = DispatchInline
+
+	conn := newReplayConn(rec.Header.Remote)
+	registry.add(conn)
+	defer registry.del(conn.id)
+
+	handler := newConnHandler(config, conn, factory)
+	var err error
+	var last time.Duration
+	for _, frame := range rec.Frames {
+		if frame.Dir != CaptureIn {
+			continue
+		}
+		if conn.isClosed() {
+			break
+		}
+		if opts.RealTime {
+			time.Sleep(frame.Time - last)
+			last = frame.Time
+		}
+		if err = handler.OnData(append([]byte(nil), frame.Data...)); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		if reason, ok := rec.CloseReason(); ok {
+			err = &CloseError{Reason: reason}
+		}
+	}
+	closeErr, _ := conn.closer.done(err)
+	conn.finish(closeErr)
+	handler.OnClose(closeErr)
+	return conn, nil
+}
+
+// ReplayConn 重放使用的连接, 记录ConnHandler发送的数据
+type ReplayConn struct {
+	id       int64
+	remote   net.Addr
+	closer   closeState
+	mutex    sync.Mutex
+	sent     [][]byte
+	closed   bool
+	closeErr *CloseError
+}
+
+func newReplayConn(remote string) *ReplayConn {
+	return &ReplayConn{
+		id:     nextConnId(),
+		remote: replayAddr(remote),
+	}
+}
+
+func (c *ReplayConn) Id() int64 {
+	return c.id
+}
+
+func (c *ReplayConn) LocalAddr() net.Addr {
+	return replayAddr("replay")
+}
+
+// 录制时的客户端地址
+func (c *ReplayConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *ReplayConn) SendData(data []byte) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return ErrReplayClosed
+	}
+	c.sent = append(c.sent, append([]byte(nil), data...))
+	return nil
+}
+
+func (c *ReplayConn) Close(reason string) error {
+	return c.CloseWithReason(CloseReasonClosed, reason)
+}
+
+// 重放在处理完当前帧后结束
+func (c *ReplayConn) CloseWithReason(reason CloseReason, msg string) error {
+	c.closer.set(reason)
+	c.mutex.Lock()
+	c.closed = true
+	c.mutex.Unlock()
+	return nil
+}
+
+// Sent ConnHandler发送的所有数据
+func (c *ReplayConn) Sent() [][]byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([][]byte(nil), c.sent...)
+}
+
+// CloseError 重放结束时传给OnClose的错误
+func (c *ReplayConn) CloseError() *CloseError {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.closeErr
+}
+
+func (c *ReplayConn) isClosed() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.closed
+}
+
+func (c *ReplayConn) finish(err *CloseError) {
+	c.mutex.Lock()
+	c.closed = true
+	c.closeErr = err
+	c.mutex.Unlock()
+}
+
+type replayAddr string
+
+func (a replayAddr) Network() string {
+	return "replay"
+}
+
+func (a replayAddr) String() string {
+	return string(a)
+}
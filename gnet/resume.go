@@ -0,0 +1,585 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/mafei198/glib/logger"
+	"github.com/mafei198/glib/packet"
+	"net"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// 可恢复会话协议, 底层连接上的每一帧以1字节op开头:
+//
+//	客户端 -> 服务器
+//	  ResumeOpNew                                   新建会话
+//	  ResumeOpResume [token string][ack uint64]     恢复会话, ack为客户端已收到的最大seq
+//	  ResumeOpData   [seq uint64][ack uint64][data]
+//	  ResumeOpAck    [ack uint64]
+//	服务器 -> 客户端
+//	  ResumeOpNew    [token string]
+//	  ResumeOpResume [ack uint64]                   ack为服务器已收到的最大seq, 之后重发未确认的帧
+//	  ResumeOpData   [seq uint64][ack uint64][data]
+//	  ResumeOpAck    [ack uint64]                   没有数据可附带确认时, 延迟AckInterval发送
+//	  ResumeOpReject [reason string]
+//
+// 配置了Config.Authenticator时, 会话继承创建它的连接的身份及key绑定,
+// 恢复会话的新连接身份须与之一致, 否则以CloseReasonUnauthorized断开; 未配置时token是唯一凭证
+const (
+	ResumeOpNew    byte = 1
+	ResumeOpResume byte = 2
+	ResumeOpData   byte = 3
+	ResumeOpAck    byte = 4
+	ResumeOpReject byte = 5
+)
+
+var (
+	ErrResumeHandshake  = errors.New("invalid resume handshake")
+	ErrResumeNotFound   = errors.New("resume session not found")
+	ErrResumeBufferFull = errors.New("resume buffer full")
+	ErrResumeExpired    = errors.New("resume session expired")
+	ErrResumeClosed     = errors.New("resume session closed")
+	ErrResumeIdentity   = errors.New("resume identity mismatch")
+)
+
+const DefaultResumeAckInterval = 200 * time.Millisecond
+
+type ResumeConfig struct {
+	// 底层连接断开后会话保留的时间
+	GracePeriod time.Duration
+	// 最多缓存的未确认帧数, 超出时关闭会话
+	MaxPending int
+	// 收到数据后最迟多久向客户端发送确认, 默认DefaultResumeAckInterval
+	AckInterval time.Duration
+}
+
+type resumeFrame struct {
+	seq  uint64
+	data []byte
+}
+
+// 可恢复会话, 对ConnHandler而言是一个连续的Conn
+type ResumeSession struct {
+	id       int64
+	token    string
+	identity *Identity // 创建会话的连接认证得到的身份
+	mgr      *resumeMgr
+	mutex    sync.Mutex
+	sending  sync.Mutex // 保证帧按seq顺序写入底层连接, 写入时不持有mutex
+	resuming sync.Mutex // 同一时间只有一个新连接接管会话
+	conn     Conn
+	delegate ConnHandler
+	sendSeq  uint64
+	recvSeq  uint64
+	acked    uint64 // 已通知客户端的recvSeq
+	pending  []*resumeFrame
+	timer    *time.Timer
+	ackTimer *time.Timer
+	closed   bool
+}
+
+type resumeMgr struct {
+	config   *ResumeConfig
+	factory  HandlerFactory
+	mutex    sync.Mutex
+	sessions map[string]*ResumeSession
+}
+
+// 包装HandlerFactory, 使连接断开后可在GracePeriod内通过token恢复会话
+func NewResumableFactory(factory HandlerFactory, resumeConfig *ResumeConfig) HandlerFactory {
+	// 复制后填充默认值, 不修改调用方的配置
+	config := &ResumeConfig{}
+	if resumeConfig != nil {
+		*config = *resumeConfig
+	}
+	if config.GracePeriod <= 0 {
+		config.GracePeriod = 30 * time.Second
+	}
+	if config.MaxPending <= 0 {
+		config.MaxPending = 1024
+	}
+	if config.AckInterval <= 0 {
+		config.AckInterval = DefaultResumeAckInterval
+	}
+	mgr := &resumeMgr{
+		config:   config,
+		factory:  factory,
+		sessions: map[string]*ResumeSession{},
+	}
+	return func(conn Conn) ConnHandler {
+		return &resumeHandler{mgr: mgr, conn: conn}
+	}
+}
+
+func (m *resumeMgr) newSession(conn Conn, identity *Identity) (*ResumeSession, error) {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return nil, err
+	}
+	session := &ResumeSession{
+		id:       nextConnId(),
+		token:    hex.EncodeToString(buf[:]),
+		identity: identity,
+		mgr:      m,
+		conn:     conn,
+	}
+	m.mutex.Lock()
+	m.sessions[session.token] = session
+	m.mutex.Unlock()
+	registry.add(session)
+	// 底层连接由会话代表, 不再单独出现在连接注册表中
+	registry.handover(conn.Id(), session.id)
+	session.delegate = m.factory(session)
+	return session, nil
+}
+
+func (m *resumeMgr) getSession(token string) (*ResumeSession, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	session, ok := m.sessions[token]
+	return session, ok
+}
+
+func (m *resumeMgr) delSession(session *ResumeSession) {
+	m.mutex.Lock()
+	delete(m.sessions, session.token)
+	m.mutex.Unlock()
+	registry.del(session.id)
+}
+
+func (s *ResumeSession) Id() int64 {
+	return s.id
+}
+
+func (s *ResumeSession) Token() string {
+	return s.token
+}
+
+func (s *ResumeSession) LocalAddr() net.Addr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.LocalAddr()
+}
+
+func (s *ResumeSession) RemoteAddr() net.Addr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.RemoteAddr()
+}
+
+// 数据先缓存, 底层连接断开期间只缓存, 恢复后重发.
+// 写入底层连接时不持有mutex, 阻塞的写不影响接收及恢复会话
+func (s *ResumeSession) SendData(data []byte) error {
+	s.sending.Lock()
+	defer s.sending.Unlock()
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return ErrResumeClosed
+	}
+	if len(s.pending) >= s.mgr.config.MaxPending {
+		s.mutex.Unlock()
+		return ErrResumeBufferFull
+	}
+	s.sendSeq++
+	// 确认前一直保留, 不能引用调用方的buffer
+	frame := &resumeFrame{seq: s.sendSeq, data: append([]byte(nil), data...)}
+	s.pending = append(s.pending, frame)
+	conn := s.conn
+	if conn == nil {
+		s.mutex.Unlock()
+		return nil
+	}
+	writer := s.encodeData(frame)
+	s.mutex.Unlock()
+	err := conn.SendData(writer.Data())
+	writer.Release()
+	return err
+}
+
+func (s *ResumeSession) Close(reason string) error {
+	return s.CloseWithReason(CloseReasonClosed, reason)
+}
+
+func (s *ResumeSession) CloseWithReason(reason CloseReason, msg string) error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return nil
+	}
+	s.closed = true
+	conn := s.conn
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.stopAck()
+	s.mutex.Unlock()
+	if conn == nil {
+		s.terminate(&CloseError{Reason: reason, Err: ErrResumeClosed})
+		return nil
+	}
+	// 底层连接的OnClose中结束会话
+	return CloseConn(conn, reason, msg)
+}
+
+// 返回的writer发送后调用Release
+func (s *ResumeSession) encodeData(frame *resumeFrame) *packet.Packet {
+	writer := packet.AcquireWriter()
+	writer.WriteByte(ResumeOpData)
+	writer.WriteUint64(frame.seq)
+	writer.WriteUint64(s.recvSeq)
+	writer.WriteRawBytes(frame.data)
+	s.acked = s.recvSeq
+	return writer
+}
+
+// 收到客户端数据后调用, 持有mutex; 未确认的帧过多时返回true, 由调用方释放mutex后立即确认, 否则延迟确认
+func (s *ResumeSession) scheduleAck() bool {
+	if s.recvSeq-s.acked >= uint64(s.mgr.config.MaxPending/2) {
+		return true
+	}
+	if s.ackTimer == nil {
+		s.ackTimer = time.AfterFunc(s.mgr.config.AckInterval, func() {
+			s.mutex.Lock()
+			s.ackTimer = nil
+			s.mutex.Unlock()
+			s.sendAck()
+		})
+	}
+	return false
+}
+
+// 不持有mutex时调用
+func (s *ResumeSession) sendAck() {
+	s.mutex.Lock()
+	s.stopAck()
+	conn, seq := s.conn, s.recvSeq
+	if conn == nil || s.closed || seq == s.acked {
+		s.mutex.Unlock()
+		return
+	}
+	s.mutex.Unlock()
+	writer := packet.AcquireWriter()
+	defer writer.Release()
+	writer.WriteByte(ResumeOpAck)
+	writer.WriteUint64(seq)
+	if err := conn.SendData(writer.Data()); err != nil {
+		logger.WARN("resume session send ack failed: ", err)
+		return
+	}
+	s.mutex.Lock()
+	if s.conn == conn && s.acked < seq {
+		s.acked = seq
+	}
+	s.mutex.Unlock()
+}
+
+func (s *ResumeSession) stopAck() {
+	if s.ackTimer != nil {
+		s.ackTimer.Stop()
+		s.ackTimer = nil
+	}
+}
+
+// 释放客户端已确认的帧
+func (s *ResumeSession) ack(seq uint64) {
+	i := 0
+	for ; i < len(s.pending); i++ {
+		if s.pending[i].seq > seq {
+			break
+		}
+	}
+	s.pending = s.pending[i:]
+}
+
+// 新连接接管会话, 重发未确认的帧; 身份与创建会话的连接不一致时拒绝, 不影响当前连接.
+// 重发期间会话处于断开状态, 新的数据只缓存, 全部写出后才切换到新连接, 写入时不持有mutex
+func (s *ResumeSession) attach(conn Conn, identity *Identity, ack uint64) error {
+	s.resuming.Lock()
+	defer s.resuming.Unlock()
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return ErrResumeClosed
+	}
+	if !sameIdentity(s.identity, identity) {
+		s.mutex.Unlock()
+		return &CloseError{Reason: CloseReasonUnauthorized, Err: ErrResumeIdentity}
+	}
+	registry.del(conn.Id())
+	if identity != nil {
+		s.identity = identity
+		registry.setIdentity(s.id, identity)
+	}
+	old := s.conn
+	s.conn = nil
+	s.stopAck()
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.ack(ack)
+	writer := packet.AcquireWriter()
+	writer.WriteByte(ResumeOpResume)
+	writer.WriteUint64(s.recvSeq)
+	s.acked = s.recvSeq
+	writers := append(make([]*packet.Packet, 0, len(s.pending)+1), writer)
+	s.mutex.Unlock()
+	if old != nil {
+		defer old.Close("session resumed by new conn")
+	}
+
+	var sent uint64
+	for {
+		s.mutex.Lock()
+		for _, frame := range s.pending {
+			if frame.seq > sent {
+				writers = append(writers, s.encodeData(frame))
+				sent = frame.seq
+			}
+		}
+		if len(writers) == 0 || s.closed {
+			if !s.closed {
+				s.conn = conn
+			}
+			closed := s.closed
+			s.mutex.Unlock()
+			if closed {
+				return ErrResumeClosed
+			}
+			return nil
+		}
+		s.mutex.Unlock()
+		var err error
+		for _, writer := range writers {
+			if err == nil {
+				err = conn.SendData(writer.Data())
+			}
+			writer.Release()
+		}
+		writers = writers[:0]
+		if err != nil {
+			s.mutex.Lock()
+			if !s.closed {
+				s.timer = time.AfterFunc(s.mgr.config.GracePeriod, func() {
+					s.expire(err)
+				})
+			}
+			s.mutex.Unlock()
+			return err
+		}
+	}
+}
+
+// 底层连接断开, 等待恢复
+func (s *ResumeSession) detach(conn Conn, err error) {
+	s.mutex.Lock()
+	if s.conn != conn {
+		s.mutex.Unlock()
+		return
+	}
+	s.conn = nil
+	s.stopAck()
+	if s.closed {
+		s.mutex.Unlock()
+		s.terminate(err)
+		return
+	}
+	s.timer = time.AfterFunc(s.mgr.config.GracePeriod, func() {
+		s.expire(err)
+	})
+	s.mutex.Unlock()
+}
+
+func (s *ResumeSession) expire(err error) {
+	s.mutex.Lock()
+	if s.conn != nil || s.timer == nil || s.closed {
+		s.mutex.Unlock()
+		return
+	}
+	s.timer = nil
+	s.closed = true
+	s.mutex.Unlock()
+	logger.INFO("resume session expired: ", s.token, " ", err)
+	s.terminate(ErrResumeExpired)
+}
+
+// 有Key时按Key比较, 否则比较Data
+func sameIdentity(a, b *Identity) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Key != "" || b.Key != "" {
+		return a.Key == b.Key
+	}
+	return reflect.DeepEqual(a.Data, b.Data)
+}
+
+func (s *ResumeSession) terminate(err error) {
+	s.mgr.delSession(s)
+	s.delegate.OnClose(newCloseError(err))
+}
+
+// 只处理当前底层连接的数据, 已被新连接接管的旧连接上的数据直接丢弃
+func (s *ResumeSession) onData(conn Conn, data []byte) error {
+	reader := packet.Reader(data)
+	op, err := reader.ReadByte()
+	if err != nil {
+		return err
+	}
+	switch op {
+	case ResumeOpData:
+		seq, err := reader.ReadUint64()
+		if err != nil {
+			return err
+		}
+		ack, err := reader.ReadUint64()
+		if err != nil {
+			return err
+		}
+		s.mutex.Lock()
+		if s.conn != conn {
+			s.mutex.Unlock()
+			return nil
+		}
+		s.ack(ack)
+		// 客户端重发的帧
+		if seq <= s.recvSeq {
+			s.mutex.Unlock()
+			return nil
+		}
+		s.recvSeq = seq
+		ackNow := s.scheduleAck()
+		s.mutex.Unlock()
+		if ackNow {
+			s.sendAck()
+		}
+		if err := s.delegate.OnData(reader.RemainData()); err != nil {
+			// 业务层主动断开, 不再允许恢复
+			s.mutex.Lock()
+			s.closed = true
+			s.mutex.Unlock()
+			return err
+		}
+		return nil
+	case ResumeOpAck:
+		ack, err := reader.ReadUint64()
+		if err != nil {
+			return err
+		}
+		s.mutex.Lock()
+		if s.conn == conn {
+			s.ack(ack)
+		}
+		s.mutex.Unlock()
+		return nil
+	default:
+		return ErrResumeHandshake
+	}
+}
+
+// 底层连接的ConnHandler, 第一帧为新建或恢复会话的握手
+type resumeHandler struct {
+	mgr     *resumeMgr
+	conn    Conn
+	session *ResumeSession
+}
+
+func (h *resumeHandler) OnData(data []byte) error {
+	if h.session != nil {
+		return h.session.onData(h.conn, data)
+	}
+	reader := packet.Reader(data)
+	op, err := reader.ReadByte()
+	if err != nil {
+		return err
+	}
+	identity, _ := registry.getIdentity(h.conn.Id())
+	switch op {
+	case ResumeOpNew:
+		// key仍绑定在其他会话上, 与重复登录一样拒绝
+		if identity != nil && identity.Key != "" {
+			if conn, ok := registry.getByKey(identity.Key); !ok || conn.Id() != h.conn.Id() {
+				return &CloseError{Reason: CloseReasonUnauthorized, Err: ErrKeyBound}
+			}
+		}
+		session, err := h.mgr.newSession(h.conn, identity)
+		if err != nil {
+			return err
+		}
+		h.session = session
+		writer := packet.Writer()
+		writer.WriteByte(ResumeOpNew)
+		writer.WriteString(session.token)
+		return h.conn.SendData(writer.Data())
+	case ResumeOpResume:
+		token, err := reader.ReadString()
+		if err != nil {
+			return err
+		}
+		ack, err := reader.ReadUint64()
+		if err != nil {
+			return err
+		}
+		session, ok := h.mgr.getSession(token)
+		if !ok {
+			h.reject(ErrResumeNotFound)
+			return ErrResumeNotFound
+		}
+		if err := session.attach(h.conn, identity, ack); err != nil {
+			h.reject(err)
+			return err
+		}
+		h.session = session
+		return nil
+	default:
+		return ErrResumeHandshake
+	}
+}
+
+func (h *resumeHandler) reject(reason error) {
+	writer := packet.Writer()
+	writer.WriteByte(ResumeOpReject)
+	writer.WriteString(reason.Error())
+	_ = h.conn.SendData(writer.Data())
+}
+
+func (h *resumeHandler) OnClose(err error) {
+	if h.session != nil {
+		h.session.detach(h.conn, err)
+	}
+}
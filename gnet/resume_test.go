@@ -0,0 +1,308 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mafei198/glib/packet"
+)
+
+type nopHandler struct{}
+
+func (nopHandler) OnData(data []byte) error { return nil }
+func (nopHandler) OnClose(err error)        {}
+
+func newResumeSession(t *testing.T, factory HandlerFactory, conn Conn) (ConnHandler, string) {
+	handler := factory(conn)
+	if err := handler.OnData([]byte{ResumeOpNew}); err != nil {
+		t.Fatal(err)
+	}
+	session := handler.(*resumeHandler).session
+	return handler, session.Token()
+}
+
+func resumeFrameOf(op byte, token string, seq uint64) []byte {
+	writer := packet.Writer()
+	writer.WriteByte(op)
+	if op == ResumeOpResume {
+		writer.WriteString(token)
+	} else {
+		writer.WriteUint64(seq)
+	}
+	writer.WriteUint64(0)
+	return writer.Data()
+}
+
+func TestResumeSendsAck(t *testing.T) {
+	factory := NewResumableFactory(func(Conn) ConnHandler { return nopHandler{} },
+		&ResumeConfig{AckInterval: 10 * time.Millisecond})
+	conn := newFakeConn()
+	handler, _ := newResumeSession(t, factory, conn)
+	for seq := uint64(1); seq <= 3; seq++ {
+		if err := handler.OnData(resumeFrameOf(ResumeOpData, "", seq)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	ops := conn.ops()
+	if !bytes.Equal(ops, []byte{ResumeOpNew, ResumeOpAck}) {
+		t.Fatalf("expect a single delayed ack, got %v", ops)
+	}
+	reader := packet.Reader(conn.sent[1][1:])
+	if ack, _ := reader.ReadUint64(); ack != 3 {
+		t.Fatalf("expect ack 3, got %d", ack)
+	}
+}
+
+func TestResumeCopiesPending(t *testing.T) {
+	factory := NewResumableFactory(func(Conn) ConnHandler { return nopHandler{} }, &ResumeConfig{})
+	handler, _ := newResumeSession(t, factory, newFakeConn())
+	session := handler.(*resumeHandler).session
+	data := []byte("hello")
+	if err := session.SendData(data); err != nil {
+		t.Fatal(err)
+	}
+	copy(data, "XXXXX")
+	if string(session.pending[0].data) != "hello" {
+		t.Fatal("pending frame aliases caller buffer")
+	}
+}
+
+// 记录收到的数据帧数
+type recvCounter struct {
+	mutex sync.Mutex
+	data  int
+}
+
+func (h *recvCounter) OnData([]byte) error {
+	h.mutex.Lock()
+	h.data++
+	h.mutex.Unlock()
+	return nil
+}
+
+func (h *recvCounter) OnClose(error) {}
+
+func (h *recvCounter) count() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.data
+}
+
+// 会话被新连接接管后, 旧连接上迟到的数据不再交给处理器
+func TestResumeStaleConnDropped(t *testing.T) {
+	counter := &recvCounter{}
+	factory := NewResumableFactory(func(Conn) ConnHandler { return counter }, &ResumeConfig{})
+	old, token := newResumeSession(t, factory, newFakeConn())
+	current := factory(newFakeConn())
+	if err := current.OnData(resumeFrameOf(ResumeOpResume, token, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := old.OnData(resumeFrameOf(ResumeOpData, "", 1)); err != nil {
+		t.Fatal(err)
+	}
+	if counter.count() != 0 {
+		t.Fatal("data from a replaced conn was delivered")
+	}
+	if err := current.OnData(resumeFrameOf(ResumeOpData, "", 1)); err != nil {
+		t.Fatal(err)
+	}
+	if data := counter.count(); data != 1 {
+		t.Fatalf("data from the current conn delivered %d times", data)
+	}
+}
+
+// 写入时阻塞的连接
+type blockingConn struct {
+	*fakeConn
+	entered chan struct{}
+	unblock chan struct{}
+}
+
+func (c *blockingConn) SendData(data []byte) error {
+	c.entered <- struct{}{}
+	<-c.unblock
+	return c.fakeConn.SendData(data)
+}
+
+// 底层连接写阻塞时, 接收确认及恢复会话不被SendData阻塞
+func TestResumeSendOutsideLock(t *testing.T) {
+	factory := NewResumableFactory(func(Conn) ConnHandler { return nopHandler{} }, &ResumeConfig{})
+	handler, token := newResumeSession(t, factory, newFakeConn())
+	session := handler.(*resumeHandler).session
+	conn := &blockingConn{fakeConn: newFakeConn(), entered: make(chan struct{}, 1), unblock: make(chan struct{})}
+	session.mutex.Lock()
+	session.conn = conn
+	session.mutex.Unlock()
+	handler.(*resumeHandler).conn = conn
+
+	sent := make(chan error, 1)
+	go func() { sent <- session.SendData([]byte("hello")) }()
+	<-conn.entered
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.OnData(resumeFrameOf(ResumeOpAck, "", 1))
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ack blocked by a pending write")
+	}
+	go func() {
+		done <- factory(newFakeConn()).OnData(resumeFrameOf(ResumeOpResume, token, 0))
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("resume blocked by a pending write")
+	}
+	close(conn.unblock)
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 确认及重发写入底层连接时不持有mutex, 写阻塞不影响会话的其他操作
+func TestResumeAckAndAttachOutsideLock(t *testing.T) {
+	factory := NewResumableFactory(func(Conn) ConnHandler { return nopHandler{} },
+		&ResumeConfig{AckInterval: time.Millisecond})
+	conn := &blockingConn{fakeConn: newFakeConn(), entered: make(chan struct{}, 1), unblock: make(chan struct{})}
+	handler, token := newResumeSession(t, factory, newFakeConn())
+	session := handler.(*resumeHandler).session
+	session.mutex.Lock()
+	session.conn = conn
+	session.mutex.Unlock()
+	handler.(*resumeHandler).conn = conn
+
+	checkUnlocked := func(what string) {
+		done := make(chan struct{})
+		go func() {
+			_ = session.RemoteAddr()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("%s holds the session lock while writing", what)
+		}
+	}
+	if err := handler.OnData(resumeFrameOf(ResumeOpData, "", 1)); err != nil {
+		t.Fatal(err)
+	}
+	<-conn.entered
+	checkUnlocked("delayed ack")
+	conn.unblock <- struct{}{}
+
+	resumed := &blockingConn{fakeConn: newFakeConn(), entered: make(chan struct{}, 1), unblock: make(chan struct{})}
+	done := make(chan error, 1)
+	go func() { done <- factory(resumed).OnData(resumeFrameOf(ResumeOpResume, token, 0)) }()
+	<-resumed.entered
+	checkUnlocked("resume")
+	// 重发期间发送的数据在恢复响应之后按序写出
+	if err := session.SendData([]byte("late")); err != nil {
+		t.Fatal(err)
+	}
+	close(resumed.unblock)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if ops := resumed.ops(); !bytes.Equal(ops, []byte{ResumeOpResume, ResumeOpData}) {
+		t.Fatalf("unexpected frames after resume %v", ops)
+	}
+	close(conn.unblock)
+}
+
+func TestResumeConfigNotModified(t *testing.T) {
+	config := &ResumeConfig{}
+	NewResumableFactory(func(Conn) ConnHandler { return nopHandler{} }, config)
+	if *config != (ResumeConfig{}) {
+		t.Fatalf("caller config modified: %+v", config)
+	}
+}
+
+func newAuthedConn(t *testing.T, key string) *fakeConn {
+	conn := newFakeConn()
+	registry.add(conn)
+	if err := attachIdentity(conn, &Identity{Key: key}); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// 会话继承创建它的连接的身份及key, 只有相同身份的连接可以恢复
+func TestResumeIdentity(t *testing.T) {
+	factory := NewResumableFactory(func(Conn) ConnHandler { return nopHandler{} }, &ResumeConfig{})
+	owner := newAuthedConn(t, "alice")
+	handler, token := newResumeSession(t, factory, owner)
+	session := handler.(*resumeHandler).session
+	defer registry.del(session.Id())
+	if identity, ok := GetIdentity(session.Id()); !ok || identity.Key != "alice" {
+		t.Fatalf("session identity: %+v", identity)
+	}
+	if conn, ok := GetConnByKey("alice"); !ok || conn != session {
+		t.Fatal("key not bound to session")
+	}
+	if _, ok := GetConn(owner.Id()); ok {
+		t.Fatal("underlying conn still registered")
+	}
+
+	thief := newAuthedConn(t, "mallory")
+	defer registry.del(thief.Id())
+	err := factory(thief).OnData(resumeFrameOf(ResumeOpResume, token, 0))
+	if CloseReasonOf(err) != CloseReasonUnauthorized {
+		t.Fatalf("resume with another identity: %v", err)
+	}
+	if owner.reason != "" || session.conn != owner {
+		t.Fatal("rejected resume replaced the current conn")
+	}
+
+	// 同一key的新连接认证时key仍在会话上, 可以恢复但不能新建会话
+	duplicate := newAuthedConn(t, "alice")
+	defer registry.del(duplicate.Id())
+	if err = factory(duplicate).OnData([]byte{ResumeOpNew}); CloseReasonOf(err) != CloseReasonUnauthorized {
+		t.Fatalf("new session with a bound key: %v", err)
+	}
+	reconnect := newAuthedConn(t, "alice")
+	defer registry.del(reconnect.Id())
+	if err = factory(reconnect).OnData(resumeFrameOf(ResumeOpResume, token, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if owner.reason == "" || session.conn != reconnect {
+		t.Fatal("resume did not replace the old conn")
+	}
+	if conn, ok := GetConnByKey("alice"); !ok || conn != session {
+		t.Fatal("key binding lost after resume")
+	}
+}
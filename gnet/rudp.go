@@ -0,0 +1,423 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+)
+
+// 可靠UDP协议, 每个UDP包为一个segment:
+// [conv uint32][cmd byte][frg byte][seq uint32][una uint32][payload]
+// conv为会话id, una为接收方期望的下一个seq(累计确认),
+// 大消息拆分为多个segment, frg从n-1递减到0.
+// 建立会话前须先握手: 客户端发送带16字节空cookie的SYN, 服务器回复COOKIE,
+// 客户端带上cookie重发SYN, 服务器校验通过才创建会话并回复SYNACK
+const (
+	rudpCmdPush   byte = 1
+	rudpCmdAck    byte = 2
+	rudpCmdPing   byte = 3
+	rudpCmdClose  byte = 4
+	rudpCmdSyn    byte = 5
+	rudpCmdCookie byte = 6
+	rudpCmdSynAck byte = 7
+
+	rudpHeaderSize = 14
+	RUDPMtu        = 1400
+	rudpMaxPayload = RUDPMtu - rudpHeaderSize
+	rudpMaxFrags   = 256
+	rudpWindow     = 256
+	// 等待进入发送窗口的分片上限, 对端过慢或失联时不再继续堆积
+	rudpSendQueueLen = 4 * rudpMaxFrags
+	rudpInterval     = 10 * time.Millisecond
+	rudpMinRto       = 30 * time.Millisecond
+	rudpMaxRto       = 5 * time.Second
+	rudpMaxXmit      = 20
+	rudpFastResend   = 2
+
+	rudpCookieSize        = 16
+	rudpCookieLife        = 10 * time.Second
+	rudpHandshakeInterval = 300 * time.Millisecond
+	rudpHandshakeRetries  = 10
+)
+
+var (
+	ErrRUDPPacket    = errors.New("invalid rudp packet")
+	ErrRUDPTooLarge  = errors.New("rudp message too large")
+	ErrRUDPSendQueue = errors.New("rudp send queue full")
+	ErrRUDPDead      = errors.New("rudp link dead")
+	ErrRUDPClosed    = errors.New("rudp session closed")
+	ErrRUDPTimeout   = errors.New("rudp idle timeout")
+	ErrRUDPHandshake = errors.New("rudp handshake timeout")
+)
+
+type rudpSegment struct {
+	frg      byte
+	seq      uint32
+	data     []byte
+	sentAt   time.Time
+	resendAt time.Time
+	xmit     int
+	fastack  int
+}
+
+// 可靠UDP会话, 服务器和客户端共用, 通过output发送UDP包
+type rudpSession struct {
+	mutex   sync.Mutex
+	conv    uint32
+	output  func(packet []byte) error
+	maxSize int
+
+	sndNxt   uint32
+	sndQueue []*rudpSegment
+	sndBuf   []*rudpSegment
+
+	rcvNxt   uint32
+	rcvBuf   map[uint32]*rudpSegment
+	rcvFrags [][]byte
+	rcvSize  int
+	acks     []uint32
+	ackDup   bool
+
+	srtt   time.Duration
+	rttvar time.Duration
+	rto    time.Duration
+	dead   bool
+	closed bool
+}
+
+func newRUDPSession(conv uint32, maxSize int, output func([]byte) error) *rudpSession {
+	return &rudpSession{
+		conv:    conv,
+		output:  output,
+		maxSize: maxSize,
+		rcvBuf:  map[uint32]*rudpSegment{},
+		rto:     200 * time.Millisecond,
+	}
+}
+
+func parseRUDPConv(packet []byte) (uint32, bool) {
+	if len(packet) < rudpHeaderSize {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(packet), true
+}
+
+// 握手包, 不属于任何会话
+func rudpControl(conv uint32, cmd byte, payload []byte) []byte {
+	packet := make([]byte, rudpHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(packet, conv)
+	packet[4] = cmd
+	copy(packet[rudpHeaderSize:], payload)
+	return packet
+}
+
+// 拆分消息放入发送队列, 由flush发出
+func (s *rudpSession) send(data []byte) error {
+	count := (len(data) + rudpMaxPayload - 1) / rudpMaxPayload
+	if count == 0 {
+		count = 1
+	}
+	if count > rudpMaxFrags {
+		return ErrRUDPTooLarge
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed || s.dead {
+		return ErrRUDPClosed
+	}
+	if len(s.sndQueue)+count > rudpSendQueueLen {
+		return ErrRUDPSendQueue
+	}
+	for i := 0; i < count; i++ {
+		size := len(data)
+		if size > rudpMaxPayload {
+			size = rudpMaxPayload
+		}
+		// 重传前一直保留, 不能引用调用方的buffer
+		seg := &rudpSegment{frg: byte(count - i - 1), data: make([]byte, size)}
+		copy(seg.data, data)
+		s.sndQueue = append(s.sndQueue, seg)
+		data = data[size:]
+	}
+	return nil
+}
+
+// 待发送及未确认的分片数
+func (s *rudpSession) pending() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.sndQueue) + len(s.sndBuf)
+}
+
+// 处理收到的UDP包, 返回组装完成的消息.
+// 返回ErrRUDPPacket时包被丢弃, 不影响会话状态
+func (s *rudpSession) input(packet []byte) (cmd byte, msgs [][]byte, err error) {
+	if len(packet) < rudpHeaderSize || binary.BigEndian.Uint32(packet) != s.conv {
+		return 0, nil, ErrRUDPPacket
+	}
+	cmd = packet[4]
+	frg := packet[5]
+	seq := binary.BigEndian.Uint32(packet[6:])
+	una := binary.BigEndian.Uint32(packet[10:])
+	payload := packet[rudpHeaderSize:]
+	switch {
+	case cmd < rudpCmdPush || cmd > rudpCmdClose:
+		return cmd, nil, ErrRUDPPacket
+	case cmd == rudpCmdAck && len(payload)%4 != 0:
+		return cmd, nil, ErrRUDPPacket
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.ackUna(una)
+	switch cmd {
+	case rudpCmdPush:
+		if seqDiff(seq, s.rcvNxt+rudpWindow) >= 0 {
+			return cmd, nil, nil
+		}
+		// 已按序收到的重复包只需回复una, 窗口内的seq去重后逐个确认,
+		// 待确认seq最多rudpWindow个
+		if seqDiff(seq, s.rcvNxt) < 0 {
+			s.ackDup = true
+		} else {
+			s.addAck(seq)
+			if _, ok := s.rcvBuf[seq]; !ok {
+				data := make([]byte, len(payload))
+				copy(data, payload)
+				s.rcvBuf[seq] = &rudpSegment{frg: frg, seq: seq, data: data}
+			}
+		}
+		msgs, err = s.assemble()
+		return cmd, msgs, err
+	case rudpCmdAck:
+		for i := 0; i < len(payload); i += 4 {
+			s.ackSeq(binary.BigEndian.Uint32(payload[i:]))
+		}
+	case rudpCmdPing:
+	case rudpCmdClose:
+		s.closed = true
+	}
+	return cmd, nil, nil
+}
+
+func (s *rudpSession) addAck(seq uint32) {
+	for _, ack := range s.acks {
+		if ack == seq {
+			return
+		}
+	}
+	s.acks = append(s.acks, seq)
+}
+
+// 按序取出连续的segment组装成完整消息
+func (s *rudpSession) assemble() (msgs [][]byte, err error) {
+	for {
+		seg, ok := s.rcvBuf[s.rcvNxt]
+		if !ok {
+			return msgs, nil
+		}
+		delete(s.rcvBuf, s.rcvNxt)
+		s.rcvNxt++
+		s.rcvFrags = append(s.rcvFrags, seg.data)
+		s.rcvSize += len(seg.data)
+		if s.rcvSize > s.maxSize {
+			return msgs, frameTooLarge(uint64(s.rcvSize))
+		}
+		if seg.frg != 0 {
+			continue
+		}
+		msg := make([]byte, 0, s.rcvSize)
+		for _, frag := range s.rcvFrags {
+			msg = append(msg, frag...)
+		}
+		s.rcvFrags = s.rcvFrags[:0]
+		s.rcvSize = 0
+		msgs = append(msgs, msg)
+	}
+}
+
+func (s *rudpSession) ackUna(una uint32) {
+	i := 0
+	for ; i < len(s.sndBuf); i++ {
+		if seqDiff(s.sndBuf[i].seq, una) >= 0 {
+			break
+		}
+	}
+	s.sndBuf = s.sndBuf[i:]
+}
+
+func (s *rudpSession) ackSeq(seq uint32) {
+	for i, seg := range s.sndBuf {
+		if seg.seq == seq {
+			if seg.xmit == 1 {
+				s.updateRtt(time.Since(seg.sentAt))
+			}
+			s.sndBuf = append(s.sndBuf[:i], s.sndBuf[i+1:]...)
+			return
+		}
+		if seqDiff(seg.seq, seq) < 0 {
+			seg.fastack++
+		}
+	}
+}
+
+// RFC 6298
+func (s *rudpSession) updateRtt(rtt time.Duration) {
+	if s.srtt == 0 {
+		s.srtt = rtt
+		s.rttvar = rtt / 2
+	} else {
+		delta := s.srtt - rtt
+		if delta < 0 {
+			delta = -delta
+		}
+		s.rttvar = (3*s.rttvar + delta) / 4
+		s.srtt = (7*s.srtt + rtt) / 8
+	}
+	s.rto = s.srtt + 4*s.rttvar
+	if s.rto < rudpMinRto {
+		s.rto = rudpMinRto
+	} else if s.rto > rudpMaxRto {
+		s.rto = rudpMaxRto
+	}
+}
+
+// 发送确认、新数据及超时重传, 由定时器周期调用
+func (s *rudpSession) flush(now time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.dead {
+		return ErrRUDPDead
+	}
+	// 写失败不影响后续发送, 未送达的数据由超时重传补发
+	var werr error
+	if err := s.flushAcks(); err != nil {
+		werr = err
+	}
+	for len(s.sndQueue) > 0 && len(s.sndBuf) < rudpWindow {
+		seg := s.sndQueue[0]
+		s.sndQueue = s.sndQueue[1:]
+		seg.seq = s.sndNxt
+		s.sndNxt++
+		s.sndBuf = append(s.sndBuf, seg)
+	}
+	for _, seg := range s.sndBuf {
+		resend := false
+		switch {
+		case seg.xmit == 0:
+			resend = true
+		case seg.fastack >= rudpFastResend:
+			resend = true
+			seg.fastack = 0
+		case now.After(seg.resendAt):
+			resend = true
+		}
+		if !resend {
+			continue
+		}
+		if seg.xmit >= rudpMaxXmit {
+			s.dead = true
+			return ErrRUDPDead
+		}
+		seg.xmit++
+		seg.sentAt = now
+		backoff := s.rto << uint(seg.xmit-1)
+		if backoff > rudpMaxRto || backoff <= 0 {
+			backoff = rudpMaxRto
+		}
+		seg.resendAt = now.Add(backoff)
+		if err := s.write(rudpCmdPush, seg.frg, seg.seq, seg.data); err != nil && werr == nil {
+			werr = err
+		}
+	}
+	return werr
+}
+
+// 确认包按MTU拆分, 已被una覆盖的seq不再单独确认
+func (s *rudpSession) flushAcks() error {
+	if len(s.acks) == 0 && !s.ackDup {
+		return nil
+	}
+	s.ackDup = false
+	acks := s.acks[:0]
+	for _, seq := range s.acks {
+		if seqDiff(seq, s.rcvNxt) >= 0 {
+			acks = append(acks, seq)
+		}
+	}
+	s.acks = s.acks[:0]
+	var werr error
+	for {
+		n := len(acks)
+		if n > rudpMaxPayload/4 {
+			n = rudpMaxPayload / 4
+		}
+		payload := make([]byte, 4*n)
+		for i, seq := range acks[:n] {
+			binary.BigEndian.PutUint32(payload[i*4:], seq)
+		}
+		acks = acks[n:]
+		if err := s.write(rudpCmdAck, 0, 0, payload); err != nil && werr == nil {
+			werr = err
+		}
+		if len(acks) == 0 {
+			return werr
+		}
+	}
+}
+
+func (s *rudpSession) ping() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.write(rudpCmdPing, 0, 0, nil)
+}
+
+func (s *rudpSession) close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.write(rudpCmdClose, 0, 0, nil)
+}
+
+func (s *rudpSession) write(cmd, frg byte, seq uint32, payload []byte) error {
+	packet := make([]byte, rudpHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(packet, s.conv)
+	packet[4] = cmd
+	packet[5] = frg
+	binary.BigEndian.PutUint32(packet[6:], seq)
+	binary.BigEndian.PutUint32(packet[10:], s.rcvNxt)
+	copy(packet[rudpHeaderSize:], payload)
+	return s.output(packet)
+}
+
+func seqDiff(a, b uint32) int32 {
+	return int32(a - b)
+}
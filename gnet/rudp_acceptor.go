@@ -0,0 +1,268 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"github.com/mafei198/glib/logger"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const DefaultMaxRUDPSessions = 10000
+
+type RUDPAcceptor struct {
+	host     string
+	port     string
+	conn     *net.UDPConn
+	factory  HandlerFactory
+	secret   []byte
+	mutex    sync.RWMutex
+	sessions map[string]*RUDPConn
+	conns    sync.WaitGroup
+
+	closed     chan struct{}
+	acceptDone chan struct{}
+	updateDone chan struct{}
+}
+
+func init() {
+	RegisterAcceptors(ProtocolRUDP, &RUDPAcceptor{})
+}
+
+func (acceptor *RUDPAcceptor) Start(port string, factory HandlerFactory) error {
+	acceptor.factory = factory
+	acceptor.host = ""
+	acceptor.port = port
+	acceptor.sessions = map[string]*RUDPConn{}
+	acceptor.closed = make(chan struct{})
+	acceptor.acceptDone = make(chan struct{})
+	acceptor.updateDone = make(chan struct{})
+	acceptor.secret = make([]byte, sha256.Size)
+	if _, err := rand.Read(acceptor.secret); err != nil {
+		return err
+	}
+	address, err := net.ResolveUDPAddr("udp", net.JoinHostPort(acceptor.host, port))
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", address)
+	if err != nil {
+		return err
+	}
+	acceptor.conn = conn
+
+	go acceptor.startAcceptLoop()
+	go acceptor.startUpdateLoop()
+
+	return nil
+}
+
+func (acceptor *RUDPAcceptor) PrintInfo() {
+	AgentPort = strconv.Itoa(acceptor.conn.LocalAddr().(*net.UDPAddr).Port)
+	logger.INFO("RUDPAgent lis: ", AgentPort)
+}
+
+// Close 停止收包及定时器, 通知所有会话关闭后关闭socket, 所有会话退出后返回
+func (acceptor *RUDPAcceptor) Close() error {
+	close(acceptor.closed)
+	<-acceptor.updateDone
+	acceptor.mutex.RLock()
+	conns := make([]*RUDPConn, 0, len(acceptor.sessions))
+	for _, conn := range acceptor.sessions {
+		conns = append(conns, conn)
+	}
+	acceptor.mutex.RUnlock()
+	for _, conn := range conns {
+		_ = conn.CloseWithReason(CloseReasonShutdown, "rudp acceptor closed")
+		_ = conn.session.close()
+	}
+	err := acceptor.conn.Close()
+	<-acceptor.acceptDone
+	acceptor.conns.Wait()
+	return err
+}
+
+func (acceptor *RUDPAcceptor) isClosed() bool {
+	select {
+	case <-acceptor.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (acceptor *RUDPAcceptor) startAcceptLoop() {
+	logger.INFO("Game RUDPConn started!")
+	defer close(acceptor.acceptDone)
+	buffer := make([]byte, RUDPMtu)
+	for {
+		n, addr, err := acceptor.conn.ReadFromUDP(buffer)
+		if err != nil {
+			if !acceptor.isClosed() {
+				logger.ERR("RUDPAcceptor read failed: ", err)
+			}
+			break
+		}
+		packet := buffer[:n]
+		conv, ok := parseRUDPConv(packet)
+		if !ok {
+			continue
+		}
+		key := addr.String()
+		acceptor.mutex.RLock()
+		conn := acceptor.sessions[key]
+		acceptor.mutex.RUnlock()
+		if packet[4] == rudpCmdSyn {
+			acceptor.onSyn(conn, key, conv, addr, packet[rudpHeaderSize:])
+			continue
+		}
+		// 未握手或会话id不符的包直接丢弃
+		if conn == nil || conn.Conv() != conv {
+			continue
+		}
+		conn.input(packet)
+	}
+}
+
+// 不带有效cookie的SYN只回复cookie, 不保存任何状态,
+// 伪造源地址的SYN无法建立会话; SYN不小于COOKIE回复, 不会被用于反射放大
+func (acceptor *RUDPAcceptor) onSyn(conn *RUDPConn, key string, conv uint32, addr *net.UDPAddr, cookie []byte) {
+	if conn != nil {
+		// SYNACK丢失时客户端会重发SYN
+		if conn.Conv() == conv {
+			_ = acceptor.writeTo(rudpControl(conv, rudpCmdSynAck, nil), addr)
+		}
+		return
+	}
+	if len(cookie) != rudpCookieSize || !mgr.enableAcceptConn {
+		return
+	}
+	now := time.Now()
+	if !acceptor.checkCookie(conv, addr, cookie, now) {
+		_ = acceptor.writeTo(rudpControl(conv, rudpCmdCookie, acceptor.cookie(conv, addr, cookieEpoch(now))), addr)
+		return
+	}
+	if acceptor.newConn(key, conv, addr) != nil {
+		_ = acceptor.writeTo(rudpControl(conv, rudpCmdSynAck, nil), addr)
+	}
+}
+
+func cookieEpoch(now time.Time) uint64 {
+	return uint64(now.UnixNano() / int64(rudpCookieLife))
+}
+
+// cookie绑定会话id、客户端地址及时间段
+func (acceptor *RUDPAcceptor) cookie(conv uint32, addr *net.UDPAddr, epoch uint64) []byte {
+	var buf [14]byte
+	binary.BigEndian.PutUint32(buf[0:], conv)
+	binary.BigEndian.PutUint64(buf[4:], epoch)
+	binary.BigEndian.PutUint16(buf[12:], uint16(addr.Port))
+	mac := hmac.New(sha256.New, acceptor.secret)
+	mac.Write(buf[:])
+	mac.Write(addr.IP.To16())
+	return mac.Sum(nil)[:rudpCookieSize]
+}
+
+// 当前及上一时间段签发的cookie均有效
+func (acceptor *RUDPAcceptor) checkCookie(conv uint32, addr *net.UDPAddr, cookie []byte, now time.Time) bool {
+	epoch := cookieEpoch(now)
+	return hmac.Equal(cookie, acceptor.cookie(conv, addr, epoch)) ||
+		hmac.Equal(cookie, acceptor.cookie(conv, addr, epoch-1))
+}
+
+func (acceptor *RUDPAcceptor) newConn(key string, conv uint32, addr *net.UDPAddr) *RUDPConn {
+	config := getConfig()
+	ip := addr.IP.String()
+	if !connLimits.acquire(config, ip) {
+		logger.WARN("RUDPAcceptor reject conn, too many connections: ", ip)
+		return nil
+	}
+	acceptor.mutex.Lock()
+	if acceptor.isClosed() {
+		acceptor.mutex.Unlock()
+		connLimits.release(ip)
+		return nil
+	}
+	if len(acceptor.sessions) >= config.MaxRUDPSessions {
+		acceptor.mutex.Unlock()
+		connLimits.release(ip)
+		logger.WARN("RUDPAcceptor reject conn, too many sessions: ", ip)
+		return nil
+	}
+	conn := NewRUDPConn(acceptor, conv, addr)
+	conn.delegate = newConnHandler(conn.config, conn, acceptor.factory)
+	acceptor.sessions[key] = conn
+	acceptor.conns.Add(1)
+	acceptor.mutex.Unlock()
+	go func() {
+		defer acceptor.conns.Done()
+		defer connLimits.release(ip)
+		defer acceptor.remove(key)
+		conn.Start()
+	}()
+	return conn
+}
+
+func (acceptor *RUDPAcceptor) remove(key string) {
+	acceptor.mutex.Lock()
+	delete(acceptor.sessions, key)
+	acceptor.mutex.Unlock()
+}
+
+// 所有会话共用一个定时器发送确认及重传
+func (acceptor *RUDPAcceptor) startUpdateLoop() {
+	defer close(acceptor.updateDone)
+	ticker := time.NewTicker(rudpInterval)
+	defer ticker.Stop()
+	conns := make([]*RUDPConn, 0)
+	for {
+		var now time.Time
+		select {
+		case now = <-ticker.C:
+		case <-acceptor.closed:
+			return
+		}
+		conns = conns[:0]
+		acceptor.mutex.RLock()
+		for _, conn := range acceptor.sessions {
+			conns = append(conns, conn)
+		}
+		acceptor.mutex.RUnlock()
+		for _, conn := range conns {
+			conn.flush(now)
+		}
+	}
+}
+
+func (acceptor *RUDPAcceptor) writeTo(packet []byte, addr *net.UDPAddr) error {
+	_, err := acceptor.conn.WriteToUDP(packet, addr)
+	return err
+}
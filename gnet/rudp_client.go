@@ -0,0 +1,194 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+)
+
+// 可靠UDP客户端, 用于测试及机器人
+type RUDPClient struct {
+	conn      *net.UDPConn
+	session   *rudpSession
+	recv      chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func DialRUDP(address string, maxSize int) (*RUDPClient, error) {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+	var buf [4]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return nil, err
+	}
+	client := &RUDPClient{
+		conn:   conn,
+		recv:   make(chan []byte, RUDPRecvQueueLen),
+		closed: make(chan struct{}),
+	}
+	client.session = newRUDPSession(binary.BigEndian.Uint32(buf[:]), maxSize, func(packet []byte) error {
+		_, err := conn.Write(packet)
+		return err
+	})
+	if err = client.handshake(); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	go client.readLoop()
+	go client.updateLoop()
+	return client, nil
+}
+
+// 发送SYN, 收到COOKIE后带上cookie重发, 直到服务器回复SYNACK
+func (c *RUDPClient) handshake() error {
+	conv := c.session.conv
+	cookie := make([]byte, rudpCookieSize)
+	buffer := make([]byte, RUDPMtu)
+	for i := 0; i < rudpHandshakeRetries; i++ {
+		if _, err := c.conn.Write(rudpControl(conv, rudpCmdSyn, cookie)); err != nil {
+			return err
+		}
+		if err := c.conn.SetReadDeadline(time.Now().Add(rudpHandshakeInterval)); err != nil {
+			return err
+		}
+	wait:
+		for {
+			n, err := c.conn.Read(buffer)
+			if e, ok := err.(net.Error); ok && e.Timeout() {
+				break
+			} else if err != nil {
+				return err
+			}
+			if id, ok := parseRUDPConv(buffer[:n]); !ok || id != conv {
+				continue
+			}
+			switch buffer[4] {
+			case rudpCmdCookie:
+				if n == rudpHeaderSize+rudpCookieSize {
+					copy(cookie, buffer[rudpHeaderSize:n])
+					break wait
+				}
+			case rudpCmdSynAck:
+				return c.conn.SetReadDeadline(time.Time{})
+			}
+		}
+	}
+	return ErrRUDPHandshake
+}
+
+func (c *RUDPClient) Send(data []byte) error {
+	return c.session.send(data)
+}
+
+// 读取一条消息, timeout为0时一直等待
+func (c *RUDPClient) Recv(timeout time.Duration) ([]byte, error) {
+	var timer <-chan time.Time
+	if timeout > 0 {
+		timer = time.After(timeout)
+	}
+	select {
+	case data := <-c.recv:
+		return data, nil
+	case <-c.closed:
+		return nil, c.closeErr
+	case <-timer:
+		return nil, ErrRUDPTimeout
+	}
+}
+
+func (c *RUDPClient) Ping() error {
+	return c.session.ping()
+}
+
+func (c *RUDPClient) Close() error {
+	_ = c.session.close()
+	c.shutdown(ErrRUDPClosed)
+	return c.conn.Close()
+}
+
+func (c *RUDPClient) shutdown(err error) {
+	c.closeOnce.Do(func() {
+		c.closeErr = err
+		close(c.closed)
+	})
+}
+
+func (c *RUDPClient) readLoop() {
+	buffer := make([]byte, RUDPMtu)
+	for {
+		n, err := c.conn.Read(buffer)
+		if err != nil {
+			c.shutdown(err)
+			return
+		}
+		cmd, msgs, err := c.session.input(buffer[:n])
+		if err == ErrRUDPPacket {
+			continue
+		} else if err != nil {
+			c.shutdown(err)
+			return
+		}
+		if cmd == rudpCmdClose {
+			c.shutdown(ErrRUDPClosed)
+			return
+		}
+		for _, msg := range msgs {
+			select {
+			case c.recv <- msg:
+			default:
+				c.shutdown(ErrRUDPQueueFull)
+				return
+			}
+		}
+	}
+}
+
+func (c *RUDPClient) updateLoop() {
+	ticker := time.NewTicker(rudpInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			if err := c.session.flush(now); err != nil {
+				c.shutdown(err)
+				return
+			}
+		case <-c.closed:
+			return
+		}
+	}
+}
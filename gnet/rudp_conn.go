@@ -0,0 +1,289 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"errors"
+	"github.com/mafei198/glib/logger"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 接收队列容量, 处理不及时导致队列满时断开连接
+const RUDPRecvQueueLen = 1024
+
+var ErrRUDPQueueFull = errors.New("rudp recv queue full")
+
+type RUDPConn struct {
+	// 原子读写, 须在首位保证32位平台上对齐
+	lastRecv    int64
+	id          int64
+	acceptor    *RUDPAcceptor
+	remote      *net.UDPAddr
+	session     *rudpSession
+	config      *Config
+	limiter     *connLimiter
+	transformer *transformer
+	delegate    ConnHandler
+	recv        chan []byte
+	closed      chan struct{}
+	closeOnce   sync.Once
+	closeErr    error
+	closer      closeState
+	stats       *connStats
+	capture     *connCapture
+}
+
+func NewRUDPConn(acceptor *RUDPAcceptor, conv uint32, remote *net.UDPAddr) *RUDPConn {
+	rudpConn := new(RUDPConn)
+	rudpConn.id = nextConnId()
+	rudpConn.acceptor = acceptor
+	rudpConn.remote = remote
+	rudpConn.config = getConfig()
+	rudpConn.limiter = newConnLimiter(rudpConn.config)
+	rudpConn.transformer = newTransformer(rudpConn.config)
+	rudpConn.recv = make(chan []byte, RUDPRecvQueueLen)
+	rudpConn.lastRecv = time.Now().UnixNano()
+	rudpConn.closed = make(chan struct{})
+	rudpConn.stats = newConnStats(ProtocolRUDP)
+	rudpConn.capture = newConnCapture(rudpConn.config, ProtocolRUDP)
+	rudpConn.session = newRUDPSession(conv, rudpConn.config.MaxFrameSize, func(packet []byte) error {
+		return acceptor.writeTo(packet, remote)
+	})
+	return rudpConn
+}
+
+func (c *RUDPConn) Id() int64 {
+	return c.id
+}
+
+// 会话id, 由客户端生成
+func (c *RUDPConn) Conv() uint32 {
+	return c.session.conv
+}
+
+func (c *RUDPConn) LocalAddr() net.Addr {
+	return c.acceptor.conn.LocalAddr()
+}
+
+func (c *RUDPConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *RUDPConn) Start() {
+	c.StartReceiveLoop()
+}
+
+func (c *RUDPConn) StartReceiveLoop() {
+	// 在线玩家统计
+	defer atomic.AddInt32(&OnlinePlayers, -1)
+	atomic.AddInt32(&OnlinePlayers, 1)
+
+	c.stats.connect()
+	registry.add(c)
+	defer registry.del(c.id)
+	c.capture.open(c)
+
+	if c.config.HeartbeatInterval > 0 {
+		go c.heartbeat()
+	}
+
+	idleTimer := time.NewTimer(c.config.IdleTimeout)
+	defer idleTimer.Stop()
+
+	var err error
+	var data []byte
+	for {
+		if !mgr.enableAcceptMsg {
+			err = ErrShutdown
+			break
+		}
+		select {
+		case data = <-c.recv:
+		case <-idleTimer.C:
+			idle := time.Since(time.Unix(0, atomic.LoadInt64(&c.lastRecv)))
+			if idle < c.config.IdleTimeout {
+				idleTimer.Reset(c.config.IdleTimeout - idle)
+				continue
+			}
+			c.onIdle()
+			err = ErrRUDPTimeout
+		case <-c.closed:
+			err = c.closeErr
+		}
+		if err != nil {
+			break
+		}
+		c.stats.recv(len(data))
+		if !c.limiter.allow(len(data)) {
+			if onRateLimited(c.config, c) == RateLimitDrop {
+				continue
+			}
+			err = ErrRateLimited
+			break
+		}
+		if c.transformer != nil {
+			var ok bool
+			if data, ok, err = c.transformer.onFrame(c, data, c.sendFrame); err != nil {
+				break
+			} else if !ok {
+				continue
+			}
+		}
+		c.capture.in(data)
+		if err = c.delegate.OnData(data); err != nil {
+			break
+		}
+	}
+
+	closeErr, notify := c.closer.done(err)
+	if notify {
+		c.notifyClose(closeErr.Reason)
+	}
+	c.shutdown(err)
+	_ = c.session.close()
+	c.stats.disconnect(closeErr)
+	c.capture.close(closeErr.Reason)
+	c.delegate.OnClose(closeErr)
+}
+
+func (c *RUDPConn) SendData(data []byte) error {
+	c.capture.out(data)
+	if c.transformer != nil {
+		return c.transformer.send(data, c.sendFrame)
+	}
+	return c.sendFrame(data)
+}
+
+func (c *RUDPConn) sendFrame(data []byte) error {
+	if err := c.session.send(data); err != nil {
+		if err == ErrRUDPSendQueue {
+			_ = c.CloseWithReason(CloseReasonSendQueueFull, err.Error())
+		}
+		return err
+	}
+	c.stats.send(len(data))
+	return nil
+}
+
+// 连接统计快照, 发送队列为未确认的分片数
+func (c *RUDPConn) Stats() *ConnStats {
+	return c.stats.snapshot(c.id, int64(c.session.pending()))
+}
+
+func (c *RUDPConn) captureState() *connCapture {
+	return c.capture
+}
+
+func (c *RUDPConn) Close(reason string) error {
+	return c.CloseWithReason(CloseReasonClosed, reason)
+}
+
+// 按指定原因关闭, OnClose收到的CloseError带有该原因
+func (c *RUDPConn) CloseWithReason(reason CloseReason, msg string) error {
+	logger.WARN("rudp_conn disconnected: ", reason, " ", msg)
+	if c.closer.set(reason) {
+		c.notifyClose(reason)
+	}
+	c.shutdown(ErrRUDPClosed)
+	return nil
+}
+
+// 立即发出Config.CloseFrame返回的最后一帧, 会话随后关闭, 不保证送达
+func (c *RUDPConn) notifyClose(reason CloseReason) {
+	data := closeFrame(c.config, reason)
+	if data == nil {
+		return
+	}
+	if err := c.SendData(data); err != nil {
+		logger.WARN("rudp_conn send close frame failed: ", err)
+		return
+	}
+	_ = c.session.flush(time.Now())
+}
+
+func (c *RUDPConn) shutdown(err error) {
+	c.closeOnce.Do(func() {
+		c.closeErr = err
+		close(c.closed)
+	})
+}
+
+// 由acceptor的读goroutine调用
+func (c *RUDPConn) input(packet []byte) {
+	cmd, msgs, err := c.session.input(packet)
+	if err == ErrRUDPPacket {
+		// UDP源地址可伪造, 无效包直接丢弃, 不断开会话
+		return
+	} else if err != nil {
+		c.shutdown(err)
+		return
+	}
+	atomic.StoreInt64(&c.lastRecv, time.Now().UnixNano())
+	if cmd == rudpCmdClose {
+		c.shutdown(ErrRUDPClosed)
+		return
+	}
+	for _, msg := range msgs {
+		select {
+		case c.recv <- msg:
+		default:
+			c.shutdown(ErrRUDPQueueFull)
+			return
+		}
+	}
+}
+
+// 由acceptor的定时器调用
+func (c *RUDPConn) flush(now time.Time) {
+	// 发送失败的segment超时后重传, 重传次数耗尽才断开
+	if err := c.session.flush(now); err == ErrRUDPDead {
+		c.shutdown(err)
+	}
+}
+
+func (c *RUDPConn) heartbeat() {
+	ticker := time.NewTicker(c.config.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.session.ping(); err != nil {
+				logger.WARN("rudp_conn send ping failed: ", err)
+				return
+			}
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+func (c *RUDPConn) onIdle() {
+	if handler, ok := c.delegate.(IdleHandler); ok {
+		handler.OnIdle()
+	}
+}
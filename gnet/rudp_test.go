@@ -0,0 +1,349 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRUDPSessionCopiesSendBuffer(t *testing.T) {
+	var sent [][]byte
+	s := newRUDPSession(1, 1<<20, func(packet []byte) error {
+		sent = append(sent, packet)
+		return nil
+	})
+	data := []byte("hello")
+	if err := s.send(data); err != nil {
+		t.Fatal(err)
+	}
+	copy(data, "XXXXX")
+	if err := s.flush(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 1 || !bytes.Equal(sent[0][rudpHeaderSize:], []byte("hello")) {
+		t.Fatalf("queued segment should not alias caller buffer: %q", sent)
+	}
+}
+
+// 对端不确认时发送队列不无限增长
+func TestRUDPSessionSendQueueLimit(t *testing.T) {
+	s := newRUDPSession(1, 1<<20, func([]byte) error { return nil })
+	large := make([]byte, rudpMaxFrags*rudpMaxPayload)
+	for i := 0; i < rudpSendQueueLen/rudpMaxFrags; i++ {
+		if err := s.send(large); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.send([]byte("x")); err != ErrRUDPSendQueue {
+		t.Fatalf("expect ErrRUDPSendQueue, got %v", err)
+	}
+	// 进入发送窗口后腾出空间
+	_ = s.flush(time.Now())
+	if err := s.send([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRUDPSessionDropsInvalid(t *testing.T) {
+	s := newRUDPSession(1, 1<<20, func([]byte) error { return nil })
+	_ = s.send([]byte("pending"))
+	_ = s.flush(time.Now())
+	for _, packet := range [][]byte{
+		rudpControl(2, rudpCmdAck, nil),
+		rudpControl(1, rudpCmdSyn, nil),
+		rudpControl(1, rudpCmdAck, []byte{1, 2, 3}),
+	} {
+		// una为0xff的非法包不应确认任何数据
+		packet[13] = 0xff
+		if _, _, err := s.input(packet); err != ErrRUDPPacket {
+			t.Fatalf("expect ErrRUDPPacket, got %v", err)
+		}
+	}
+	if s.pending() != 1 {
+		t.Fatal("invalid packet changed session state")
+	}
+}
+
+func TestRUDPCookie(t *testing.T) {
+	acceptor := &RUDPAcceptor{secret: []byte("secret")}
+	addr := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1000}
+	now := time.Now()
+	cookie := acceptor.cookie(7, addr, cookieEpoch(now))
+	if !acceptor.checkCookie(7, addr, cookie, now.Add(rudpCookieLife)) {
+		t.Fatal("cookie from previous epoch should be valid")
+	}
+	if acceptor.checkCookie(7, addr, cookie, now.Add(2*rudpCookieLife)) {
+		t.Fatal("expired cookie accepted")
+	}
+	if acceptor.checkCookie(8, addr, cookie, now) {
+		t.Fatal("cookie accepted for another conv")
+	}
+	if acceptor.checkCookie(7, &net.UDPAddr{IP: addr.IP, Port: 1001}, cookie, now) {
+		t.Fatal("cookie accepted for another address")
+	}
+}
+
+func rudpPush(conv, seq uint32, payload []byte) []byte {
+	packet := rudpControl(conv, rudpCmdPush, payload)
+	binary.BigEndian.PutUint32(packet[6:], seq)
+	return packet
+}
+
+func TestRUDPSessionAcks(t *testing.T) {
+	var sent [][]byte
+	s := newRUDPSession(1, 1<<20, func(packet []byte) error {
+		sent = append(sent, packet)
+		return nil
+	})
+	// 乱序及重复的seq只确认一次
+	for _, seq := range []uint32{2, 2, 1, 2} {
+		if _, _, err := s.input(rudpPush(1, seq, []byte("x"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = s.flush(time.Now())
+	if len(sent) != 1 || !bytes.Equal(sent[0][rudpHeaderSize:], []byte{0, 0, 0, 2, 0, 0, 0, 1}) {
+		t.Fatalf("unexpected acks %v", sent)
+	}
+	// 按序收到后只需una, 已收到的重复包也要回复una
+	for _, seq := range []uint32{0, 1} {
+		sent = sent[:0]
+		if _, _, err := s.input(rudpPush(1, seq, []byte("x"))); err != nil {
+			t.Fatal(err)
+		}
+		_ = s.flush(time.Now())
+		if len(sent) != 1 || len(sent[0]) != rudpHeaderSize || binary.BigEndian.Uint32(sent[0][10:]) != 3 {
+			t.Fatalf("seq %d: unexpected acks %v", seq, sent)
+		}
+	}
+	sent = sent[:0]
+	_ = s.flush(time.Now())
+	if len(sent) != 0 {
+		t.Fatalf("nothing to ack, sent %v", sent)
+	}
+}
+
+func TestRUDPSessionAckSplit(t *testing.T) {
+	var sent [][]byte
+	s := newRUDPSession(1, 1<<20, func(packet []byte) error {
+		sent = append(sent, packet)
+		return nil
+	})
+	for seq := uint32(1); seq < rudpWindow; seq++ {
+		s.addAck(seq)
+	}
+	// 模拟超出单包容量的待确认seq
+	for seq := uint32(1); seq < rudpWindow; seq++ {
+		s.acks = append(s.acks, seq+rudpWindow)
+	}
+	_ = s.flushAcks()
+	acked := 0
+	for _, packet := range sent {
+		if len(packet) > RUDPMtu {
+			t.Fatalf("ack packet exceeds mtu: %d", len(packet))
+		}
+		acked += (len(packet) - rudpHeaderSize) / 4
+	}
+	if len(sent) != 2 || acked != 2*(rudpWindow-1) {
+		t.Fatalf("unexpected ack packets %d, acked %d", len(sent), acked)
+	}
+}
+
+func TestRUDPSessionAckError(t *testing.T) {
+	errAck := errors.New("ack failed")
+	var pushed int
+	s := newRUDPSession(1, 1<<20, func(packet []byte) error {
+		if packet[4] == rudpCmdAck {
+			return errAck
+		}
+		pushed++
+		return nil
+	})
+	_ = s.send([]byte("data"))
+	if _, _, err := s.input(rudpPush(1, 0, []byte("x"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.flush(time.Now()); err != errAck {
+		t.Fatalf("expect ack error, got %v", err)
+	}
+	if pushed != 1 {
+		t.Fatal("ack write error skipped data")
+	}
+}
+
+// 转发客户端与服务器之间的UDP包, 按概率丢弃数据及确认包
+type lossyUDPProxy struct {
+	conn    *net.UDPConn
+	server  *net.UDPAddr
+	loss    float64
+	rand    *rand.Rand
+	mutex   sync.Mutex
+	client  *net.UDPAddr
+	dropped int32
+}
+
+func newLossyUDPProxy(t *testing.T, server string, loss float64) *lossyUDPProxy {
+	serverAddr, err := net.ResolveUDPAddr("udp", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := &lossyUDPProxy{conn: conn, server: serverAddr, loss: loss, rand: rand.New(rand.NewSource(1))}
+	go proxy.run()
+	return proxy
+}
+
+func (p *lossyUDPProxy) run() {
+	buffer := make([]byte, 2*RUDPMtu)
+	for {
+		n, addr, err := p.conn.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+		to := p.server
+		if addr.Port == p.server.Port {
+			to = p.client
+		} else {
+			p.client = addr
+		}
+		// 握手及关闭包不重传, 不丢弃
+		cmd := buffer[4]
+		if (cmd == rudpCmdPush || cmd == rudpCmdAck) && p.rand.Float64() < p.loss {
+			atomic.AddInt32(&p.dropped, 1)
+			continue
+		}
+		if to != nil {
+			_, _ = p.conn.WriteToUDP(buffer[:n], to)
+		}
+	}
+}
+
+func TestRUDPEndToEnd(t *testing.T) {
+	handlers := make(chan *echoHandler, 2)
+	addr, stop := startTestAcceptor(t, &RUDPAcceptor{}, func(conn Conn) ConnHandler {
+		h := &echoHandler{conn: conn, closed: make(chan error, 1)}
+		handlers <- h
+		return h
+	})
+	defer stop()
+	_, port, _ := net.SplitHostPort(addr)
+	proxy := newLossyUDPProxy(t, net.JoinHostPort("127.0.0.1", port), 0.2)
+	defer proxy.conn.Close()
+
+	client, err := DialRUDP(proxy.conn.LocalAddr().String(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := <-handlers
+	// 大消息拆分为多个分片, 丢包后重传并按序重组
+	large := make([]byte, 5*rudpMaxPayload+100)
+	rand.New(rand.NewSource(2)).Read(large)
+	msgs := [][]byte{[]byte("hello"), large, []byte("rudp")}
+	for i := 0; i < 20; i++ {
+		msgs = append(msgs, []byte{byte(i)})
+	}
+	for _, msg := range msgs {
+		if err := client.Send(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, want := range msgs {
+		data, err := client.Recv(5 * time.Second)
+		if err != nil || !bytes.Equal(data, want) {
+			t.Fatalf("msg %d: got %d bytes, %v, want %d bytes", i, len(data), err, len(want))
+		}
+	}
+	if atomic.LoadInt32(&proxy.dropped) == 0 {
+		t.Fatal("no packet dropped")
+	}
+
+	// 客户端关闭, 服务器收到CLOSE后断开
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err = <-h.closed:
+	case <-time.After(time.Second):
+		t.Fatal("OnClose not called")
+	}
+	if reason := CloseReasonOf(err); reason != CloseReasonClientClosed {
+		t.Fatalf("unexpected close reason %s: %v", reason, err)
+	}
+
+	// 服务器踢下线, 客户端收到CLOSE
+	client, err = DialRUDP(proxy.conn.LocalAddr().String(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	h = <-handlers
+	if err := Kick(h.conn.Id(), "test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Recv(time.Second); err != ErrRUDPClosed {
+		t.Fatalf("client not closed: %v", err)
+	}
+	if reason := CloseReasonOf(<-h.closed); reason != CloseReasonKick {
+		t.Fatalf("unexpected close reason %s", reason)
+	}
+}
+
+func TestRUDPAcceptorClose(t *testing.T) {
+	handlers := make(chan *echoHandler, 1)
+	acceptor := &RUDPAcceptor{}
+	addr, stop := startTestAcceptor(t, acceptor, func(conn Conn) ConnHandler {
+		h := &echoHandler{conn: conn, closed: make(chan error, 1)}
+		handlers <- h
+		return h
+	})
+	_, port, _ := net.SplitHostPort(addr)
+	client, err := DialRUDP(net.JoinHostPort("127.0.0.1", port), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	h := <-handlers
+	stop()
+	if reason := CloseReasonOf(<-h.closed); reason != CloseReasonShutdown {
+		t.Fatalf("unexpected close reason %s", reason)
+	}
+	if _, err := client.Recv(time.Second); err != ErrRUDPClosed {
+		t.Fatalf("client not closed: %v", err)
+	}
+	if _, err := acceptor.conn.WriteToUDP([]byte{0}, client.conn.LocalAddr().(*net.UDPAddr)); err == nil {
+		t.Fatal("acceptor socket not closed")
+	}
+}
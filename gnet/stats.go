@@ -0,0 +1,330 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"fmt"
+	"github.com/mafei198/glib/logger"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ServerStats 按协议汇总的网络统计快照
+type ServerStats struct {
+	Protocol       string
+	Online         int64
+	Connects       int64
+	Disconnects    int64
+	ConnectRate    int64 // 最近一秒新建连接数
+	DisconnectRate int64 // 最近一秒断开连接数
+	BytesIn        int64
+	BytesOut       int64
+	FramesIn       int64
+	FramesOut      int64
+	SendQueueDepth int64 // 所有连接等待写出的帧数
+	CloseReasons   map[CloseReason]int64
+}
+
+// ConnStats 单个连接的统计快照
+type ConnStats struct {
+	Id             int64
+	Protocol       string
+	ConnectedAt    time.Time
+	BytesIn        int64
+	BytesOut       int64
+	FramesIn       int64
+	FramesOut      int64
+	SendQueueDepth int64
+}
+
+type serverStats struct {
+	online          int64
+	connects        int64
+	disconnects     int64
+	connectRate     int64
+	disconnectRate  int64
+	lastConnects    int64
+	lastDisconnects int64
+	bytesIn         int64
+	bytesOut        int64
+	framesIn        int64
+	framesOut       int64
+
+	protocol     string
+	mutex        sync.Mutex
+	closeReasons map[CloseReason]int64
+}
+
+type connStats struct {
+	bytesIn   int64
+	bytesOut  int64
+	framesIn  int64
+	framesOut int64
+	sending   int64
+	// UnixNano, 快照可能与connect并发, 原子读写
+	connectedAt int64
+
+	server *serverStats
+}
+
+// StatsConn 支持统计的连接, TCP/WebSocket/RUDP连接均已实现
+type StatsConn interface {
+	Conn
+	Stats() *ConnStats
+}
+
+var servers = struct {
+	sync.RWMutex
+	m map[string]*serverStats
+}{m: map[string]*serverStats{}}
+
+func getServerStats(protocol string) *serverStats {
+	servers.RLock()
+	s, ok := servers.m[protocol]
+	servers.RUnlock()
+	if ok {
+		return s
+	}
+	servers.Lock()
+	defer servers.Unlock()
+	if s, ok = servers.m[protocol]; !ok {
+		s = &serverStats{protocol: protocol, closeReasons: map[CloseReason]int64{}}
+		servers.m[protocol] = s
+	}
+	return s
+}
+
+func newConnStats(protocol string) *connStats {
+	return &connStats{server: getServerStats(protocol)}
+}
+
+func (s *connStats) connect() {
+	atomic.StoreInt64(&s.connectedAt, time.Now().UnixNano())
+	atomic.AddInt64(&s.server.online, 1)
+	atomic.AddInt64(&s.server.connects, 1)
+}
+
+func (s *connStats) disconnect(err error) {
+	atomic.AddInt64(&s.server.online, -1)
+	atomic.AddInt64(&s.server.disconnects, 1)
+	reason := CloseReasonOf(err)
+	s.server.mutex.Lock()
+	s.server.closeReasons[reason]++
+	s.server.mutex.Unlock()
+}
+
+func (s *connStats) recv(n int) {
+	atomic.AddInt64(&s.bytesIn, int64(n))
+	atomic.AddInt64(&s.framesIn, 1)
+	atomic.AddInt64(&s.server.bytesIn, int64(n))
+	atomic.AddInt64(&s.server.framesIn, 1)
+}
+
+func (s *connStats) send(n int) {
+	atomic.AddInt64(&s.bytesOut, int64(n))
+	atomic.AddInt64(&s.framesOut, 1)
+	atomic.AddInt64(&s.server.bytesOut, int64(n))
+	atomic.AddInt64(&s.server.framesOut, 1)
+}
+
+// 写锁外排队及正在写出的帧数
+func (s *connStats) beginSend() {
+	atomic.AddInt64(&s.sending, 1)
+}
+
+func (s *connStats) endSend() {
+	atomic.AddInt64(&s.sending, -1)
+}
+
+func (s *connStats) sendingLen() int64 {
+	return atomic.LoadInt64(&s.sending)
+}
+
+func (s *connStats) snapshot(id int64, queueLen int64) *ConnStats {
+	var connectedAt time.Time
+	if at := atomic.LoadInt64(&s.connectedAt); at != 0 {
+		connectedAt = time.Unix(0, at)
+	}
+	return &ConnStats{
+		Id:             id,
+		Protocol:       s.server.protocol,
+		ConnectedAt:    connectedAt,
+		BytesIn:        atomic.LoadInt64(&s.bytesIn),
+		BytesOut:       atomic.LoadInt64(&s.bytesOut),
+		FramesIn:       atomic.LoadInt64(&s.framesIn),
+		FramesOut:      atomic.LoadInt64(&s.framesOut),
+		SendQueueDepth: queueLen,
+	}
+}
+
+func (s *serverStats) snapshot() *ServerStats {
+	stats := &ServerStats{
+		Protocol:       s.protocol,
+		Online:         atomic.LoadInt64(&s.online),
+		Connects:       atomic.LoadInt64(&s.connects),
+		Disconnects:    atomic.LoadInt64(&s.disconnects),
+		ConnectRate:    atomic.LoadInt64(&s.connectRate),
+		DisconnectRate: atomic.LoadInt64(&s.disconnectRate),
+		BytesIn:        atomic.LoadInt64(&s.bytesIn),
+		BytesOut:       atomic.LoadInt64(&s.bytesOut),
+		FramesIn:       atomic.LoadInt64(&s.framesIn),
+		FramesOut:      atomic.LoadInt64(&s.framesOut),
+		CloseReasons:   map[CloseReason]int64{},
+	}
+	s.mutex.Lock()
+	for reason, count := range s.closeReasons {
+		stats.CloseReasons[reason] = count
+	}
+	s.mutex.Unlock()
+	return stats
+}
+
+// 每秒采样一次连接/断开速率
+func (s *serverStats) sample() {
+	connects := atomic.LoadInt64(&s.connects)
+	disconnects := atomic.LoadInt64(&s.disconnects)
+	atomic.StoreInt64(&s.connectRate, connects-s.lastConnects)
+	atomic.StoreInt64(&s.disconnectRate, disconnects-s.lastDisconnects)
+	s.lastConnects = connects
+	s.lastDisconnects = disconnects
+}
+
+// Stats 返回各协议的统计快照, 按协议名排序
+func Stats() []*ServerStats {
+	servers.RLock()
+	result := make([]*ServerStats, 0, len(servers.m))
+	index := make(map[string]*ServerStats, len(servers.m))
+	for _, s := range servers.m {
+		stats := s.snapshot()
+		index[s.protocol] = stats
+		result = append(result, stats)
+	}
+	servers.RUnlock()
+	for _, conn := range registry.snapshot() {
+		if sc, ok := conn.(StatsConn); ok {
+			stats := sc.Stats()
+			if server, ok := index[stats.Protocol]; ok {
+				server.SendQueueDepth += stats.SendQueueDepth
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Protocol < result[j].Protocol
+	})
+	return result
+}
+
+// GetConnStats 按连接id获取单个连接的统计快照
+func GetConnStats(id int64) (*ConnStats, bool) {
+	conn, ok := registry.get(id)
+	if !ok {
+		return nil, false
+	}
+	sc, ok := conn.(StatsConn)
+	if !ok {
+		return nil, false
+	}
+	return sc.Stats(), true
+}
+
+// 采样速率, 按Config.StatusLogInterval输出日志, Stop时退出
+func statsLoop(config *Config, stopped chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	lastLog := time.Now()
+	for {
+		select {
+		case now := <-ticker.C:
+			servers.RLock()
+			for _, s := range servers.m {
+				s.sample()
+			}
+			servers.RUnlock()
+			if config.StatusLogInterval > 0 && now.Sub(lastLog) >= config.StatusLogInterval {
+				lastLog = now
+				logStats()
+			}
+		case <-stopped:
+			return
+		}
+	}
+}
+
+func logStats() {
+	for _, s := range Stats() {
+		logger.INFO("Network Status ", s.Protocol, " CCU: ", s.Online,
+			" FramesIn: ", s.FramesIn, " FramesOut: ", s.FramesOut,
+			" BytesIn: ", s.BytesIn, " BytesOut: ", s.BytesOut,
+			" SendQueue: ", s.SendQueueDepth)
+	}
+}
+
+type metric struct {
+	name  string
+	kind  string
+	help  string
+	value func(s *ServerStats) int64
+}
+
+var metrics = []metric{
+	{"gnet_online", "gauge", "Current online connections.", func(s *ServerStats) int64 { return s.Online }},
+	{"gnet_connects_total", "counter", "Accepted connections.", func(s *ServerStats) int64 { return s.Connects }},
+	{"gnet_disconnects_total", "counter", "Closed connections.", func(s *ServerStats) int64 { return s.Disconnects }},
+	{"gnet_connect_rate", "gauge", "Connections accepted in the last second.", func(s *ServerStats) int64 { return s.ConnectRate }},
+	{"gnet_disconnect_rate", "gauge", "Connections closed in the last second.", func(s *ServerStats) int64 { return s.DisconnectRate }},
+	{"gnet_bytes_in_total", "counter", "Received frame bytes.", func(s *ServerStats) int64 { return s.BytesIn }},
+	{"gnet_bytes_out_total", "counter", "Sent frame bytes.", func(s *ServerStats) int64 { return s.BytesOut }},
+	{"gnet_frames_in_total", "counter", "Received frames.", func(s *ServerStats) int64 { return s.FramesIn }},
+	{"gnet_frames_out_total", "counter", "Sent frames.", func(s *ServerStats) int64 { return s.FramesOut }},
+	{"gnet_send_queue_depth", "gauge", "Frames waiting to be written.", func(s *ServerStats) int64 { return s.SendQueueDepth }},
+}
+
+// StatsHandler 以Prometheus文本格式导出统计数据
+func StatsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		stats := Stats()
+		for _, m := range metrics {
+			fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+			for _, s := range stats {
+				fmt.Fprintf(w, "%s{protocol=%q} %d\n", m.name, s.Protocol, m.value(s))
+			}
+		}
+		fmt.Fprintf(w, "# HELP gnet_close_reasons_total Closed connections by reason.\n# TYPE gnet_close_reasons_total counter\n")
+		for _, s := range stats {
+			reasons := make([]string, 0, len(s.CloseReasons))
+			for reason := range s.CloseReasons {
+				reasons = append(reasons, string(reason))
+			}
+			sort.Strings(reasons)
+			for _, reason := range reasons {
+				fmt.Fprintf(w, "gnet_close_reasons_total{protocol=%q,reason=%q} %d\n", s.Protocol, reason, s.CloseReasons[CloseReason(reason)])
+			}
+		}
+	})
+}
@@ -0,0 +1,161 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 统计按协议全局累计, 每次测试使用新的协议名
+func testProtocol(name string) string {
+	return name + "_" + strconv.FormatInt(nextConnId(), 10)
+}
+
+// 带统计的测试连接
+type statsConn struct {
+	*fakeConn
+	stats *connStats
+}
+
+func (c *statsConn) Stats() *ConnStats {
+	return c.stats.snapshot(c.id, c.stats.sendingLen())
+}
+
+func serverStatsOf(t *testing.T, protocol string) *ServerStats {
+	for _, s := range Stats() {
+		if s.Protocol == protocol {
+			return s
+		}
+	}
+	t.Fatalf("no stats for %s", protocol)
+	return nil
+}
+
+func TestConnStatsCounters(t *testing.T) {
+	protocol := testProtocol("test_counters")
+	conn := &statsConn{fakeConn: newFakeConn(), stats: newConnStats(protocol)}
+	before := time.Now()
+	conn.stats.connect()
+	registry.add(conn)
+	defer registry.del(conn.id)
+	conn.stats.recv(10)
+	conn.stats.recv(20)
+	conn.stats.send(5)
+	conn.stats.beginSend()
+
+	snap, ok := GetConnStats(conn.id)
+	if !ok {
+		t.Fatal("conn stats not found")
+	}
+	if snap.Protocol != protocol || snap.BytesIn != 30 || snap.FramesIn != 2 || snap.BytesOut != 5 ||
+		snap.FramesOut != 1 || snap.SendQueueDepth != 1 || snap.ConnectedAt.Before(before) {
+		t.Fatalf("unexpected conn stats %+v", snap)
+	}
+	server := serverStatsOf(t, protocol)
+	if server.Online != 1 || server.Connects != 1 || server.BytesIn != 30 || server.FramesOut != 1 || server.SendQueueDepth != 1 {
+		t.Fatalf("unexpected server stats %+v", server)
+	}
+
+	conn.stats.endSend()
+	conn.stats.disconnect(ErrRateLimited)
+	server = serverStatsOf(t, protocol)
+	if server.Online != 0 || server.Disconnects != 1 || server.SendQueueDepth != 0 ||
+		server.CloseReasons[CloseReasonRateLimited] != 1 {
+		t.Fatalf("unexpected server stats after disconnect %+v", server)
+	}
+}
+
+func TestServerStatsSample(t *testing.T) {
+	s := newConnStats(testProtocol("test_sample"))
+	for i := 0; i < 3; i++ {
+		s.connect()
+	}
+	s.disconnect(nil)
+	s.server.sample()
+	if stats := s.server.snapshot(); stats.ConnectRate != 3 || stats.DisconnectRate != 1 {
+		t.Fatalf("unexpected rates %+v", stats)
+	}
+	s.server.sample()
+	if stats := s.server.snapshot(); stats.ConnectRate != 0 || stats.DisconnectRate != 0 {
+		t.Fatalf("rates not reset %+v", stats)
+	}
+}
+
+// 注册表中的连接可能在connect之前被快照, 不能与connect竞争
+func TestConnStatsSnapshotDuringConnect(t *testing.T) {
+	conn := &statsConn{fakeConn: newFakeConn(), stats: newConnStats(testProtocol("test_race"))}
+	registry.add(conn)
+	defer registry.del(conn.id)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			Stats()
+		}
+	}()
+	// 不引入同步, 让快照与connect在-race下可能交错
+	time.Sleep(time.Millisecond)
+	conn.stats.connect()
+	wg.Wait()
+	if conn.Stats().ConnectedAt.IsZero() {
+		t.Fatal("connectedAt not recorded")
+	}
+}
+
+func TestStatsHandler(t *testing.T) {
+	protocol := testProtocol("test_exporter")
+	s := newConnStats(protocol)
+	s.connect()
+	s.recv(7)
+	s.disconnect(ErrRUDPTimeout)
+	s.connect()
+
+	w := httptest.NewRecorder()
+	StatsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Fatalf("content type %q", ct)
+	}
+	body := w.Body.String()
+	for _, line := range []string{
+		"# TYPE gnet_online gauge",
+		"# TYPE gnet_connects_total counter",
+		`gnet_online{protocol="` + protocol + `"} 1`,
+		`gnet_connects_total{protocol="` + protocol + `"} 2`,
+		`gnet_disconnects_total{protocol="` + protocol + `"} 1`,
+		`gnet_bytes_in_total{protocol="` + protocol + `"} 7`,
+		`gnet_frames_in_total{protocol="` + protocol + `"} 1`,
+		`gnet_close_reasons_total{protocol="` + protocol + `",reason="timeout"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatalf("missing %q in:\n%s", line, body)
+		}
+	}
+}
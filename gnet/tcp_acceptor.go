@@ -27,6 +27,13 @@ import (
 	"github.com/mafei198/glib/logger"
 	"net"
 	"strconv"
+	"time"
+)
+
+// accept出错(如EMFILE)时的退避时间, 与net/http.Server.Serve一致
+const (
+	minAcceptDelay = 5 * time.Millisecond
+	maxAcceptDelay = time.Second
 )
 
 type TcpAcceptor struct {
@@ -63,22 +70,82 @@ func (acceptor *TcpAcceptor) PrintInfo() {
 
 func (acceptor *TcpAcceptor) startAcceptLoop() {
 	logger.INFO("Game TCPConn started!")
+	var delay time.Duration
 	for {
 		// 新连接
 		conn, err := acceptor.listener.Accept()
 		//logger.INFO("TcpAcceptor accepted new conn")
 		if err != nil {
-			logger.ERR("TcpAcceptor accept failed: ", err)
+			if !mgr.enableAcceptConn {
+				break
+			}
+			delay = acceptBackoff(delay)
+			logger.ERR("TcpAcceptor accept failed: ", err, ", retrying in ", delay)
+			time.Sleep(delay)
+			continue
 		}
+		delay = 0
 
 		if !mgr.enableAcceptConn {
+			_ = conn.Close()
 			break
 		}
 
-		tcpConn := NewTcpConn(conn)
-		tcpConn.delegate = acceptor.factory(tcpConn)
-		go tcpConn.Start()
+		go acceptor.handleConn(conn)
 	}
 
 	_ = acceptor.listener.Close()
 }
+
+// 检查连接数限制并读取PROXY头部, 通过时返回真实客户端IP, 连接断开后须调用connLimits.release(ip)
+func admitConn(config *Config, conn net.Conn) (net.Conn, string, bool) {
+	// 先占用连接数再读取PROXY头部, 避免慢速连接绕过限制
+	ip := addrIP(conn.RemoteAddr())
+	if !connLimits.acquire(config, ip) {
+		logger.WARN("TcpAcceptor reject conn, too many connections: ", ip)
+		_ = conn.Close()
+		return nil, "", false
+	}
+	if config.ProxyProtocol {
+		proxyConn, err := readProxyHeader(conn)
+		if err != nil {
+			logger.WARN("TcpAcceptor read proxy header failed: ", conn.RemoteAddr(), " ", err)
+			connLimits.release(ip)
+			_ = conn.Close()
+			return nil, "", false
+		}
+		conn = proxyConn
+		realIP := addrIP(conn.RemoteAddr())
+		if !connLimits.rekey(config, ip, realIP) {
+			logger.WARN("TcpAcceptor reject conn, too many connections: ", realIP)
+			connLimits.release(ip)
+			_ = conn.Close()
+			return nil, "", false
+		}
+		ip = realIP
+	}
+	return conn, ip, true
+}
+
+// 下一次重试accept前的等待时间, 从minAcceptDelay开始翻倍
+func acceptBackoff(delay time.Duration) time.Duration {
+	if delay == 0 {
+		return minAcceptDelay
+	}
+	if delay *= 2; delay > maxAcceptDelay {
+		delay = maxAcceptDelay
+	}
+	return delay
+}
+
+func (acceptor *TcpAcceptor) handleConn(conn net.Conn) {
+	conn, ip, ok := admitConn(getConfig(), conn)
+	if !ok {
+		return
+	}
+	defer connLimits.release(ip)
+
+	tcpConn := NewTcpConn(conn)
+	tcpConn.delegate = newConnHandler(tcpConn.config, tcpConn, acceptor.factory)
+	tcpConn.Start()
+}
@@ -25,14 +25,13 @@ THE SOFTWARE.
 package gnet
 
 import (
-	"encoding/binary"
+	"bufio"
 	"errors"
-	"fmt"
 	"github.com/mafei198/glib/logger"
 	"github.com/mafei198/glib/packet"
-	"io"
 	"math"
 	"net"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -41,16 +40,53 @@ import (
 const MaxIncomingPacket = math.MaxInt16
 
 type TCPConn struct {
-	conn     net.Conn
-	delegate ConnHandler
+	id          int64
+	conn        net.Conn
+	reader      *bufio.Reader
+	config      *Config
+	limiter     *connLimiter
+	transformer *transformer
+	delegate    ConnHandler
+	wmutex      sync.Mutex
+	closing     int32 // 已开始关闭, 之后的写最多等待closeNotifyTimeout
+	closed      chan struct{}
+	closer      closeState
+	stats       *connStats
+	capture     *connCapture
 }
 
 func NewTcpConn(conn net.Conn) *TCPConn {
+	tcpConn := newTCPConn(conn, ProtocolTCP)
+	tcpConn.reader = bufio.NewReader(conn)
+	return tcpConn
+}
+
+// 不带读缓冲, 事件循环模式下由EpollConn负责读取
+func newTCPConn(conn net.Conn, protocol string) *TCPConn {
 	tcpConn := new(TCPConn)
+	tcpConn.id = nextConnId()
 	tcpConn.conn = conn
+	tcpConn.config = getConfig()
+	tcpConn.limiter = newConnLimiter(tcpConn.config)
+	tcpConn.transformer = newTransformer(tcpConn.config)
+	tcpConn.closed = make(chan struct{})
+	tcpConn.stats = newConnStats(protocol)
+	tcpConn.capture = newConnCapture(tcpConn.config, protocol)
 	return tcpConn
 }
 
+func (c *TCPConn) Id() int64 {
+	return c.id
+}
+
+func (c *TCPConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *TCPConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
 func (c *TCPConn) Start() {
 	c.StartReceiveLoop()
 
@@ -60,81 +96,190 @@ func (c *TCPConn) Start() {
 }
 
 func (c *TCPConn) StartReceiveLoop() {
-	header := make([]byte, Packet)
-
 	// 在线玩家统计
 	defer atomic.AddInt32(&OnlinePlayers, -1)
 	atomic.AddInt32(&OnlinePlayers, 1)
 
+	c.stats.connect()
+	registry.add(c)
+	defer registry.del(c.id)
+	c.capture.open(c)
+
+	if c.config.HeartbeatInterval > 0 && c.config.TcpPing {
+		go c.heartbeat()
+	}
+	defer close(c.closed)
+
 	var err error
 	var data []byte
 	for {
 		if !mgr.enableAcceptMsg {
+			err = ErrShutdown
 			break
 		}
-		data, err = c.receive(header)
+		data, err = c.receive()
 		if err != nil {
+			if isTimeout(err) {
+				c.onIdle()
+			}
 			break
 		}
-		if err = c.onData(data); err != nil {
+		if err = c.handleFrame(c, data); err != nil {
 			break
 		}
 	}
 
-	if err != nil {
-		//logger.WARN("tcp_conn disconnected: ", err)
+	closeErr, notify := c.closer.done(err)
+	if notify {
+		c.notifyClose(closeErr.Reason)
 	}
+	c.stats.disconnect(closeErr)
+	c.capture.close(closeErr.Reason)
+	c.onClose(closeErr)
+}
 
-	c.onClose(err)
+// 处理收到的一帧, conn为对外暴露的连接, 用于限流回调及密钥交换
+func (c *TCPConn) handleFrame(conn Conn, data []byte) error {
+	c.stats.recv(len(data))
+	// 心跳帧
+	if len(data) == 0 && c.config.TcpPing {
+		packet.PutBuffer(data)
+		return nil
+	}
+	if !c.limiter.allow(len(data)) {
+		if onRateLimited(c.config, conn) == RateLimitDrop {
+			packet.PutBuffer(data)
+			return nil
+		}
+		return ErrRateLimited
+	}
+	if c.transformer != nil {
+		var ok bool
+		var err error
+		if data, ok, err = c.transformer.onFrame(conn, data, c.sendFrame); err != nil || !ok {
+			return err
+		}
+	}
+	return c.onData(data)
 }
 
 // 发送消息
 func (c *TCPConn) SendData(data []byte) error {
-	writer := packet.Writer()
-	writer.WriteInt32((int32)(len(data)))
-	writer.WriteRawBytes(data)
-	_, err := c.conn.Write(writer.Data())
+	c.capture.out(data)
+	if c.transformer != nil {
+		return c.transformer.send(data, c.sendFrame)
+	}
+	return c.sendFrame(data)
+}
+
+func (c *TCPConn) sendFrame(data []byte) error {
+	frame, err := appendFrame(c.config.Codec, data)
+	if err != nil {
+		return err
+	}
+	err = c.write(frame)
+	packet.PutBuffer(frame)
+	if err != nil {
+		return err
+	}
+	c.stats.send(len(data))
+	return nil
+}
+
+func (c *TCPConn) write(data []byte) error {
+	c.stats.beginSend()
+	defer c.stats.endSend()
+	c.wmutex.Lock()
+	defer c.wmutex.Unlock()
+	if timeout := writeTimeout(c.config, &c.closing); timeout > 0 {
+		if err := c.conn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+			return err
+		}
+	}
+	_, err := c.conn.Write(data)
 	return err
 }
 
+// 定时发送0长度ping帧
+func (c *TCPConn) heartbeat() {
+	ping, err := c.config.Codec.AppendFrame(nil, nil)
+	if err != nil {
+		logger.ERR("tcp_conn encode ping failed: ", err)
+		return
+	}
+	ticker := time.NewTicker(c.config.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.write(ping); err != nil {
+				logger.WARN("tcp_conn send ping failed: ", err)
+				return
+			}
+		case <-c.closed:
+			return
+		}
+	}
+}
+
 func (c *TCPConn) Close(reason string) error {
-	logger.WARN("tcp_conn disconnected: ", reason)
+	return c.CloseWithReason(CloseReasonClosed, reason)
+}
+
+// 按指定原因关闭, OnClose收到的CloseError带有该原因;
+// 需要发送CloseFrame时在新goroutine中发送后关闭, 不阻塞调用方
+func (c *TCPConn) CloseWithReason(reason CloseReason, msg string) error {
+	logger.WARN("tcp_conn disconnected: ", reason, " ", msg)
+	if c.closer.set(reason) && closeFrame(c.config, reason) != nil {
+		go func() {
+			c.notifyClose(reason)
+			_ = c.conn.Close()
+		}()
+		return nil
+	}
 	return c.conn.Close()
 }
 
+// 发送Config.CloseFrame返回的最后一帧
+func (c *TCPConn) notifyClose(reason CloseReason) {
+	data := closeFrame(c.config, reason)
+	if data == nil {
+		return
+	}
+	atomic.StoreInt32(&c.closing, 1)
+	if err := c.SendData(data); err != nil {
+		logger.WARN("tcp_conn send close frame failed: ", err)
+	}
+}
+
 // 获取请求数据
-func (c *TCPConn) receive(header []byte) ([]byte, error) {
+func (c *TCPConn) receive() ([]byte, error) {
 	// 设置读取数据超时时间
-	err := c.conn.SetReadDeadline(time.Now().Add(ReadTimeout))
+	err := c.conn.SetReadDeadline(time.Now().Add(c.config.IdleTimeout))
 	if err != nil {
 		logger.ERR("Receive data timeout: ", err)
 		return nil, err
 	}
 
-	// 从消息中取最前面4个字节header
-	_, err = io.ReadFull(c.conn, header)
+	data, err := c.config.Codec.ReadFrame(c.reader, c.config.MaxFrameSize)
 	if err != nil {
-		//logger.ERR("Receive data head failed: ", err)
-		return nil, err
-	}
-
-	// header得到消息总长度
-	size := binary.BigEndian.Uint32(header)
-	if size > MaxIncomingPacket {
-		err := fmt.Sprintln("exceed max incomming packet size: ", size)
-		logger.ERR(err)
-		return nil, errors.New(err)
-	}
-	// 构建对应消息长度的字节切片用于接收消息
-	data := make([]byte, size)
-	_, err = io.ReadFull(c.conn, data)
-	if err != nil {
-		logger.ERR("Receive data body failed: ", err)
+		if errors.Is(err, ErrFrameTooLarge) {
+			logger.ERR("exceed max incomming packet size: ", err)
+		}
 		return nil, err
 	}
 	return data, nil
 }
 
+// 连接统计快照
+func (c *TCPConn) Stats() *ConnStats {
+	return c.stats.snapshot(c.id, c.stats.sendingLen())
+}
+
+func (c *TCPConn) captureState() *connCapture {
+	return c.capture
+}
+
 // 清理
 func (c *TCPConn) cleanup() {
 	_ = c.conn.Close()
@@ -142,10 +287,17 @@ func (c *TCPConn) cleanup() {
 
 // 接受消息
 func (c *TCPConn) onData(data []byte) error {
+	c.capture.in(data)
 	return c.delegate.OnData(data)
 }
 
+func (c *TCPConn) onIdle() {
+	if handler, ok := c.delegate.(IdleHandler); ok {
+		handler.OnIdle()
+	}
+}
+
 // 断开连接
-func (c *TCPConn) onClose(err error) {
+func (c *TCPConn) onClose(err *CloseError) {
 	c.delegate.OnClose(err)
 }
@@ -0,0 +1,107 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 记录收到的数据帧数及空闲、断开回调
+type idleRecorder struct {
+	data   int32
+	idle   chan struct{}
+	closed chan struct{}
+}
+
+func newIdleRecorder() *idleRecorder {
+	return &idleRecorder{idle: make(chan struct{}), closed: make(chan struct{})}
+}
+
+func (h *idleRecorder) OnData([]byte) error {
+	atomic.AddInt32(&h.data, 1)
+	return nil
+}
+
+func (h *idleRecorder) OnIdle()       { close(h.idle) }
+func (h *idleRecorder) OnClose(error) { close(h.closed) }
+
+// 服务器定时发送ping, 客户端的ping只刷新空闲时间, 停止发送后触发OnIdle并断开
+func TestTCPConnHeartbeat(t *testing.T) {
+	saved := mgr
+	mgr = &Mgr{enableAcceptConn: true, enableAcceptMsg: true}
+	defer func() { mgr = saved }()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := NewTcpConn(server)
+	conn.config = defaultConfig()
+	conn.config.IdleTimeout = 100 * time.Millisecond
+	conn.config.HeartbeatInterval = 10 * time.Millisecond
+	conn.config.TcpPing = true
+	handler := newIdleRecorder()
+	conn.delegate = handler
+	go conn.Start()
+
+	ping := make([]byte, Packet)
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(client, ping); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range ping {
+		if b != 0 {
+			t.Fatalf("unexpected ping frame %v", ping)
+		}
+	}
+	_ = client.SetReadDeadline(time.Time{})
+	go io.Copy(ioutil.Discard, client)
+
+	for i := 0; i < 5; i++ {
+		if _, err := client.Write(ping); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(40 * time.Millisecond)
+	}
+	select {
+	case <-handler.idle:
+		t.Fatal("pings did not keep the conn alive")
+	default:
+	}
+	if atomic.LoadInt32(&handler.data) != 0 {
+		t.Fatal("ping frames delivered to OnData")
+	}
+
+	for _, ch := range []chan struct{}{handler.idle, handler.closed} {
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Fatal("idle conn not closed")
+		}
+	}
+}
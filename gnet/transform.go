@@ -0,0 +1,421 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"github.com/golang/snappy"
+	"github.com/mafei198/glib/misc"
+	"github.com/mafei198/glib/packet"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+	"io"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+)
+
+// 启用压缩、加密或分片后, 每帧数据前增加1字节标志位
+const (
+	FlagGzip      byte = 1 << 0
+	FlagSnappy    byte = 1 << 1
+	FlagEncrypted byte = 1 << 2
+	FlagChunk     byte = 1 << 3
+
+	flagsMask = FlagGzip | FlagSnappy | FlagEncrypted | FlagChunk
+)
+
+type Compression int
+
+const (
+	CompressNone Compression = iota
+	CompressGzip
+	CompressSnappy
+)
+
+var (
+	ErrInvalidFlags     = errors.New("invalid frame flags")
+	ErrHandshakePending = errors.New("key exchange not finished")
+	ErrDecrypt          = errors.New("decrypt frame failed")
+	ErrServerKey        = errors.New("server key verification failed")
+)
+
+// 密钥交换, 连接收到的第一帧交给Exchange处理, reply不加密发回客户端,
+// 返回的secret经HKDF为两个方向各派生一个AES-256-GCM密钥
+type KeyExchanger interface {
+	Exchange(conn Conn, data []byte) (secret []byte, reply []byte, err error)
+}
+
+// 客户端一侧的密钥交换, 每个连接使用单独的实例
+type ClientKeyExchanger interface {
+	// 返回发给服务器的第一帧数据
+	Hello() ([]byte, error)
+	// 处理服务器的回复, 返回与服务器一致的secret
+	Finish(reply []byte) (secret []byte, err error)
+}
+
+const x25519SignContext = "gnet x25519"
+
+// 客户端发送32字节X25519公钥, 服务器回复自己的临时公钥, 双方以共享密钥作为secret.
+// 临时公钥本身无法防止中间人攻击, 公网使用时须设置SigningKey,
+// 客户端以预置的服务器签名公钥校验, 见NewX25519ClientExchange
+type X25519KeyExchange struct {
+	// 不为nil时回复附带对双方公钥的ed25519签名
+	SigningKey ed25519.PrivateKey
+}
+
+func (x *X25519KeyExchange) Exchange(conn Conn, data []byte) ([]byte, []byte, error) {
+	if len(data) != curve25519.PointSize {
+		return nil, nil, errors.New("invalid x25519 public key")
+	}
+	private, public, err := x25519Keypair()
+	if err != nil {
+		return nil, nil, err
+	}
+	shared, err := curve25519.X25519(private, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if x.SigningKey != nil {
+		public = append(public, ed25519.Sign(x.SigningKey, x25519SignMessage(data, public))...)
+	}
+	return shared, public, nil
+}
+
+type x25519ClientExchange struct {
+	serverKey ed25519.PublicKey
+	private   []byte
+	public    []byte
+}
+
+// X25519KeyExchange对应的客户端, serverKey为服务器SigningKey的公钥,
+// 为nil时不校验服务器身份, 只应在可信网络中使用
+func NewX25519ClientExchange(serverKey ed25519.PublicKey) ClientKeyExchanger {
+	return &x25519ClientExchange{serverKey: serverKey}
+}
+
+func (x *x25519ClientExchange) Hello() ([]byte, error) {
+	var err error
+	if x.private, x.public, err = x25519Keypair(); err != nil {
+		return nil, err
+	}
+	return x.public, nil
+}
+
+func (x *x25519ClientExchange) Finish(reply []byte) ([]byte, error) {
+	if x.private == nil || len(reply) < curve25519.PointSize {
+		return nil, errors.New("invalid x25519 reply")
+	}
+	public, sign := reply[:curve25519.PointSize], reply[curve25519.PointSize:]
+	if x.serverKey != nil {
+		if len(sign) != ed25519.SignatureSize || !ed25519.Verify(x.serverKey, x25519SignMessage(x.public, public), sign) {
+			return nil, ErrServerKey
+		}
+	}
+	return curve25519.X25519(x.private, public)
+}
+
+func x25519Keypair() (private, public []byte, err error) {
+	private = make([]byte, curve25519.ScalarSize)
+	if _, err = rand.Read(private); err != nil {
+		return nil, nil, err
+	}
+	public, err = curve25519.X25519(private, curve25519.Basepoint)
+	return private, public, err
+}
+
+func x25519SignMessage(client, server []byte) []byte {
+	msg := make([]byte, 0, len(x25519SignContext)+len(client)+len(server))
+	msg = append(msg, x25519SignContext...)
+	msg = append(msg, client...)
+	return append(msg, server...)
+}
+
+// 两个方向使用不同的密钥, nonce为各自的帧计数, 不随帧发送,
+// 重放、乱序、丢弃或反射回来的帧都会解密失败
+type cipherState struct {
+	sendSeq uint64 // 由transformer.smutex保护
+	recvSeq uint64 // 只在读goroutine中访问
+	send    cipher.AEAD
+	recv    cipher.AEAD
+}
+
+func newCipherState(secret []byte, client bool) (*cipherState, error) {
+	c2s, err := deriveAEAD(secret, "gnet client to server")
+	if err != nil {
+		return nil, err
+	}
+	s2c, err := deriveAEAD(secret, "gnet server to client")
+	if err != nil {
+		return nil, err
+	}
+	if client {
+		return &cipherState{send: c2s, recv: s2c}, nil
+	}
+	return &cipherState{send: s2c, recv: c2s}, nil
+}
+
+func deriveAEAD(secret []byte, info string) (cipher.AEAD, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, nil, []byte(info)), key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func counterNonce(seq uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], seq)
+	return nonce
+}
+
+// 单连接的压缩/加密/分片处理
+type transformer struct {
+	config *Config
+	client bool
+	keyed  bool
+	cipher atomic.Value // *cipherState
+	// 加密与发送须在同一把锁内完成, 保证帧按nonce顺序到达对端
+	smutex   sync.Mutex
+	chunkSeq uint32
+	chunks   *chunkAssembler
+}
+
+func newTransformer(config *Config) *transformer {
+	if config.Compression == CompressNone && config.KeyExchange == nil && config.ChunkSize <= 0 {
+		return nil
+	}
+	return &transformer{config: config, keyed: config.KeyExchange != nil, chunks: newChunkAssembler(config.MaxMessageSize)}
+}
+
+// 客户端使用, 密钥交换在连接建立后完成
+func newClientTransformer(config *Config) *transformer {
+	if config.Compression == CompressNone && config.ClientKeyExchange == nil && config.ChunkSize <= 0 {
+		return nil
+	}
+	return &transformer{config: config, client: true, keyed: config.ClientKeyExchange != nil,
+		chunks: newChunkAssembler(config.MaxMessageSize)}
+}
+
+func (t *transformer) cipherState() *cipherState {
+	state, _ := t.cipher.Load().(*cipherState)
+	return state
+}
+
+func (t *transformer) handshakePending() bool {
+	return t.keyed && t.cipherState() == nil
+}
+
+// 由密钥交换得到的secret启用加密
+func (t *transformer) setSecret(secret []byte) error {
+	state, err := newCipherState(secret, t.client)
+	if err != nil {
+		return err
+	}
+	t.cipher.Store(state)
+	return nil
+}
+
+// 处理收到的帧, ok为false时表示该帧为密钥交换帧或未收齐的分片, 不交给OnData
+func (t *transformer) onFrame(conn Conn, frame []byte, sendFrame func([]byte) error) (data []byte, ok bool, err error) {
+	if !t.handshakePending() {
+		data, ok, err = t.decode(frame)
+		// 解密或解压得到新的buffer, 原始帧不再使用
+		if err != nil || !sameBuffer(data, frame) {
+			packet.PutBuffer(frame)
+		}
+		return data, ok && err == nil, err
+	}
+	if t.client || len(frame) == 0 || frame[0] != 0 {
+		return nil, false, ErrInvalidFlags
+	}
+	return nil, false, t.handshake(conn, frame[1:], sendFrame)
+}
+
+// 密钥交换并回复客户端, 启用加密到回复写出期间持有发送锁,
+// 并发的SendData不会先于回复发出客户端尚无法解密的帧
+func (t *transformer) handshake(conn Conn, data []byte, sendFrame func([]byte) error) error {
+	secret, reply, err := t.config.KeyExchange.Exchange(conn, data)
+	if err != nil {
+		return err
+	}
+	state, err := newCipherState(secret, t.client)
+	if err != nil {
+		return err
+	}
+	t.smutex.Lock()
+	defer t.smutex.Unlock()
+	t.cipher.Store(state)
+	return sendFrame(append([]byte{0}, reply...))
+}
+
+func (t *transformer) send(data []byte, sendFrame func([]byte) error) error {
+	if t.config.ChunkSize > 0 && len(data) > t.config.ChunkSize {
+		return t.sendChunks(data, sendFrame)
+	}
+	return t.sendFrame(data, sendFrame)
+}
+
+// 压缩并加密后交给sendFrame发送
+func (t *transformer) sendFrame(data []byte, sendFrame func([]byte) error) error {
+	if t.handshakePending() {
+		return ErrHandshakePending
+	}
+	data, flags, err := t.compress(data)
+	if err != nil {
+		return err
+	}
+	return t.seal(data, flags, sendFrame)
+}
+
+// 超过CompressThreshold时压缩, 返回压缩后的数据及对应标志位
+func (t *transformer) compress(data []byte) ([]byte, byte, error) {
+	if len(data) < t.config.CompressThreshold {
+		return data, 0, nil
+	}
+	switch t.config.Compression {
+	case CompressGzip:
+		data, err := misc.Gzip(data)
+		return data, FlagGzip, err
+	case CompressSnappy:
+		return snappy.Encode(nil, data), FlagSnappy, nil
+	}
+	return data, 0, nil
+}
+
+// 加上标志位, 已完成密钥交换时加密
+func (t *transformer) seal(data []byte, flags byte, sendFrame func([]byte) error) error {
+	var err error
+	state := t.cipherState()
+	if state == nil {
+		frame := packet.GetBuffer(len(data) + 1)[:0]
+		frame = append(frame, flags)
+		frame = append(frame, data...)
+		err = sendFrame(frame)
+		packet.PutBuffer(frame)
+		return err
+	}
+	flags |= FlagEncrypted
+	frame := packet.GetBuffer(1 + len(data) + state.send.Overhead())[:1]
+	frame[0] = flags
+	t.smutex.Lock()
+	defer t.smutex.Unlock()
+	frame = state.send.Seal(frame, counterNonce(state.sendSeq), data, frame[:1])
+	state.sendSeq++
+	err = sendFrame(frame)
+	packet.PutBuffer(frame)
+	return err
+}
+
+// 解密并解压, 分片帧在收齐后才返回整条消息, ok为false表示消息未完整
+func (t *transformer) decode(frame []byte) ([]byte, bool, error) {
+	if len(frame) == 0 {
+		return nil, false, ErrInvalidFlags
+	}
+	flags, data := frame[0], frame[1:]
+	if flags&^flagsMask != 0 || flags&(FlagGzip|FlagSnappy) == FlagGzip|FlagSnappy {
+		return nil, false, ErrInvalidFlags
+	}
+	state := t.cipherState()
+	// 启用密钥交换后不接受明文帧
+	if (state != nil) != (flags&FlagEncrypted != 0) {
+		return nil, false, ErrInvalidFlags
+	}
+	var err error
+	if state != nil {
+		if data, err = state.recv.Open(nil, counterNonce(state.recvSeq), data, frame[:1]); err != nil {
+			return nil, false, ErrDecrypt
+		}
+		state.recvSeq++
+	}
+	maxSize := t.config.MaxFrameSize
+	if flags&FlagChunk != 0 {
+		if t.config.ChunkSize <= 0 {
+			return nil, false, ErrInvalidFlags
+		}
+		var ok bool
+		if data, ok, err = t.chunks.add(flags, data); err != nil || !ok {
+			return nil, false, err
+		}
+		maxSize = t.config.MaxMessageSize
+	}
+	data, err = decompress(flags, data, maxSize)
+	return data, err == nil, err
+}
+
+// 按标志位解压, 限制解压后的长度
+func decompress(flags byte, data []byte, maxSize int) ([]byte, error) {
+	switch {
+	case flags&FlagGzip != 0:
+		return gunzip(data, maxSize)
+	case flags&FlagSnappy != 0:
+		size, err := snappy.DecodedLen(data)
+		if err != nil {
+			return nil, err
+		}
+		if size > maxSize {
+			return nil, frameTooLarge(uint64(size))
+		}
+		return snappy.Decode(nil, data)
+	}
+	return data, nil
+}
+
+// b是否为a的切片, 即共用同一块内存
+func sameBuffer(a, b []byte) bool {
+	if cap(a) == 0 || cap(b) == 0 {
+		return false
+	}
+	return &a[:cap(a)][cap(a)-1] == &b[:cap(b)][cap(b)-1]
+}
+
+// 解压时限制最大长度, 避免压缩炸弹
+func gunzip(data []byte, maxSize int) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	result, err := ioutil.ReadAll(io.LimitReader(reader, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(result) > maxSize {
+		return nil, frameTooLarge(uint64(len(result)))
+	}
+	return result, nil
+}
@@ -0,0 +1,174 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+type frameSink struct {
+	frames [][]byte
+}
+
+func (s *frameSink) send(frame []byte) error {
+	s.frames = append(s.frames, append([]byte(nil), frame...))
+	return nil
+}
+
+// 返回完成密钥交换的服务器与客户端transformer
+func newTransformerPair(t *testing.T, signing ed25519.PrivateKey, pinned ed25519.PublicKey) (*transformer, *transformer, error) {
+	server := &Config{KeyExchange: &X25519KeyExchange{SigningKey: signing}, Compression: CompressSnappy}
+	client := &Config{ClientKeyExchange: func() ClientKeyExchanger { return NewX25519ClientExchange(pinned) },
+		Compression: CompressSnappy}
+	if err := server.init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.init(); err != nil {
+		t.Fatal(err)
+	}
+	st, ct := newTransformer(server), newClientTransformer(client)
+	exchange := client.ClientKeyExchange()
+	hello, err := exchange.Hello()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &frameSink{}
+	if _, ok, err := st.onFrame(nil, append([]byte{0}, hello...), sink.send); ok || err != nil {
+		t.Fatalf("handshake frame: ok=%v err=%v", ok, err)
+	}
+	secret, err := exchange.Finish(sink.frames[0][1:])
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = ct.setSecret(secret); err != nil {
+		t.Fatal(err)
+	}
+	return st, ct, nil
+}
+
+func TestTransformEncrypted(t *testing.T) {
+	public, private, _ := ed25519.GenerateKey(rand.Reader)
+	st, ct, err := newTransformerPair(t, private, public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := bytes.Repeat([]byte("hello gnet "), 50)
+	for _, pair := range [][2]*transformer{{ct, st}, {st, ct}} {
+		sink := &frameSink{}
+		if err := pair[0].send(msg, sink.send); err != nil {
+			t.Fatal(err)
+		}
+		got, ok, err := pair[1].onFrame(nil, sink.frames[0], nil)
+		if err != nil || !ok {
+			t.Fatalf("ok=%v err=%v", ok, err)
+		}
+		if !bytes.Equal(got, msg) {
+			t.Fatal("message mismatch")
+		}
+	}
+}
+
+// 密钥交换回复写出前, 并发发送的加密帧不能先发出
+func TestTransformHandshakeReplyFirst(t *testing.T) {
+	server := &Config{KeyExchange: &X25519KeyExchange{}}
+	if err := server.init(); err != nil {
+		t.Fatal(err)
+	}
+	st := newTransformer(server)
+	hello, err := NewX25519ClientExchange(nil).Hello()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mutex sync.Mutex
+	var frames [][]byte
+	replying := make(chan struct{})
+	sent := make(chan error, 1)
+	go func() {
+		<-replying
+		sent <- st.send([]byte("push"), func(frame []byte) error {
+			mutex.Lock()
+			frames = append(frames, append([]byte(nil), frame...))
+			mutex.Unlock()
+			return nil
+		})
+	}()
+	_, _, err = st.onFrame(nil, append([]byte{0}, hello...), func(frame []byte) error {
+		close(replying)
+		time.Sleep(20 * time.Millisecond)
+		mutex.Lock()
+		frames = append(frames, append([]byte(nil), frame...))
+		mutex.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = <-sent; err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 2 || frames[0][0] != 0 || frames[1][0]&FlagEncrypted == 0 {
+		t.Fatalf("unexpected frame order %v", frames)
+	}
+}
+
+func TestTransformRejectReplay(t *testing.T) {
+	st, ct, err := newTransformerPair(t, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &frameSink{}
+	_ = ct.send([]byte("first"), sink.send)
+	_ = ct.send([]byte("second"), sink.send)
+	if _, _, err := st.onFrame(nil, append([]byte(nil), sink.frames[0]...), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := st.onFrame(nil, append([]byte(nil), sink.frames[0]...), nil); err != ErrDecrypt {
+		t.Fatalf("replayed frame: %v", err)
+	}
+
+	// 反射回发送方的帧使用另一方向的密钥, 同样解密失败
+	st, ct, _ = newTransformerPair(t, nil, nil)
+	sink = &frameSink{}
+	_ = ct.send([]byte("reflect"), sink.send)
+	if _, _, err := ct.onFrame(nil, sink.frames[0], nil); err != ErrDecrypt {
+		t.Fatalf("reflected frame: %v", err)
+	}
+}
+
+func TestX25519ServerKeyPinned(t *testing.T) {
+	_, private, _ := ed25519.GenerateKey(rand.Reader)
+	other, _, _ := ed25519.GenerateKey(rand.Reader)
+	if _, _, err := newTransformerPair(t, private, other); err != ErrServerKey {
+		t.Fatalf("expect ErrServerKey, got %v", err)
+	}
+	if _, _, err := newTransformerPair(t, nil, other); err != ErrServerKey {
+		t.Fatalf("unsigned reply should be rejected, got %v", err)
+	}
+}
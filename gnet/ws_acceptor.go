@@ -28,16 +28,45 @@ import (
 	"github.com/mafei198/glib/logger"
 	"net"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 )
 
 type WSConnHandler func(conn *websocket.Conn)
 
+const (
+	WSBinary = websocket.BinaryMessage
+	WSText   = websocket.TextMessage
+)
+
+type WSConfig struct {
+	// 为nil时使用独立的ServeMux, 不再注册到http.DefaultServeMux
+	Mux *http.ServeMux
+	// WebSocket升级路径, 默认"/"
+	Path string
+	// 允许的Origin列表, 按不含端口的主机名或完整Origin匹配,
+	// 支持"*"及"*.example.com"形式, 为空时只允许同源请求
+	AllowedOrigins []string
+	// 服务器支持的子协议, 按客户端请求顺序协商
+	Subprotocols []string
+	// 启用permessage-deflate
+	EnableCompression bool
+	// 单条消息最大长度, 默认Config.MaxFrameSize
+	ReadLimit       int64
+	ReadBufferSize  int
+	WriteBufferSize int
+	// SendData使用的消息类型, 默认WSBinary; 启用压缩/加密/分片时总是WSBinary
+	MessageType int
+}
+
 type WSAcceptor struct {
 	host     string
 	port     string
 	listener net.Listener
 	factory  HandlerFactory
+	config   *WSConfig
+	upgrader *websocket.Upgrader
 }
 
 func init() {
@@ -46,6 +75,16 @@ func init() {
 
 func (acceptor *WSAcceptor) Start(port string, factory HandlerFactory) error {
 	acceptor.factory = factory
+	acceptor.config = getConfig().WS
+	acceptor.upgrader = &websocket.Upgrader{
+		ReadBufferSize:    acceptor.config.ReadBufferSize,
+		WriteBufferSize:   acceptor.config.WriteBufferSize,
+		Subprotocols:      acceptor.config.Subprotocols,
+		EnableCompression: acceptor.config.EnableCompression,
+	}
+	if len(acceptor.config.AllowedOrigins) > 0 {
+		acceptor.upgrader.CheckOrigin = acceptor.config.checkOrigin
+	}
 
 	acceptor.host = ""
 	acceptor.port = port
@@ -67,8 +106,12 @@ func (acceptor *WSAcceptor) PrintInfo() {
 }
 
 func (acceptor *WSAcceptor) startAcceptLoop() {
-	http.HandleFunc("/", acceptor.wsHandler)
-	if err := http.Serve(acceptor.listener, nil); err != nil {
+	mux := acceptor.config.Mux
+	if mux == nil {
+		mux = http.NewServeMux()
+	}
+	mux.HandleFunc(acceptor.config.Path, acceptor.wsHandler)
+	if err := http.Serve(acceptor.listener, mux); err != nil {
 		logger.ERR("start WSConn failed: ", err)
 		panic(err)
 	}
@@ -79,15 +122,83 @@ func (acceptor *WSAcceptor) wsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	config := getConfig()
+	remoteAddr := forwardedAddr(config, r)
+	ip := addrIP(remoteAddr)
+	if !connLimits.acquire(config, ip) {
+		logger.WARN("WSAcceptor reject conn, too many connections: ", ip)
+		http.Error(w, "too many connections", http.StatusServiceUnavailable)
+		return
+	}
+
+	wsAuth, preAuth := config.Authenticator.(WSAuthenticator)
+	var identity *Identity
+	if preAuth {
+		var err error
+		if identity, err = wsAuth.AuthenticateRequest(r); err != nil {
+			connLimits.release(ip)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+
 	logger.INFO("WSConn accepted new conn")
-	upgrader := websocket.Upgrader{}
-	conn, err := upgrader.Upgrade(w, r, nil)
+	conn, err := acceptor.upgrader.Upgrade(w, r, nil)
 	if err != nil {
+		connLimits.release(ip)
 		logger.ERR("upgrade:", err)
 		return
 	}
 
 	wsConn := NewWSConn(conn)
-	wsConn.delegate = acceptor.factory(wsConn)
-	go wsConn.Start()
+	wsConn.remoteAddr = remoteAddr
+	if preAuth {
+		// 上线并记录身份后才创建ConnHandler
+		wsConn.identity = identity
+		wsConn.factory = acceptor.factory
+	} else {
+		wsConn.delegate = newConnHandler(wsConn.config, wsConn, acceptor.factory)
+	}
+	go func() {
+		defer connLimits.release(ip)
+		wsConn.Start()
+	}()
+}
+
+func (c *WSConfig) init(maxFrameSize int) {
+	if c.Path == "" {
+		c.Path = "/"
+	}
+	if c.ReadLimit <= 0 {
+		c.ReadLimit = int64(maxFrameSize)
+	}
+	if c.MessageType != WSText {
+		c.MessageType = WSBinary
+	}
+}
+
+// 没有Origin头的请求(非浏览器客户端)直接放行
+func (c *WSConfig) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	host := origin
+	if u, err := url.Parse(origin); err == nil && u.Host != "" {
+		host = u.Hostname()
+	}
+	for _, allowed := range c.AllowedOrigins {
+		switch {
+		case allowed == "*":
+			return true
+		case strings.EqualFold(allowed, origin), strings.EqualFold(allowed, host):
+			return true
+		case strings.HasPrefix(allowed, "*."):
+			suffix := strings.ToLower(allowed[1:])
+			if strings.HasSuffix(strings.ToLower(host), suffix) {
+				return true
+			}
+		}
+	}
+	return false
 }
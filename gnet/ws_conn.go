@@ -25,24 +25,68 @@ THE SOFTWARE.
 package gnet
 
 import (
+	"errors"
 	"github.com/gorilla/websocket"
 	"github.com/mafei198/glib/logger"
+	"github.com/mafei198/glib/packet"
+	"net"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
 type WSConn struct {
-	mt       int
-	conn     *websocket.Conn
-	delegate ConnHandler
+	id          int64
+	mt          int
+	conn        *websocket.Conn
+	remoteAddr  net.Addr
+	config      *Config
+	limiter     *connLimiter
+	transformer *transformer
+	delegate    ConnHandler
+	wmutex      sync.Mutex
+	closing     int32 // 已开始关闭, 之后的写最多等待closeNotifyTimeout
+	closed      chan struct{}
+	closer      closeState
+	stats       *connStats
+	identity    *Identity
+	factory     HandlerFactory
+	capture     *connCapture
 }
 
+var ErrWSTextTransformed = errors.New("ws text message not allowed with transformer")
+
 func NewWSConn(conn *websocket.Conn) *WSConn {
 	wsConn := new(WSConn)
+	wsConn.id = nextConnId()
 	wsConn.conn = conn
+	wsConn.config = getConfig()
+	wsConn.mt = wsConn.config.WS.MessageType
+	conn.SetReadLimit(wsConn.config.WS.ReadLimit)
+	wsConn.limiter = newConnLimiter(wsConn.config)
+	wsConn.transformer = newTransformer(wsConn.config)
+	wsConn.closed = make(chan struct{})
+	wsConn.stats = newConnStats(ProtocolWS)
+	wsConn.capture = newConnCapture(wsConn.config, ProtocolWS)
 	return wsConn
 }
 
+func (c *WSConn) Id() int64 {
+	return c.id
+}
+
+func (c *WSConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// 经可信代理转发时为X-Forwarded-For中的客户端地址
+func (c *WSConn) RemoteAddr() net.Addr {
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.conn.RemoteAddr()
+}
+
 func (c *WSConn) Start() {
 	c.StartReceiveLoop()
 
@@ -56,36 +100,207 @@ func (c *WSConn) StartReceiveLoop() {
 	defer atomic.AddInt32(&OnlinePlayers, -1)
 	atomic.AddInt32(&OnlinePlayers, 1)
 
-	var mt int
+	c.stats.connect()
+	registry.add(c)
+	defer registry.del(c.id)
+	c.capture.open(c)
+
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(c.config.IdleTimeout))
+	})
+	if c.config.HeartbeatInterval > 0 {
+		go c.heartbeat()
+	}
+	defer close(c.closed)
+
 	var data []byte
 	var err error
-	for {
+	// 升级前已通过WSAuthenticator认证
+	if c.delegate == nil {
+		if err = attachIdentity(c, c.identity); err != nil {
+			err = &CloseError{Reason: CloseReasonUnauthorized, Err: err}
+		} else {
+			c.delegate = wrapDispatch(c.config, c, c.factory(c))
+		}
+	}
+	for err == nil {
 		if !mgr.enableAcceptMsg {
+			err = ErrShutdown
 			break
 		}
-		_ = c.conn.SetReadDeadline(time.Now().Add(ReadTimeout))
-		mt, data, err = c.conn.ReadMessage()
-		c.mt = mt
+		_ = c.conn.SetReadDeadline(time.Now().Add(c.config.IdleTimeout))
+		data, err = c.readMessage()
 		if err != nil {
+			if isTimeout(err) {
+				c.onIdle()
+			}
+			break
+		}
+		c.stats.recv(len(data))
+		if !c.limiter.allow(len(data)) {
+			if onRateLimited(c.config, c) == RateLimitDrop {
+				packet.PutBuffer(data)
+				continue
+			}
+			err = ErrRateLimited
 			break
 		}
+		if c.transformer != nil {
+			var ok bool
+			if data, ok, err = c.transformer.onFrame(c, data, c.sendFrame); err != nil {
+				break
+			} else if !ok {
+				continue
+			}
+		}
+		c.capture.in(data)
 		if err = c.delegate.OnData(data); err != nil {
 			break
 		}
 	}
 	logger.WARN("ws_conn disconnected: ", err)
-	c.onClose(err)
+	closeErr, notify := c.closer.done(err)
+	if notify {
+		c.notifyClose(closeErr.Reason)
+	}
+	c.stats.disconnect(closeErr)
+	c.capture.close(closeErr.Reason)
+	c.onClose(closeErr)
+}
+
+// 与ReadMessage相同, 数据读入池中buffer
+func (c *WSConn) readMessage() ([]byte, error) {
+	_, r, err := c.conn.NextReader()
+	if err != nil {
+		return nil, err
+	}
+	return readMessage(r)
+}
+
+func (c *WSConn) captureState() *connCapture {
+	return c.capture
 }
 
 func (c *WSConn) Close(reason string) error {
-	logger.WARN("ws_conn disconnected: ", reason)
+	return c.CloseWithReason(CloseReasonClosed, reason)
+}
+
+// 按指定原因关闭, 客户端会收到对应状态码的close控制帧
+func (c *WSConn) CloseWithReason(reason CloseReason, msg string) error {
+	logger.WARN("ws_conn disconnected: ", reason, " ", msg)
+	if c.closer.set(reason) && reason.serverSide() {
+		// 在新goroutine中通知后关闭, 不阻塞调用方
+		go func() {
+			c.notifyClose(reason)
+			_ = c.conn.Close()
+		}()
+		return nil
+	}
 	return c.conn.Close()
 }
 
+// 发送Config.CloseFrame返回的最后一帧及close控制帧
+func (c *WSConn) notifyClose(reason CloseReason) {
+	if !reason.serverSide() {
+		return
+	}
+	atomic.StoreInt32(&c.closing, 1)
+	if data := closeFrame(c.config, reason); data != nil {
+		if err := c.SendData(data); err != nil {
+			logger.WARN("ws_conn send close frame failed: ", err)
+		}
+	}
+	msg := websocket.FormatCloseMessage(reason.wsCode(), string(reason))
+	_ = c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(closeNotifyTimeout))
+}
+
 func (c *WSConn) SendData(data []byte) error {
-	return c.conn.WriteMessage(c.mt, data)
+	c.capture.out(data)
+	return c.sendMessage(c.mt, data)
+}
+
+// 协商得到的子协议
+func (c *WSConn) Subprotocol() string {
+	return c.conn.Subprotocol()
+}
+
+// 忽略WSConfig.MessageType, 以文本消息发送;
+// 启用压缩/加密/分片时帧内容不是合法UTF-8, 返回ErrWSTextTransformed
+func (c *WSConn) SendText(data []byte) error {
+	if c.transformer != nil {
+		return ErrWSTextTransformed
+	}
+	return c.sendMessage(WSText, data)
+}
+
+// 忽略WSConfig.MessageType, 以二进制消息发送
+func (c *WSConn) SendBinary(data []byte) error {
+	return c.sendMessage(WSBinary, data)
 }
 
-func (c *WSConn) onClose(err error) {
+func (c *WSConn) sendMessage(mt int, data []byte) error {
+	if c.transformer != nil {
+		return c.transformer.send(data, c.sendFrame)
+	}
+	return c.writeMessage(mt, data)
+}
+
+// 变换后的帧总是以二进制消息发送
+func (c *WSConn) sendFrame(data []byte) error {
+	return c.writeMessage(WSBinary, data)
+}
+
+// websocket.Conn不支持并发写, SendTo/BroadcastAll可能在其他goroutine调用
+func (c *WSConn) writeMessage(mt int, data []byte) error {
+	c.stats.beginSend()
+	defer c.stats.endSend()
+	c.wmutex.Lock()
+	defer c.wmutex.Unlock()
+	if timeout := writeTimeout(c.config, &c.closing); timeout > 0 {
+		if err := c.conn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+			return err
+		}
+	}
+	if err := c.conn.WriteMessage(mt, data); err != nil {
+		return err
+	}
+	c.stats.send(len(data))
+	return nil
+}
+
+// 连接统计快照
+func (c *WSConn) Stats() *ConnStats {
+	return c.stats.snapshot(c.id, c.stats.sendingLen())
+}
+
+// 定时发送websocket ping控制帧, 收到pong时刷新空闲时间
+func (c *WSConn) heartbeat() {
+	ticker := time.NewTicker(c.config.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			deadline := time.Now().Add(c.config.HeartbeatInterval)
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+				logger.WARN("ws_conn send ping failed: ", err)
+				return
+			}
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+func (c *WSConn) onIdle() {
+	if handler, ok := c.delegate.(IdleHandler); ok {
+		handler.OnIdle()
+	}
+}
+
+// 认证失败时没有ConnHandler
+func (c *WSConn) onClose(err *CloseError) {
+	if c.delegate == nil {
+		return
+	}
 	c.delegate.OnClose(err)
 }
@@ -0,0 +1,75 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gnet
+
+import (
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWSTransformedFramesBinary(t *testing.T) {
+	saved := mgr
+	config := &Config{Compression: CompressGzip, WS: &WSConfig{MessageType: WSText}}
+	if err := config.init(); err != nil {
+		t.Fatal(err)
+	}
+	mgr = &Mgr{config: config, enableAcceptConn: true, enableAcceptMsg: true}
+	defer func() { mgr = saved }()
+
+	errs := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			errs <- err
+			return
+		}
+		conn := NewWSConn(ws)
+		if err := conn.SendText([]byte("text")); err != ErrWSTextTransformed {
+			errs <- err
+			return
+		}
+		errs <- conn.SendData([]byte("hello"))
+	}))
+	defer server.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	mt, _, err := client.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mt != WSBinary {
+		t.Fatalf("transformed frame sent as message type %d", mt)
+	}
+}
@@ -0,0 +1,250 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package packet
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// 量化浮点数的范围或精度无效
+var ErrInvalidFloatRange = errors.New("packet: invalid float range")
+
+// BitWriter 按位写入Packet, 高位在前, 用于布尔值及小整数等紧凑编码.
+// 位数据直接追加到Packet, 中间写入字节数据后的位数据从新的字节开始,
+// 同一消息可以交替写入字节段和位段
+type BitWriter struct {
+	p    *Packet
+	end  int  // 上次写入位数据后的数据长度
+	free uint // 最后一个字节未使用的位数
+}
+
+// BitReader 按位读取Packet, 与BitWriter对应.
+// 中间读取字节数据后的位数据从新的字节开始, 读取失败时位置不变, 错误记录在Packet中
+type BitReader struct {
+	p    *Packet
+	next uint // 上次读取位数据后的位置
+	left uint // 当前字节未读取的位数
+	cur  byte
+}
+
+// FloatRange 量化浮点数的范围及精度, 编码为(v-Min)/Precision四舍五入后的无符号整数
+type FloatRange struct {
+	Min       float64
+	Max       float64
+	Precision float64
+}
+
+func (p *Packet) BitWriter() *BitWriter {
+	return &BitWriter{p: p}
+}
+
+func (p *Packet) BitReader() *BitReader {
+	return &BitReader{p: p}
+}
+
+// BitsFor 表示0到max需要的位数
+func BitsFor(max uint64) int {
+	return bits.Len64(max)
+}
+
+// Bits 编码后的位数, 范围或精度无效时返回ErrInvalidFloatRange
+func (r FloatRange) Bits() (int, error) {
+	steps, err := r.steps()
+	if err != nil {
+		return 0, err
+	}
+	return BitsFor(steps), nil
+}
+
+func (r FloatRange) steps() (uint64, error) {
+	steps := math.Ceil((r.Max - r.Min) / r.Precision)
+	if !(r.Max > r.Min) || !(r.Precision > 0) || math.IsInf(r.Max-r.Min, 0) || !(steps < 1<<64) {
+		return 0, ErrInvalidFloatRange
+	}
+	return uint64(steps), nil
+}
+
+func checkBitWidth(n int) error {
+	if n < 1 || n > 64 {
+		return fmt.Errorf("packet: invalid bit width %d", n)
+	}
+	return nil
+}
+
+//=============================================== BitWriter
+
+func (w *BitWriter) WriteBool(v bool) {
+	if v {
+		w.writeBits(1, 1)
+	} else {
+		w.writeBits(0, 1)
+	}
+}
+
+// WriteUint 写入n位无符号整数, 超出范围时返回ErrValueOverflow
+func (w *BitWriter) WriteUint(v uint64, n int) error {
+	if err := checkBitWidth(n); err != nil {
+		return err
+	}
+	if err := CheckUint(v, n); err != nil {
+		return err
+	}
+	w.writeBits(v, uint(n))
+	return nil
+}
+
+// WriteInt 写入n位补码表示的有符号整数, 超出范围时返回ErrValueOverflow
+func (w *BitWriter) WriteInt(v int64, n int) error {
+	if err := checkBitWidth(n); err != nil {
+		return err
+	}
+	if err := CheckInt(v, n); err != nil {
+		return err
+	}
+	w.writeBits(uint64(v)&(math.MaxUint64>>uint(64-n)), uint(n))
+	return nil
+}
+
+// WriteFloat 按r量化写入v, v超出范围时返回ErrValueOverflow
+func (w *BitWriter) WriteFloat(v float64, r FloatRange) error {
+	steps, err := r.steps()
+	if err != nil {
+		return err
+	}
+	if !(v >= r.Min && v <= r.Max) {
+		return ErrValueOverflow
+	}
+	q := uint64(math.Round((v - r.Min) / r.Precision))
+	if q > steps {
+		q = steps
+	}
+	w.writeBits(q, uint(BitsFor(steps)))
+	return nil
+}
+
+// Align 之后的位数据从新的字节开始, 当前字节剩余的位为0
+func (w *BitWriter) Align() {
+	w.free = 0
+}
+
+func (w *BitWriter) writeBits(v uint64, n uint) {
+	if w.end != len(w.p.data) {
+		w.free = 0
+	}
+	for n > 0 {
+		if w.free == 0 {
+			w.p.data = append(w.p.data, 0)
+			w.free = 8
+		}
+		k := n
+		if k > w.free {
+			k = w.free
+		}
+		b := byte(v>>(n-k)) & (1<<k - 1)
+		w.p.data[len(w.p.data)-1] |= b << (w.free - k)
+		w.free -= k
+		n -= k
+	}
+	w.end = len(w.p.data)
+}
+
+//=============================================== BitReader
+
+func (r *BitReader) ReadBool() (bool, error) {
+	v, err := r.readBits(1)
+	return v == 1, err
+}
+
+// ReadUint 读取n位无符号整数
+func (r *BitReader) ReadUint(n int) (uint64, error) {
+	if err := checkBitWidth(n); err != nil {
+		return 0, r.p.fail(err)
+	}
+	return r.readBits(uint(n))
+}
+
+// ReadInt 读取n位补码表示的有符号整数
+func (r *BitReader) ReadInt(n int) (int64, error) {
+	if err := checkBitWidth(n); err != nil {
+		return 0, r.p.fail(err)
+	}
+	v, err := r.readBits(uint(n))
+	if err != nil {
+		return 0, err
+	}
+	shift := uint(64 - n)
+	return int64(v<<shift) >> shift, nil
+}
+
+// ReadFloat 读取按rng量化的浮点数, 结果限制在rng范围内
+func (r *BitReader) ReadFloat(rng FloatRange) (float64, error) {
+	steps, err := rng.steps()
+	if err != nil {
+		return 0, r.p.fail(err)
+	}
+	q, err := r.readBits(uint(BitsFor(steps)))
+	if err != nil {
+		return 0, err
+	}
+	return math.Min(rng.Min+float64(q)*rng.Precision, rng.Max), nil
+}
+
+// Align 丢弃当前字节未读取的位
+func (r *BitReader) Align() {
+	r.left = 0
+}
+
+func (r *BitReader) readBits(n uint) (ret uint64, err error) {
+	p := r.p
+	if err = p.failed(); err != nil {
+		return
+	}
+	if r.next != p.pos {
+		r.left = 0
+	}
+	if uint64(n) > uint64(r.left)+8*uint64(p.Remaining()) {
+		err = p.fail(errors.New("read bits failed"))
+		return
+	}
+	for n > 0 {
+		if r.left == 0 {
+			r.cur = p.data[p.pos]
+			p.pos++
+			r.left = 8
+		}
+		k := n
+		if k > r.left {
+			k = r.left
+		}
+		ret = ret<<k | uint64(r.cur>>(r.left-k))&(1<<k-1)
+		r.left -= k
+		n -= k
+	}
+	r.next = p.pos
+	return
+}
@@ -0,0 +1,172 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package packet
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"testing/quick"
+)
+
+func TestBitsMixedSections(t *testing.T) {
+	writer := Writer()
+	writer.WriteUint16(0x0102)
+	bw := writer.BitWriter()
+	bw.WriteBool(true)
+	if err := bw.WriteUint(5, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := bw.WriteInt(-3, 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := bw.WriteUint(0x1ff, 9); err != nil {
+		t.Fatal(err)
+	}
+	// 字节数据之后的位数据从新的字节开始
+	writer.WriteByte(0xaa)
+	bw.WriteBool(true)
+	bw.Align()
+	bw.WriteBool(true)
+	want := []byte{1, 2, 0xdd, 0xff, 0x80, 0xaa, 0x80, 0x80}
+	if !bytes.Equal(writer.Data(), want) {
+		t.Fatalf("encoded %x, want %x", writer.Data(), want)
+	}
+
+	reader := StickyReader(writer.Data())
+	br := reader.BitReader()
+	head, _ := reader.ReadUint16()
+	flag, _ := br.ReadBool()
+	small, _ := br.ReadUint(3)
+	signed, _ := br.ReadInt(4)
+	wide, _ := br.ReadUint(9)
+	b, _ := reader.ReadByte()
+	flag2, _ := br.ReadBool()
+	br.Align()
+	flag3, _ := br.ReadBool()
+	if err := reader.Err(); err != nil || reader.Remaining() != 0 {
+		t.Fatalf("read bits: %v remaining %d", err, reader.Remaining())
+	}
+	if head != 0x0102 || !flag || small != 5 || signed != -3 || wide != 0x1ff || b != 0xaa || !flag2 || !flag3 {
+		t.Fatalf("unexpected values %x %v %d %d %x %x %v %v", head, flag, small, signed, wide, b, flag2, flag3)
+	}
+}
+
+func TestBitsErrors(t *testing.T) {
+	bw := Writer().BitWriter()
+	if err := bw.WriteUint(8, 3); err != ErrValueOverflow {
+		t.Fatalf("WriteUint overflow: %v", err)
+	}
+	if err := bw.WriteInt(-5, 3); err != ErrValueOverflow {
+		t.Fatalf("WriteInt overflow: %v", err)
+	}
+	if err := bw.WriteUint(0, 65); err == nil {
+		t.Fatal("invalid width accepted")
+	}
+	rng := FloatRange{Min: -1, Max: 1, Precision: 0.01}
+	if err := bw.WriteFloat(1.5, rng); err != ErrValueOverflow {
+		t.Fatalf("WriteFloat out of range: %v", err)
+	}
+	if err := bw.WriteFloat(math.NaN(), rng); err != ErrValueOverflow {
+		t.Fatalf("WriteFloat NaN: %v", err)
+	}
+	if _, err := (FloatRange{Min: 1, Max: 1, Precision: 1}).Bits(); err != ErrInvalidFloatRange {
+		t.Fatalf("empty range: %v", err)
+	}
+
+	// 数据不足时位置不变, 粘性模式下之后的读取都失败
+	reader := StickyReader([]byte{0xf0, 0x0f})
+	br := reader.BitReader()
+	if v, err := br.ReadUint(4); err != nil || v != 0xf {
+		t.Fatalf("ReadUint: %d %v", v, err)
+	}
+	if _, err := br.ReadUint(13); err == nil || reader.Pos() != 1 {
+		t.Fatalf("read past end: %v pos %d", err, reader.Pos())
+	}
+	if _, err := br.ReadBool(); err == nil {
+		t.Fatal("read after error succeeded")
+	}
+}
+
+func TestBitsFloat(t *testing.T) {
+	rng := FloatRange{Min: -500, Max: 500, Precision: 0.01}
+	if n, err := rng.Bits(); err != nil || n != 17 {
+		t.Fatalf("Bits: %d %v", n, err)
+	}
+	prop := func(x int32) bool {
+		v := float64(x%50000) / 100.1
+		writer := Writer()
+		if err := writer.BitWriter().WriteFloat(v, rng); err != nil {
+			return false
+		}
+		got, err := Reader(writer.Data()).BitReader().ReadFloat(rng)
+		return err == nil && math.Abs(got-v) <= rng.Precision/2+1e-9 && got >= rng.Min && got <= rng.Max
+	}
+	if err := quick.Check(prop, nil); err != nil {
+		t.Error(err)
+	}
+	// 最大的量化值按Max截断
+	rng = FloatRange{Min: 0, Max: 1, Precision: 0.4}
+	writer := Writer()
+	_ = writer.BitWriter().WriteFloat(1, rng)
+	if v, err := Reader(writer.Data()).BitReader().ReadFloat(rng); err != nil || v != 1 {
+		t.Fatalf("ReadFloat max: %v %v", v, err)
+	}
+}
+
+func TestBitsRoundTripProperty(t *testing.T) {
+	prop := func(values []int64, widths []uint8) bool {
+		if len(widths) == 0 {
+			return true
+		}
+		writer := Writer()
+		bw := writer.BitWriter()
+		for i, v := range values {
+			n := int(widths[i%len(widths)]%64) + 1
+			if err := bw.WriteInt(v>>uint(64-n), n); err != nil {
+				return false
+			}
+			if err := bw.WriteUint(uint64(v)>>uint(64-n), n); err != nil {
+				return false
+			}
+		}
+		br := Reader(writer.Data()).BitReader()
+		for i, v := range values {
+			n := int(widths[i%len(widths)]%64) + 1
+			s, err := br.ReadInt(n)
+			if err != nil || s != v>>uint(64-n) {
+				return false
+			}
+			u, err := br.ReadUint(n)
+			if err != nil || u != uint64(v)>>uint(64-n) {
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(prop, nil); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,111 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package packet
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// 池中buffer按2的幂分级, 64B ~ 64KB, 超出范围的不进入池
+const (
+	minBufferShift = 6
+	maxBufferShift = 16
+)
+
+var bufferPools [maxBufferShift - minBufferShift + 1]sync.Pool
+
+var writerPool = sync.Pool{
+	New: func() interface{} {
+		return &Packet{}
+	},
+}
+
+// GetBuffer 从池中取长度为size的buffer, 内容未清零
+func GetBuffer(size int) []byte {
+	class := ceilClass(size)
+	if class < 0 {
+		return make([]byte, size)
+	}
+	if buf, ok := bufferPools[class].Get().(*[]byte); ok {
+		return (*buf)[:size]
+	}
+	return make([]byte, size, 1<<uint(class+minBufferShift))
+}
+
+// PutBuffer 归还buffer, 调用后不能再使用buf及其切片. 容量不足最小分级的直接丢弃
+func PutBuffer(buf []byte) {
+	class := floorClass(cap(buf))
+	if class < 0 {
+		return
+	}
+	buf = buf[:0]
+	bufferPools[class].Put(&buf)
+}
+
+// 容量不小于size的最小分级
+func ceilClass(size int) int {
+	if size > 1<<maxBufferShift {
+		return -1
+	}
+	if size <= 1<<minBufferShift {
+		return 0
+	}
+	return bits.Len(uint(size-1)) - minBufferShift
+}
+
+// 容量不超过size的最大分级
+func floorClass(size int) int {
+	if size < 1<<minBufferShift {
+		return -1
+	}
+	shift := bits.Len(uint(size)) - 1
+	if shift > maxBufferShift {
+		shift = maxBufferShift
+	}
+	return shift - minBufferShift
+}
+
+// AcquireWriter 使用池中buffer的Writer, Data()不再使用后调用Release归还
+func AcquireWriter() *Packet {
+	pkt := writerPool.Get().(*Packet)
+	if pkt.data == nil {
+		pkt.data = GetBuffer(128)[:0]
+	}
+	return pkt
+}
+
+// Release 归还Writer, 之后不能再使用该Packet及Data()返回的数据.
+// 扩容后的buffer随Writer保留, 超过最大分级的直接丢弃
+func (p *Packet) Release() {
+	if cap(p.data) > 1<<maxBufferShift {
+		p.data = nil
+	} else {
+		p.data = p.data[:0]
+	}
+	p.pos = 0
+	p.err = nil
+	p.sticky = false
+	writerPool.Put(p)
+}
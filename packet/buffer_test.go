@@ -0,0 +1,93 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package packet
+
+import "testing"
+
+func TestBufferClasses(t *testing.T) {
+	cases := []struct {
+		size, cap int
+	}{
+		{0, 64},
+		{1, 64},
+		{64, 64},
+		{65, 128},
+		{1000, 1024},
+		{1 << 16, 1 << 16},
+		{1<<16 + 1, 1<<16 + 1},
+	}
+	for _, c := range cases {
+		buf := GetBuffer(c.size)
+		if len(buf) != c.size || cap(buf) != c.cap {
+			t.Errorf("GetBuffer(%d): len %d cap %d, want cap %d", c.size, len(buf), cap(buf), c.cap)
+		}
+		PutBuffer(buf)
+	}
+	// 非分级容量的buffer按向下取整的分级归还, 取出时容量仍满足要求
+	PutBuffer(make([]byte, 0, 200))
+	if buf := GetBuffer(128); cap(buf) < 128 {
+		t.Fatalf("cap %d less than 128", cap(buf))
+	}
+}
+
+func TestAcquireWriter(t *testing.T) {
+	writer := AcquireWriter()
+	writer.WriteString("hello")
+	writer.WriteUint32(7)
+	reader := Reader(writer.Data())
+	if s, _ := reader.ReadString(); s != "hello" {
+		t.Fatalf("got %q", s)
+	}
+	if v, _ := reader.ReadUint32(); v != 7 {
+		t.Fatalf("got %d", v)
+	}
+	writer.Release()
+	if writer = AcquireWriter(); writer.Length() != 0 {
+		t.Fatalf("reused writer not reset, length %d", writer.Length())
+	}
+	writer.Release()
+}
+
+func encodeMessage(writer *Packet) {
+	writer.WriteUint16(1001)
+	writer.WriteString("player_name")
+	writer.WriteInt64(1 << 40)
+	writer.WriteRawBytes(make([]byte, 200))
+}
+
+func BenchmarkWriter(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		encodeMessage(Writer())
+	}
+}
+
+func BenchmarkAcquireWriter(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		writer := AcquireWriter()
+		encodeMessage(writer)
+		writer.Release()
+	}
+}
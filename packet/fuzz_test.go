@@ -0,0 +1,200 @@
+//go:build go1.18
+// +build go1.18
+
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package packet
+
+import (
+	"bytes"
+	"testing"
+)
+
+// 每个读取方法一项, arg由fuzz输入提供, 作为需要数量或宽度参数的方法的参数
+var fuzzReaders = []struct {
+	name string
+	read func(p *Packet, arg byte) ([]byte, error)
+}{
+	{"ReadBool", func(p *Packet, _ byte) ([]byte, error) { _, err := p.ReadBool(); return nil, err }},
+	{"ReadByte", func(p *Packet, _ byte) ([]byte, error) { _, err := p.ReadByte(); return nil, err }},
+	{"ReadBytes", func(p *Packet, _ byte) ([]byte, error) { return p.ReadBytes() }},
+	{"ReadString", func(p *Packet, _ byte) ([]byte, error) { v, err := p.ReadString(); return []byte(v), err }},
+	{"ReadBytes32", func(p *Packet, _ byte) ([]byte, error) { return p.ReadBytes32() }},
+	{"ReadString32", func(p *Packet, _ byte) ([]byte, error) { v, err := p.ReadString32(); return []byte(v), err }},
+	{"ReadUint16", func(p *Packet, _ byte) ([]byte, error) { _, err := p.ReadUint16(); return nil, err }},
+	{"ReadInt16", func(p *Packet, _ byte) ([]byte, error) { _, err := p.ReadInt16(); return nil, err }},
+	{"ReadUint24", func(p *Packet, _ byte) ([]byte, error) { _, err := p.ReadUint24(); return nil, err }},
+	{"ReadInt24", func(p *Packet, _ byte) ([]byte, error) { _, err := p.ReadInt24(); return nil, err }},
+	{"ReadUint32", func(p *Packet, _ byte) ([]byte, error) { _, err := p.ReadUint32(); return nil, err }},
+	{"ReadInt32", func(p *Packet, _ byte) ([]byte, error) { _, err := p.ReadInt32(); return nil, err }},
+	{"ReadUint64", func(p *Packet, _ byte) ([]byte, error) { _, err := p.ReadUint64(); return nil, err }},
+	{"ReadInt64", func(p *Packet, _ byte) ([]byte, error) { _, err := p.ReadInt64(); return nil, err }},
+	{"ReadFloat32", func(p *Packet, _ byte) ([]byte, error) { _, err := p.ReadFloat32(); return nil, err }},
+	{"ReadFloat64", func(p *Packet, _ byte) ([]byte, error) { _, err := p.ReadFloat64(); return nil, err }},
+	{"ReadUint16LE", func(p *Packet, _ byte) ([]byte, error) { _, err := p.ReadUint16LE(); return nil, err }},
+	{"ReadInt16LE", func(p *Packet, _ byte) ([]byte, error) { _, err := p.ReadInt16LE(); return nil, err }},
+	{"ReadUint32LE", func(p *Packet, _ byte) ([]byte, error) { _, err := p.ReadUint32LE(); return nil, err }},
+	{"ReadInt32LE", func(p *Packet, _ byte) ([]byte, error) { _, err := p.ReadInt32LE(); return nil, err }},
+	{"ReadUint64LE", func(p *Packet, _ byte) ([]byte, error) { _, err := p.ReadUint64LE(); return nil, err }},
+	{"ReadInt64LE", func(p *Packet, _ byte) ([]byte, error) { _, err := p.ReadInt64LE(); return nil, err }},
+	{"ReadFloat32LE", func(p *Packet, _ byte) ([]byte, error) { _, err := p.ReadFloat32LE(); return nil, err }},
+	{"ReadFloat64LE", func(p *Packet, _ byte) ([]byte, error) { _, err := p.ReadFloat64LE(); return nil, err }},
+	{"ReadUvarint", func(p *Packet, _ byte) ([]byte, error) { _, err := p.ReadUvarint(); return nil, err }},
+	{"ReadVarint", func(p *Packet, _ byte) ([]byte, error) { _, err := p.ReadVarint(); return nil, err }},
+	{"ReadUvarint32", func(p *Packet, _ byte) ([]byte, error) { _, err := p.ReadUvarint32(); return nil, err }},
+	{"ReadVarint32", func(p *Packet, _ byte) ([]byte, error) { _, err := p.ReadVarint32(); return nil, err }},
+	{"ReadVarBytes", func(p *Packet, _ byte) ([]byte, error) { return p.ReadVarBytes() }},
+	{"ReadVarString", func(p *Packet, _ byte) ([]byte, error) { v, err := p.ReadVarString(); return []byte(v), err }},
+	{"ReadInt", func(p *Packet, arg byte) ([]byte, error) {
+		_, err := p.ReadInt(fuzzWidth(arg), arg&1 == 1)
+		return nil, err
+	}},
+	{"ReadUint", func(p *Packet, arg byte) ([]byte, error) {
+		_, err := p.ReadUint(fuzzWidth(arg), arg&1 == 1)
+		return nil, err
+	}},
+	{"ReadLength", func(p *Packet, arg byte) ([]byte, error) { _, err := p.ReadLength(fuzzWidth(arg)); return nil, err }},
+	{"ReadRawBytes", func(p *Packet, arg byte) ([]byte, error) { return p.ReadRawBytes(int(int8(arg))) }},
+	{"ReadUint16s", func(p *Packet, arg byte) ([]byte, error) { _, err := p.ReadUint16s(int(int8(arg))); return nil, err }},
+	{"ReadUint32s", func(p *Packet, arg byte) ([]byte, error) { _, err := p.ReadUint32s(int(int8(arg))); return nil, err }},
+	{"ReadInt32s", func(p *Packet, arg byte) ([]byte, error) { _, err := p.ReadInt32s(int(int8(arg))); return nil, err }},
+	{"ReadUint64s", func(p *Packet, arg byte) ([]byte, error) { _, err := p.ReadUint64s(int(int8(arg))); return nil, err }},
+	{"ReadInt64s", func(p *Packet, arg byte) ([]byte, error) { _, err := p.ReadInt64s(int(int8(arg))); return nil, err }},
+	{"PeekByte", func(p *Packet, _ byte) ([]byte, error) { _, err := p.PeekByte(); return nil, err }},
+	{"PeekUint16", func(p *Packet, _ byte) ([]byte, error) { _, err := p.PeekUint16(); return nil, err }},
+	{"PeekUint32", func(p *Packet, _ byte) ([]byte, error) { _, err := p.PeekUint32(); return nil, err }},
+	{"PeekUvarint", func(p *Packet, _ byte) ([]byte, error) { _, err := p.PeekUvarint(); return nil, err }},
+	{"Peek", func(p *Packet, arg byte) ([]byte, error) { return p.Peek(int(int8(arg))) }},
+	{"Require", func(p *Packet, arg byte) ([]byte, error) { return nil, p.Require(int(int8(arg))) }},
+	{"Seek", func(p *Packet, arg byte) ([]byte, error) { return nil, p.Seek(uint(arg)) }},
+	{"RemainData", func(p *Packet, _ byte) ([]byte, error) { return p.RemainData(), p.failed() }},
+	{"BitReader.ReadBool", func(p *Packet, _ byte) ([]byte, error) { _, err := p.BitReader().ReadBool(); return nil, err }},
+	{"BitReader.ReadUint", func(p *Packet, arg byte) ([]byte, error) {
+		_, err := p.BitReader().ReadUint(int(arg % 66))
+		return nil, err
+	}},
+	{"BitReader.ReadInt", func(p *Packet, arg byte) ([]byte, error) {
+		_, err := p.BitReader().ReadInt(int(arg % 66))
+		return nil, err
+	}},
+	{"BitReader.ReadFloat", func(p *Packet, arg byte) ([]byte, error) {
+		_, err := p.BitReader().ReadFloat(FloatRange{Min: -1, Max: float64(arg), Precision: 0.001})
+		return nil, err
+	}},
+}
+
+// 包含WidthVarint及非法宽度
+func fuzzWidth(arg byte) int {
+	widths := []int{8, 16, 32, 64, WidthVarint, 0, 7}
+	return widths[int(arg>>1)%len(widths)]
+}
+
+// FuzzRead ops每两字节选择一个读取方法及其参数, 依次读取data.
+// 任何输入都不能panic, 读取失败不移动位置, 返回的数据必须位于data内
+func FuzzRead(f *testing.F) {
+	writer := Writer()
+	writer.WriteString("name")
+	writer.WriteUint32(7)
+	writer.WriteVarBytes([]byte("bytes"))
+	writer.WriteFloat64(1.5)
+	f.Add(writer.Data(), []byte{3, 0, 10, 0, 28, 0, 15, 0}, false)
+	f.Add(writer.Data(), []byte{44, 2, 33, 0x80, 2, 0, 45, 0}, true)
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0xff}, []byte{4, 0, 24, 0, 32, 9}, true)
+	f.Fuzz(func(t *testing.T, data, ops []byte, sticky bool) {
+		p := Reader(data)
+		if sticky {
+			p = StickyReader(data)
+		}
+		for i := 0; i+1 < len(ops); i += 2 {
+			reader := fuzzReaders[int(ops[i])%len(fuzzReaders)]
+			pos, first := p.Pos(), p.Err()
+			ret, err := reader.read(p, ops[i+1])
+			if p.Pos() > uint(len(data)) || p.Remaining() != len(data)-int(p.Pos()) {
+				t.Fatalf("%s: pos %d out of data %d", reader.name, p.Pos(), len(data))
+			}
+			if err != nil && p.Pos() != pos {
+				t.Fatalf("%s: failed read moved pos %d -> %d", reader.name, pos, p.Pos())
+			}
+			if first != nil && p.Err() != first {
+				t.Fatalf("%s: first error overwritten", reader.name)
+			}
+			if sticky && first != nil && err == nil {
+				t.Fatalf("%s: read succeeded after error", reader.name)
+			}
+			if len(ret) > len(data) || (len(ret) > 0 && !bytes.Contains(data, ret)) {
+				t.Fatalf("%s: returned data outside input", reader.name)
+			}
+		}
+	})
+}
+
+type fuzzStruct struct {
+	Flag   bool
+	Small  int8
+	Wide   int64  `packet:"le"`
+	Count  uint32 `packet:"varint"`
+	Delta  int32  `packet:"varint"`
+	Ratio  float32
+	Scale  float64 `packet:"le"`
+	Name   string  `packet:"len=varint"`
+	Blob   []byte  `packet:"len=32"`
+	Ids    []uint16
+	Fixed  [2]uint8
+	Tree   *treeNode  `packet:"optional"`
+	Nested []treeNode `packet:"len=varint"`
+}
+
+// FuzzUnmarshal 任意数据解码结构体不能panic, 解码成功的结果重新编码后必须能得到相同的值
+func FuzzUnmarshal(f *testing.F) {
+	data, err := Marshal(&fuzzStruct{
+		Name:   "name",
+		Blob:   []byte{1, 2, 3},
+		Ids:    []uint16{1, 2},
+		Tree:   &treeNode{Value: 1, Children: []treeNode{}},
+		Nested: []treeNode{{Value: 2, Children: []treeNode{}}},
+	})
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(data)
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		v := fuzzStruct{}
+		if err := Unmarshal(data, &v); err != nil {
+			return
+		}
+		encoded, err := Marshal(&v)
+		if err != nil {
+			t.Fatalf("re-marshal %+v: %v", v, err)
+		}
+		again := fuzzStruct{}
+		if err = Unmarshal(encoded, &again); err != nil {
+			t.Fatalf("unmarshal re-marshaled data: %v", err)
+		}
+		if encoded2, _ := Marshal(&again); !bytes.Equal(encoded, encoded2) {
+			t.Fatalf("unstable encoding %v != %v", encoded, encoded2)
+		}
+	})
+}
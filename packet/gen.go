@@ -0,0 +1,460 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package packet
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 结构体注释中带有该标记时由Generate生成编解码方法
+const GenerateMark = "packet:generate"
+
+// Generate 为dir目录下注释带有//packet:generate的结构体生成MarshalPacket/UnmarshalPacket方法,
+// 写入outfile. 编码与WriteStruct相同, 嵌套的结构体须同样生成或实现Marshaler/Unmarshaler
+func Generate(dir, outfile string) error {
+	// 按构建约束选出包中的文件, 排除go:generate使用的main文件
+	buildPkg, err := build.ImportDir(dir, 0)
+	if err != nil {
+		return err
+	}
+	fset := token.NewFileSet()
+	pkg := &ast.Package{Name: buildPkg.Name, Files: map[string]*ast.File{}}
+	for _, name := range buildPkg.GoFiles {
+		if name == filepath.Base(outfile) {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		file, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
+		if err != nil {
+			return err
+		}
+		pkg.Files[path] = file
+	}
+	code, err := generate(pkg)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(outfile, code, 0644)
+}
+
+func generate(pkg *ast.Package) ([]byte, error) {
+	g := &generator{types: map[string]ast.Expr{}}
+	var targets []*ast.TypeSpec
+	names := make([]string, 0, len(pkg.Files))
+	for name := range pkg.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, decl := range pkg.Files[name].Decls {
+			genDecl, ok := decl.(*ast.GenDecl)
+			if !ok || genDecl.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range genDecl.Specs {
+				typeSpec := spec.(*ast.TypeSpec)
+				g.types[typeSpec.Name.Name] = typeSpec.Type
+				doc := typeSpec.Doc
+				if doc == nil && len(genDecl.Specs) == 1 {
+					doc = genDecl.Doc
+				}
+				if _, ok := typeSpec.Type.(*ast.StructType); ok && hasGenerateMark(doc) {
+					targets = append(targets, typeSpec)
+				}
+			}
+		}
+	}
+
+	g.printf("// Code generated by packet.Generate. DO NOT EDIT.\n\n")
+	g.printf("package %s\n\n", pkg.Name)
+	g.printf("import \"github.com/mafei198/glib/packet\"\n")
+	for _, spec := range targets {
+		if err := g.genStruct(spec.Name.Name, spec.Type.(*ast.StructType)); err != nil {
+			return nil, err
+		}
+	}
+	return format.Source(g.buf.Bytes())
+}
+
+func hasGenerateMark(doc *ast.CommentGroup) bool {
+	if doc == nil {
+		return false
+	}
+	for _, comment := range doc.List {
+		if strings.TrimSpace(strings.TrimPrefix(comment.Text, "//")) == GenerateMark {
+			return true
+		}
+	}
+	return false
+}
+
+type generator struct {
+	buf   bytes.Buffer
+	types map[string]ast.Expr
+	seq   int
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// 生成代码中的临时变量名
+func (g *generator) tmp(prefix string) string {
+	g.seq++
+	return prefix + strconv.Itoa(g.seq)
+}
+
+// 字段的类型信息
+type genType struct {
+	kind  reflect.Kind
+	bits  int
+	expr  string // 类型表达式, 用于类型转换及make/new
+	elem  *genType
+	bytes bool
+}
+
+var basicKinds = map[string]*genType{
+	"bool":    {kind: reflect.Bool},
+	"int":     {kind: reflect.Int, bits: 64},
+	"int8":    {kind: reflect.Int8, bits: 8},
+	"int16":   {kind: reflect.Int16, bits: 16},
+	"int32":   {kind: reflect.Int32, bits: 32},
+	"rune":    {kind: reflect.Int32, bits: 32},
+	"int64":   {kind: reflect.Int64, bits: 64},
+	"uint":    {kind: reflect.Uint, bits: 64},
+	"uint8":   {kind: reflect.Uint8, bits: 8},
+	"byte":    {kind: reflect.Uint8, bits: 8},
+	"uint16":  {kind: reflect.Uint16, bits: 16},
+	"uint32":  {kind: reflect.Uint32, bits: 32},
+	"uint64":  {kind: reflect.Uint64, bits: 64},
+	"float32": {kind: reflect.Float32, bits: 32},
+	"float64": {kind: reflect.Float64, bits: 64},
+	"string":  {kind: reflect.String},
+}
+
+func (g *generator) resolve(expr ast.Expr) (*genType, error) {
+	typeExpr := types.ExprString(expr)
+	switch e := expr.(type) {
+	case *ast.Ident:
+		if basic, ok := basicKinds[e.Name]; ok {
+			t := *basic
+			t.expr = typeExpr
+			return &t, nil
+		}
+		underlying, ok := g.types[e.Name]
+		if !ok {
+			return nil, fmt.Errorf("packet: unknown type %s", e.Name)
+		}
+		if _, ok := underlying.(*ast.StructType); ok {
+			return &genType{kind: reflect.Struct, expr: typeExpr}, nil
+		}
+		t, err := g.resolve(underlying)
+		if err != nil {
+			return nil, err
+		}
+		t.expr = typeExpr
+		return t, nil
+	case *ast.SelectorExpr:
+		// 其他包的类型须实现Marshaler/Unmarshaler
+		return &genType{kind: reflect.Struct, expr: typeExpr}, nil
+	case *ast.StarExpr:
+		elem, err := g.resolve(e.X)
+		if err != nil {
+			return nil, err
+		}
+		return &genType{kind: reflect.Ptr, expr: typeExpr, elem: elem}, nil
+	case *ast.ArrayType:
+		elem, err := g.resolve(e.Elt)
+		if err != nil {
+			return nil, err
+		}
+		if e.Len != nil {
+			return &genType{kind: reflect.Array, expr: typeExpr, elem: elem}, nil
+		}
+		return &genType{kind: reflect.Slice, expr: typeExpr, elem: elem, bytes: elem.kind == reflect.Uint8}, nil
+	}
+	return nil, fmt.Errorf("packet: unsupported type %s", typeExpr)
+}
+
+type genField struct {
+	name string
+	typ  *genType
+	opts *fieldOptions
+}
+
+func (g *generator) genStruct(name string, st *ast.StructType) error {
+	var fields []*genField
+	var opts []*fieldOptions
+	for _, field := range st.Fields.List {
+		names := make([]string, 0, len(field.Names))
+		for _, ident := range field.Names {
+			names = append(names, ident.Name)
+		}
+		// 嵌入字段以类型名作为字段名
+		if len(names) == 0 {
+			typeName := types.ExprString(field.Type)
+			typeName = typeName[strings.LastIndex(typeName, ".")+1:]
+			names = append(names, strings.TrimPrefix(typeName, "*"))
+		}
+		tag := ""
+		if field.Tag != nil {
+			raw, err := strconv.Unquote(field.Tag.Value)
+			if err != nil {
+				return err
+			}
+			tag = reflect.StructTag(raw).Get("packet")
+		}
+		for _, fieldName := range names {
+			if !ast.IsExported(fieldName) {
+				continue
+			}
+			opt, err := parseTag(tag)
+			if err != nil {
+				return fmt.Errorf("%v: %s.%s", err, name, fieldName)
+			}
+			var typ *genType
+			if !opt.skip {
+				if typ, err = g.resolve(field.Type); err != nil {
+					return fmt.Errorf("%v: %s.%s", err, name, fieldName)
+				}
+			}
+			fields = append(fields, &genField{name: fieldName, typ: typ, opts: opt})
+			opts = append(opts, opt)
+		}
+	}
+	indexes, err := orderFields(opts)
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, name)
+	}
+
+	g.printf("\nfunc (v *%s) MarshalPacket(w *packet.Packet) error {\n", name)
+	for _, i := range indexes {
+		field := fields[i]
+		if err := g.encode("v."+field.name, field.typ, field.opts); err != nil {
+			return fmt.Errorf("%v: %s.%s", err, name, field.name)
+		}
+	}
+	g.printf("return nil\n}\n")
+
+	g.printf("\nfunc (v *%s) UnmarshalPacket(r *packet.Packet) error {\n", name)
+	for _, i := range indexes {
+		field := fields[i]
+		if err := g.decode("v."+field.name, field.typ, field.opts); err != nil {
+			return fmt.Errorf("%v: %s.%s", err, name, field.name)
+		}
+	}
+	g.printf("return nil\n}\n")
+	return nil
+}
+
+func leSuffix(le bool) string {
+	if le {
+		return "LE"
+	}
+	return ""
+}
+
+func widthExpr(width int) string {
+	if width == WidthVarint {
+		return "packet.WidthVarint"
+	}
+	return strconv.Itoa(width)
+}
+
+const returnErr = "if err != nil {\nreturn err\n}\n"
+
+func (g *generator) encode(expr string, t *genType, opts *fieldOptions) error {
+	switch t.kind {
+	case reflect.Bool:
+		g.printf("w.WriteBool(bool(%s))\n", expr)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		width, err := opts.intWidth(t.bits)
+		if err != nil {
+			return err
+		}
+		g.printf("if err := w.WriteInt(int64(%s), %s, %v); err != nil {\nreturn err\n}\n", expr, widthExpr(width), opts.le)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		width, err := opts.intWidth(t.bits)
+		if err != nil {
+			return err
+		}
+		g.printf("if err := w.WriteUint(uint64(%s), %s, %v); err != nil {\nreturn err\n}\n", expr, widthExpr(width), opts.le)
+	case reflect.Float32, reflect.Float64:
+		g.printf("w.WriteFloat%d%s(float%d(%s))\n", t.bits, leSuffix(opts.le), t.bits, expr)
+	case reflect.String:
+		g.writePrefixed("String", "string", expr, opts.lenWidth)
+	case reflect.Slice:
+		if t.bytes {
+			g.writePrefixed("Bytes", "[]byte", expr, opts.lenWidth)
+			return nil
+		}
+		g.printf("if err := w.WriteLength(len(%s), %s); err != nil {\nreturn err\n}\n", expr, widthExpr(opts.lenWidth))
+		return g.encodeElems(expr, t, opts)
+	case reflect.Array:
+		return g.encodeElems(expr, t, opts)
+	case reflect.Ptr:
+		if opts.optional {
+			g.printf("w.WriteBool(%s != nil)\nif %s != nil {\n", expr, expr)
+			if err := g.encode("(*"+expr+")", t.elem, opts); err != nil {
+				return err
+			}
+			g.printf("}\n")
+			return nil
+		}
+		g.printf("if %s == nil {\nreturn packet.ErrNilPointer\n}\n", expr)
+		return g.encode("(*"+expr+")", t.elem, opts)
+	case reflect.Struct:
+		g.printf("if err := %s.MarshalPacket(w); err != nil {\nreturn err\n}\n", expr)
+	default:
+		return fmt.Errorf("packet: unsupported type %s", t.expr)
+	}
+	return nil
+}
+
+func (g *generator) writePrefixed(method, cast, expr string, lenWidth int) {
+	if lenWidth == WidthVarint {
+		g.printf("w.WriteVar%s(%s(%s))\n", method, cast, expr)
+		return
+	}
+	g.printf("if err := w.Write%s%s(%s(%s)); err != nil {\nreturn err\n}\n", method, lenSuffix32(lenWidth), cast, expr)
+}
+
+func (g *generator) encodeElems(expr string, t *genType, opts *fieldOptions) error {
+	i := g.tmp("i")
+	g.printf("for %s := range %s {\n", i, expr)
+	if err := g.encode(expr+"["+i+"]", t.elem, opts); err != nil {
+		return err
+	}
+	g.printf("}\n")
+	return nil
+}
+
+func (g *generator) decode(target string, t *genType, opts *fieldOptions) error {
+	switch t.kind {
+	case reflect.Bool:
+		x := g.tmp("x")
+		g.printf("%s, err := r.ReadBool()\n"+returnErr+"%s = %s(%s)\n", x, target, t.expr, x)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		width, err := opts.intWidth(t.bits)
+		if err != nil {
+			return err
+		}
+		x := g.tmp("x")
+		g.printf("%s, err := r.ReadInt(%s, %v)\n"+returnErr, x, widthExpr(width), opts.le)
+		if width == WidthVarint && t.bits < 64 {
+			g.printf("if err = packet.CheckInt(%s, %d); err != nil {\nreturn err\n}\n", x, t.bits)
+		}
+		g.printf("%s = %s(%s)\n", target, t.expr, x)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		width, err := opts.intWidth(t.bits)
+		if err != nil {
+			return err
+		}
+		x := g.tmp("x")
+		g.printf("%s, err := r.ReadUint(%s, %v)\n"+returnErr, x, widthExpr(width), opts.le)
+		if width == WidthVarint && t.bits < 64 {
+			g.printf("if err = packet.CheckUint(%s, %d); err != nil {\nreturn err\n}\n", x, t.bits)
+		}
+		g.printf("%s = %s(%s)\n", target, t.expr, x)
+	case reflect.Float32, reflect.Float64:
+		x := g.tmp("x")
+		g.printf("%s, err := r.ReadFloat%d%s()\n"+returnErr+"%s = %s(%s)\n", x, t.bits, leSuffix(opts.le), target, t.expr, x)
+	case reflect.String:
+		x := g.tmp("x")
+		g.printf("%s, err := r.Read%sString%s()\n"+returnErr+"%s = %s(%s)\n",
+			x, varPrefix(opts.lenWidth), lenSuffix32(opts.lenWidth), target, t.expr, x)
+	case reflect.Slice:
+		if t.bytes {
+			x := g.tmp("x")
+			g.printf("%s, err := r.Read%sBytes%s()\n"+returnErr+"%s = append(%s(nil), %s...)\n",
+				x, varPrefix(opts.lenWidth), lenSuffix32(opts.lenWidth), target, t.expr, x)
+			return nil
+		}
+		n, i, x := g.tmp("n"), g.tmp("i"), g.tmp("x")
+		g.printf("%s, err := r.ReadLength(%s)\n"+returnErr+"%s = make(%s, 0, packet.PreallocLen(%s))\n", n, widthExpr(opts.lenWidth), target, t.expr, n)
+		g.printf("for %s := 0; %s < %s; %s++ {\nvar %s %s\n", i, i, n, i, x, t.elem.expr)
+		if err := g.decode(x, t.elem, opts); err != nil {
+			return err
+		}
+		g.printf("%s = append(%s, %s)\n}\n", target, target, x)
+		return nil
+	case reflect.Array:
+		return g.decodeElems(target, t, opts)
+	case reflect.Ptr:
+		if opts.optional {
+			x := g.tmp("x")
+			g.printf("%s, err := r.ReadBool()\n"+returnErr+"%s = nil\nif %s {\n%s = new(%s)\n", x, target, x, target, t.elem.expr)
+			if err := g.decode("(*"+target+")", t.elem, opts); err != nil {
+				return err
+			}
+			g.printf("}\n")
+			return nil
+		}
+		g.printf("%s = new(%s)\n", target, t.elem.expr)
+		return g.decode("(*"+target+")", t.elem, opts)
+	case reflect.Struct:
+		g.printf("if err := %s.UnmarshalPacket(r); err != nil {\nreturn err\n}\n", target)
+	default:
+		return fmt.Errorf("packet: unsupported type %s", t.expr)
+	}
+	return nil
+}
+
+func varPrefix(lenWidth int) string {
+	if lenWidth == WidthVarint {
+		return "Var"
+	}
+	return ""
+}
+
+func lenSuffix32(lenWidth int) string {
+	if lenWidth == 32 {
+		return "32"
+	}
+	return ""
+}
+
+func (g *generator) decodeElems(target string, t *genType, opts *fieldOptions) error {
+	i := g.tmp("i")
+	g.printf("for %s := range %s {\n", i, target)
+	if err := g.decode(target+"["+i+"]", t.elem, opts); err != nil {
+		return err
+	}
+	g.printf("}\n")
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build ignore
+// +build ignore
+
+package main
+
+import "github.com/mafei198/glib/packet"
+
+func main() {
+	if err := packet.Generate(".", "types_packet.go"); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,157 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package gentest
+
+import (
+	"bytes"
+	"github.com/mafei198/glib/packet"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// 去掉生成的方法, 使用反射编码
+type rawPlayer Player
+type rawItem Item
+
+func newPlayer() *Player {
+	guild := "guild"
+	return &Player{
+		Id:      1 << 40,
+		Name:    "player",
+		Level:   -3,
+		Exp:     1 << 50,
+		Pos:     Vec3{1, 2, 3},
+		Items:   []*Item{{Id: -100, Count: 255, Bound: true}, {Id: 1 << 30, Count: 1}},
+		Tags:    Tags{"a", "bc"},
+		Avatar:  []byte{1, 2, 3},
+		Guild:   &guild,
+		Slots:   [3]int8{-1, 0, 1},
+		Friends: []int32{7, -7},
+		Online:  true,
+		Score:   99.5,
+	}
+}
+
+func TestGeneratedUpToDate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "packet_gen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	outfile := filepath.Join(dir, "types_packet.go")
+	if err = packet.Generate(".", outfile); err != nil {
+		t.Fatal(err)
+	}
+	generated, _ := ioutil.ReadFile(outfile)
+	committed, _ := ioutil.ReadFile("types_packet.go")
+	if !bytes.Equal(generated, committed) {
+		t.Fatal("types_packet.go is out of date, run go generate")
+	}
+}
+
+func TestGeneratedMatchesReflection(t *testing.T) {
+	player := newPlayer()
+	generated, err := packet.Marshal(player)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reflected, err := packet.Marshal((*rawPlayer)(player))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(generated, reflected) {
+		t.Fatalf("generated %v\nreflected %v", generated, reflected)
+	}
+	item := player.Items[0]
+	generated, _ = packet.Marshal(item)
+	reflected, _ = packet.Marshal((*rawItem)(item))
+	if !bytes.Equal(generated, reflected) {
+		t.Fatalf("generated %v\nreflected %v", generated, reflected)
+	}
+
+	decoded := &Player{}
+	if err = packet.Unmarshal(generated[:0], decoded); err == nil {
+		t.Fatal("decode empty data succeeded")
+	}
+	data, _ := packet.Marshal(player)
+	if err = packet.Unmarshal(data, decoded); err != nil {
+		t.Fatal(err)
+	}
+	raw := &rawPlayer{}
+	if err = packet.Unmarshal(data, raw); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, player) || !reflect.DeepEqual((*Player)(raw), player) {
+		t.Fatalf("decoded %+v\nraw %+v\nwant %+v", decoded, raw, player)
+	}
+}
+
+func TestGeneratedErrors(t *testing.T) {
+	player := newPlayer()
+	player.Friends = []int32{1 << 20}
+	if _, err := packet.Marshal(player); err != packet.ErrValueOverflow {
+		t.Fatalf("expect ErrValueOverflow, got %v", err)
+	}
+	if _, err := packet.Marshal((*rawPlayer)(player)); err != packet.ErrValueOverflow {
+		t.Fatalf("expect ErrValueOverflow, got %v", err)
+	}
+	player = newPlayer()
+	player.Items = append(player.Items, nil)
+	if _, err := packet.Marshal(player); err != packet.ErrNilPointer {
+		t.Fatalf("expect ErrNilPointer, got %v", err)
+	}
+	// varint解码超出int32范围
+	w := packet.Writer()
+	w.WriteVarint(1 << 40)
+	if err := packet.Unmarshal(w.Data(), &Item{}); err != packet.ErrValueOverflow {
+		t.Fatalf("expect ErrValueOverflow, got %v", err)
+	}
+	if err := packet.Unmarshal(w.Data(), &rawItem{}); err != packet.ErrValueOverflow {
+		t.Fatalf("expect ErrValueOverflow, got %v", err)
+	}
+}
+
+func BenchmarkMarshalGenerated(b *testing.B) {
+	player := newPlayer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		w := packet.AcquireWriter()
+		_ = w.WriteStruct(player)
+		w.Release()
+	}
+}
+
+func BenchmarkMarshalReflect(b *testing.B) {
+	player := (*rawPlayer)(newPlayer())
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		w := packet.AcquireWriter()
+		_ = w.WriteStruct(player)
+		w.Release()
+	}
+}
@@ -0,0 +1,66 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+// Package gentest 用于测试packet.Generate生成的代码与反射编码一致
+package gentest
+
+//go:generate go run gen.go
+
+type PlayerId int64
+
+type Tags []string
+
+//packet:generate
+type Vec3 struct {
+	X float32 `packet:"le"`
+	Y float32 `packet:"le"`
+	Z float32 `packet:"le"`
+}
+
+//packet:generate
+type Item struct {
+	Id    int32  `packet:"varint"`
+	Count uint16 `packet:"8"`
+	Bound bool
+}
+
+//packet:generate
+type Player struct {
+	Id       PlayerId `packet:"order=1"`
+	Name     string   `packet:"order=2"`
+	Level    int16    `packet:"order=3,le"`
+	Exp      uint64   `packet:"order=4,varint"`
+	Pos      Vec3     `packet:"order=5"`
+	Items    []*Item  `packet:"order=6,len=varint"`
+	Tags     Tags     `packet:"order=7,len=32"`
+	Avatar   []byte   `packet:"order=8,len=varint"`
+	Guild    *string  `packet:"order=9,optional"`
+	Last     *Vec3    `packet:"order=10,optional"`
+	Slots    [3]int8  `packet:"order=11"`
+	Friends  []int32  `packet:"order=12,16"`
+	Online   bool     `packet:"order=13"`
+	Score    float64  `packet:"order=14"`
+	Cache    string   `packet:"-"`
+	internal int
+}
@@ -0,0 +1,244 @@
+// Code generated by packet.Generate. DO NOT EDIT.
+
+package gentest
+
+import "github.com/mafei198/glib/packet"
+
+func (v *Vec3) MarshalPacket(w *packet.Packet) error {
+	w.WriteFloat32LE(float32(v.X))
+	w.WriteFloat32LE(float32(v.Y))
+	w.WriteFloat32LE(float32(v.Z))
+	return nil
+}
+
+func (v *Vec3) UnmarshalPacket(r *packet.Packet) error {
+	x1, err := r.ReadFloat32LE()
+	if err != nil {
+		return err
+	}
+	v.X = float32(x1)
+	x2, err := r.ReadFloat32LE()
+	if err != nil {
+		return err
+	}
+	v.Y = float32(x2)
+	x3, err := r.ReadFloat32LE()
+	if err != nil {
+		return err
+	}
+	v.Z = float32(x3)
+	return nil
+}
+
+func (v *Item) MarshalPacket(w *packet.Packet) error {
+	if err := w.WriteInt(int64(v.Id), packet.WidthVarint, false); err != nil {
+		return err
+	}
+	if err := w.WriteUint(uint64(v.Count), 8, false); err != nil {
+		return err
+	}
+	w.WriteBool(bool(v.Bound))
+	return nil
+}
+
+func (v *Item) UnmarshalPacket(r *packet.Packet) error {
+	x4, err := r.ReadInt(packet.WidthVarint, false)
+	if err != nil {
+		return err
+	}
+	if err = packet.CheckInt(x4, 32); err != nil {
+		return err
+	}
+	v.Id = int32(x4)
+	x5, err := r.ReadUint(8, false)
+	if err != nil {
+		return err
+	}
+	v.Count = uint16(x5)
+	x6, err := r.ReadBool()
+	if err != nil {
+		return err
+	}
+	v.Bound = bool(x6)
+	return nil
+}
+
+func (v *Player) MarshalPacket(w *packet.Packet) error {
+	if err := w.WriteInt(int64(v.Id), 64, false); err != nil {
+		return err
+	}
+	if err := w.WriteString(string(v.Name)); err != nil {
+		return err
+	}
+	if err := w.WriteInt(int64(v.Level), 16, true); err != nil {
+		return err
+	}
+	if err := w.WriteUint(uint64(v.Exp), packet.WidthVarint, false); err != nil {
+		return err
+	}
+	if err := v.Pos.MarshalPacket(w); err != nil {
+		return err
+	}
+	if err := w.WriteLength(len(v.Items), packet.WidthVarint); err != nil {
+		return err
+	}
+	for i7 := range v.Items {
+		if v.Items[i7] == nil {
+			return packet.ErrNilPointer
+		}
+		if err := (*v.Items[i7]).MarshalPacket(w); err != nil {
+			return err
+		}
+	}
+	if err := w.WriteLength(len(v.Tags), 32); err != nil {
+		return err
+	}
+	for i8 := range v.Tags {
+		if err := w.WriteString32(string(v.Tags[i8])); err != nil {
+			return err
+		}
+	}
+	w.WriteVarBytes([]byte(v.Avatar))
+	w.WriteBool(v.Guild != nil)
+	if v.Guild != nil {
+		if err := w.WriteString(string((*v.Guild))); err != nil {
+			return err
+		}
+	}
+	w.WriteBool(v.Last != nil)
+	if v.Last != nil {
+		if err := (*v.Last).MarshalPacket(w); err != nil {
+			return err
+		}
+	}
+	for i9 := range v.Slots {
+		if err := w.WriteInt(int64(v.Slots[i9]), 8, false); err != nil {
+			return err
+		}
+	}
+	if err := w.WriteLength(len(v.Friends), 16); err != nil {
+		return err
+	}
+	for i10 := range v.Friends {
+		if err := w.WriteInt(int64(v.Friends[i10]), 16, false); err != nil {
+			return err
+		}
+	}
+	w.WriteBool(bool(v.Online))
+	w.WriteFloat64(float64(v.Score))
+	return nil
+}
+
+func (v *Player) UnmarshalPacket(r *packet.Packet) error {
+	x11, err := r.ReadInt(64, false)
+	if err != nil {
+		return err
+	}
+	v.Id = PlayerId(x11)
+	x12, err := r.ReadString()
+	if err != nil {
+		return err
+	}
+	v.Name = string(x12)
+	x13, err := r.ReadInt(16, true)
+	if err != nil {
+		return err
+	}
+	v.Level = int16(x13)
+	x14, err := r.ReadUint(packet.WidthVarint, false)
+	if err != nil {
+		return err
+	}
+	v.Exp = uint64(x14)
+	if err := v.Pos.UnmarshalPacket(r); err != nil {
+		return err
+	}
+	n15, err := r.ReadLength(packet.WidthVarint)
+	if err != nil {
+		return err
+	}
+	v.Items = make([]*Item, 0, packet.PreallocLen(n15))
+	for i16 := 0; i16 < n15; i16++ {
+		var x17 *Item
+		x17 = new(Item)
+		if err := (*x17).UnmarshalPacket(r); err != nil {
+			return err
+		}
+		v.Items = append(v.Items, x17)
+	}
+	n18, err := r.ReadLength(32)
+	if err != nil {
+		return err
+	}
+	v.Tags = make(Tags, 0, packet.PreallocLen(n18))
+	for i19 := 0; i19 < n18; i19++ {
+		var x20 string
+		x21, err := r.ReadString32()
+		if err != nil {
+			return err
+		}
+		x20 = string(x21)
+		v.Tags = append(v.Tags, x20)
+	}
+	x22, err := r.ReadVarBytes()
+	if err != nil {
+		return err
+	}
+	v.Avatar = append([]byte(nil), x22...)
+	x23, err := r.ReadBool()
+	if err != nil {
+		return err
+	}
+	v.Guild = nil
+	if x23 {
+		v.Guild = new(string)
+		x24, err := r.ReadString()
+		if err != nil {
+			return err
+		}
+		(*v.Guild) = string(x24)
+	}
+	x25, err := r.ReadBool()
+	if err != nil {
+		return err
+	}
+	v.Last = nil
+	if x25 {
+		v.Last = new(Vec3)
+		if err := (*v.Last).UnmarshalPacket(r); err != nil {
+			return err
+		}
+	}
+	for i26 := range v.Slots {
+		x27, err := r.ReadInt(8, false)
+		if err != nil {
+			return err
+		}
+		v.Slots[i26] = int8(x27)
+	}
+	n28, err := r.ReadLength(16)
+	if err != nil {
+		return err
+	}
+	v.Friends = make([]int32, 0, packet.PreallocLen(n28))
+	for i29 := 0; i29 < n28; i29++ {
+		var x30 int32
+		x31, err := r.ReadInt(16, false)
+		if err != nil {
+			return err
+		}
+		x30 = int32(x31)
+		v.Friends = append(v.Friends, x30)
+	}
+	x32, err := r.ReadBool()
+	if err != nil {
+		return err
+	}
+	v.Online = bool(x32)
+	x33, err := r.ReadFloat64()
+	if err != nil {
+		return err
+	}
+	v.Score = float64(x33)
+	return nil
+}
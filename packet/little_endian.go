@@ -0,0 +1,149 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package packet
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// 小端序读写, 用于与使用小端序的客户端或文件格式交互
+
+func (p *Packet) ReadUint16LE() (ret uint16, err error) {
+	if err = p.failed(); err != nil {
+		return
+	}
+	if p.pos+2 > uint(len(p.data)) {
+		err = p.fail(errors.New("read uint16 failed"))
+		return
+	}
+	ret = binary.LittleEndian.Uint16(p.data[p.pos:])
+	p.pos += 2
+	return
+}
+
+func (p *Packet) ReadInt16LE() (ret int16, err error) {
+	_ret, err := p.ReadUint16LE()
+	ret = int16(_ret)
+	return
+}
+
+func (p *Packet) ReadUint32LE() (ret uint32, err error) {
+	if err = p.failed(); err != nil {
+		return
+	}
+	if p.pos+4 > uint(len(p.data)) {
+		err = p.fail(errors.New("read uint32 failed"))
+		return
+	}
+	ret = binary.LittleEndian.Uint32(p.data[p.pos:])
+	p.pos += 4
+	return
+}
+
+func (p *Packet) ReadInt32LE() (ret int32, err error) {
+	_ret, err := p.ReadUint32LE()
+	ret = int32(_ret)
+	return
+}
+
+func (p *Packet) ReadUint64LE() (ret uint64, err error) {
+	if err = p.failed(); err != nil {
+		return
+	}
+	if p.pos+8 > uint(len(p.data)) {
+		err = p.fail(errors.New("read uint64 failed"))
+		return
+	}
+	ret = binary.LittleEndian.Uint64(p.data[p.pos:])
+	p.pos += 8
+	return
+}
+
+func (p *Packet) ReadInt64LE() (ret int64, err error) {
+	_ret, err := p.ReadUint64LE()
+	ret = int64(_ret)
+	return
+}
+
+// 与ReadFloat32相同, NaN及Inf读为0
+func (p *Packet) ReadFloat32LE() (ret float32, err error) {
+	bits, err := p.ReadUint32LE()
+	if err != nil {
+		return
+	}
+	ret = math.Float32frombits(bits)
+	if math.IsNaN(float64(ret)) || math.IsInf(float64(ret), 0) {
+		return 0, nil
+	}
+	return ret, nil
+}
+
+func (p *Packet) ReadFloat64LE() (ret float64, err error) {
+	bits, err := p.ReadUint64LE()
+	if err != nil {
+		return
+	}
+	ret = math.Float64frombits(bits)
+	if math.IsNaN(ret) || math.IsInf(ret, 0) {
+		return 0, nil
+	}
+	return ret, nil
+}
+
+func (p *Packet) WriteUint16LE(v uint16) {
+	p.data = append(p.data, byte(v), byte(v>>8))
+}
+
+func (p *Packet) WriteInt16LE(v int16) {
+	p.WriteUint16LE(uint16(v))
+}
+
+func (p *Packet) WriteUint32LE(v uint32) {
+	p.data = append(p.data, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func (p *Packet) WriteInt32LE(v int32) {
+	p.WriteUint32LE(uint32(v))
+}
+
+func (p *Packet) WriteUint64LE(v uint64) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	p.data = append(p.data, buf[:]...)
+}
+
+func (p *Packet) WriteInt64LE(v int64) {
+	p.WriteUint64LE(uint64(v))
+}
+
+func (p *Packet) WriteFloat32LE(f float32) {
+	p.WriteUint32LE(math.Float32bits(f))
+}
+
+func (p *Packet) WriteFloat64LE(f float64) {
+	p.WriteUint64LE(math.Float64bits(f))
+}
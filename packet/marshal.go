@@ -0,0 +1,773 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package packet
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// 整数超出编码宽度或字段类型的范围
+	ErrValueOverflow = errors.New("packet: value overflow")
+	// 未标记optional的指针字段为nil
+	ErrNilPointer = errors.New("packet: nil pointer field")
+)
+
+// 整数及长度头使用varint编码, 有符号整数为zigzag编码
+const WidthVarint = -1
+
+// 解码slice时预分配的元素数上限, 之后随解码的元素增长,
+// 避免嵌套的长度头让每一层都按剩余字节数分配内存
+const maxSlicePrealloc = 32
+
+// PreallocLen 解码长度头为n的slice时的初始容量
+func PreallocLen(n int) int {
+	if n > maxSlicePrealloc {
+		return maxSlicePrealloc
+	}
+	return n
+}
+
+// Marshaler 由Generate生成, WriteStruct优先使用, 避免反射
+type Marshaler interface {
+	MarshalPacket(w *Packet) error
+}
+
+// Unmarshaler 由Generate生成, ReadStruct优先使用
+type Unmarshaler interface {
+	UnmarshalPacket(r *Packet) error
+}
+
+var (
+	marshalerType   = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+)
+
+// Marshal 按struct tag编码结构体, 规则见WriteStruct
+func Marshal(v interface{}) ([]byte, error) {
+	w := Writer()
+	if err := w.WriteStruct(v); err != nil {
+		return nil, err
+	}
+	return w.Data(), nil
+}
+
+// Unmarshal 解码Marshal编码的数据, v须为结构体指针
+func Unmarshal(data []byte, v interface{}) error {
+	return Reader(data).ReadStruct(v)
+}
+
+// WriteStruct 按声明顺序编码结构体的导出字段, 字段的packet tag以逗号分隔以下选项:
+//
+//	"-"                不编码该字段
+//	order=N            按N从小到大编码, 须所有编码的字段都指定
+//	8/16/32/64         整数的编码宽度, 不超过字段本身的宽度, 默认与字段相同, int/uint为64
+//	varint             整数使用varint
+//	le                 整数及浮点数使用小端序, 默认大端序
+//	optional           指针字段前加1字节标记是否为nil, 否则nil指针返回ErrNilPointer
+//	len=16/32/varint   string/[]byte及slice的长度头, 默认16, 与WriteString相同
+//
+// 支持bool、整数、浮点数、string、slice、数组、结构体及指针, 选项同样作用于slice/数组/指针的元素.
+// slice元素编码后至少占1字节
+func (p *Packet) WriteStruct(v interface{}) error {
+	if m, ok := v.(Marshaler); ok {
+		return m.MarshalPacket(p)
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return ErrNilPointer
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("packet: WriteStruct requires a struct, got %T", v)
+	}
+	// 嵌套结构体的Marshaler须通过地址调用
+	if !rv.CanAddr() {
+		copied := reflect.New(rv.Type()).Elem()
+		copied.Set(rv)
+		rv = copied
+	}
+	codec, err := getStructCodec(rv.Type())
+	if err != nil {
+		return err
+	}
+	return codec.encode(p, rv)
+}
+
+// ReadStruct 解码WriteStruct编码的数据, v须为非nil的结构体指针
+func (p *Packet) ReadStruct(v interface{}) error {
+	if u, ok := v.(Unmarshaler); ok {
+		return u.UnmarshalPacket(p)
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("packet: ReadStruct requires a non-nil struct pointer, got %T", v)
+	}
+	codec, err := getStructCodec(rv.Elem().Type())
+	if err != nil {
+		return err
+	}
+	return codec.decode(p, rv.Elem())
+}
+
+// WriteInt 按宽度写入有符号整数, 超出宽度范围时返回ErrValueOverflow
+func (p *Packet) WriteInt(v int64, width int, le bool) error {
+	if width == WidthVarint {
+		p.WriteVarint(v)
+		return nil
+	}
+	if err := CheckInt(v, width); err != nil {
+		return err
+	}
+	return p.writeFixed(uint64(v), width, le)
+}
+
+// WriteUint 按宽度写入无符号整数, 超出宽度范围时返回ErrValueOverflow
+func (p *Packet) WriteUint(v uint64, width int, le bool) error {
+	if width == WidthVarint {
+		p.WriteUvarint(v)
+		return nil
+	}
+	if err := CheckUint(v, width); err != nil {
+		return err
+	}
+	return p.writeFixed(v, width, le)
+}
+
+func (p *Packet) writeFixed(v uint64, width int, le bool) error {
+	switch {
+	case width == 8:
+		p.WriteByte(byte(v))
+	case width == 16 && le:
+		p.WriteUint16LE(uint16(v))
+	case width == 16:
+		p.WriteUint16(uint16(v))
+	case width == 32 && le:
+		p.WriteUint32LE(uint32(v))
+	case width == 32:
+		p.WriteUint32(uint32(v))
+	case width == 64 && le:
+		p.WriteUint64LE(v)
+	case width == 64:
+		p.WriteUint64(v)
+	default:
+		return fmt.Errorf("packet: invalid width %d", width)
+	}
+	return nil
+}
+
+// ReadInt 按宽度读取有符号整数, 与WriteInt对应
+func (p *Packet) ReadInt(width int, le bool) (int64, error) {
+	if width == WidthVarint {
+		return p.ReadVarint()
+	}
+	v, err := p.readFixed(width, le)
+	if err != nil {
+		return 0, err
+	}
+	switch width {
+	case 8:
+		return int64(int8(v)), nil
+	case 16:
+		return int64(int16(v)), nil
+	case 32:
+		return int64(int32(v)), nil
+	}
+	return int64(v), nil
+}
+
+// ReadUint 按宽度读取无符号整数, 与WriteUint对应
+func (p *Packet) ReadUint(width int, le bool) (uint64, error) {
+	if width == WidthVarint {
+		return p.ReadUvarint()
+	}
+	return p.readFixed(width, le)
+}
+
+func (p *Packet) readFixed(width int, le bool) (uint64, error) {
+	switch {
+	case width == 8:
+		v, err := p.ReadByte()
+		return uint64(v), err
+	case width == 16 && le:
+		v, err := p.ReadUint16LE()
+		return uint64(v), err
+	case width == 16:
+		v, err := p.ReadUint16()
+		return uint64(v), err
+	case width == 32 && le:
+		v, err := p.ReadUint32LE()
+		return uint64(v), err
+	case width == 32:
+		v, err := p.ReadUint32()
+		return uint64(v), err
+	case width == 64 && le:
+		return p.ReadUint64LE()
+	case width == 64:
+		return p.ReadUint64()
+	}
+	return 0, p.fail(fmt.Errorf("packet: invalid width %d", width))
+}
+
+// CheckInt v超出bits位有符号整数的范围时返回ErrValueOverflow
+func CheckInt(v int64, bits int) error {
+	if bits < 64 && (v < -1<<uint(bits-1) || v > 1<<uint(bits-1)-1) {
+		return ErrValueOverflow
+	}
+	return nil
+}
+
+// CheckUint v超出bits位无符号整数的范围时返回ErrValueOverflow
+func CheckUint(v uint64, bits int) error {
+	if bits < 64 && v > 1<<uint(bits)-1 {
+		return ErrValueOverflow
+	}
+	return nil
+}
+
+// WriteLength 写入slice等的长度头, width为16、32或WidthVarint
+func (p *Packet) WriteLength(n int, width int) error {
+	switch width {
+	case 16:
+		if n > math.MaxUint16 {
+			return ErrLengthOverflow
+		}
+		p.WriteUint16(uint16(n))
+	case 32:
+		if uint64(n) > math.MaxUint32 {
+			return ErrLengthOverflow
+		}
+		p.WriteUint32(uint32(n))
+	case WidthVarint:
+		p.WriteUvarint(uint64(n))
+	default:
+		return fmt.Errorf("packet: invalid length width %d", width)
+	}
+	return nil
+}
+
+// ReadLength 读取WriteLength写入的长度, 超过剩余字节数时返回ErrLengthOverflow
+func (p *Packet) ReadLength(width int) (int, error) {
+	pos := p.pos
+	var n uint64
+	var err error
+	switch width {
+	case 16:
+		var v uint16
+		v, err = p.ReadUint16()
+		n = uint64(v)
+	case 32:
+		var v uint32
+		v, err = p.ReadUint32()
+		n = uint64(v)
+	case WidthVarint:
+		n, err = p.ReadUvarint()
+	default:
+		return 0, p.fail(fmt.Errorf("packet: invalid length width %d", width))
+	}
+	if err != nil {
+		return 0, err
+	}
+	if p.pos > uint(len(p.data)) || n > uint64(uint(len(p.data))-p.pos) {
+		p.pos = pos
+		return 0, p.fail(ErrLengthOverflow)
+	}
+	return int(n), nil
+}
+
+// 字段的packet tag选项
+type fieldOptions struct {
+	skip     bool
+	le       bool
+	optional bool
+	hasOrder bool
+	order    int
+	width    int
+	lenWidth int
+}
+
+func parseTag(tag string) (*fieldOptions, error) {
+	opts := &fieldOptions{lenWidth: 16}
+	if tag == "-" {
+		opts.skip = true
+		return opts, nil
+	}
+	for _, item := range strings.Split(tag, ",") {
+		item = strings.TrimSpace(item)
+		switch {
+		case item == "":
+		case item == "le":
+			opts.le = true
+		case item == "optional":
+			opts.optional = true
+		case item == "varint":
+			opts.width = WidthVarint
+		case item == "8", item == "16", item == "32", item == "64":
+			opts.width, _ = strconv.Atoi(item)
+		case item == "len=16":
+			opts.lenWidth = 16
+		case item == "len=32":
+			opts.lenWidth = 32
+		case item == "len=varint":
+			opts.lenWidth = WidthVarint
+		case strings.HasPrefix(item, "order="):
+			order, err := strconv.Atoi(item[len("order="):])
+			if err != nil {
+				return nil, fmt.Errorf("packet: invalid tag option %q", item)
+			}
+			opts.hasOrder = true
+			opts.order = order
+		default:
+			return nil, fmt.Errorf("packet: invalid tag option %q", item)
+		}
+	}
+	return opts, nil
+}
+
+// 整数字段的编码宽度, bits为字段本身的宽度
+func (opts *fieldOptions) intWidth(bits int) (int, error) {
+	if opts.width == 0 {
+		return bits, nil
+	}
+	if opts.width > bits {
+		return 0, fmt.Errorf("packet: width %d exceeds %d bits field", opts.width, bits)
+	}
+	return opts.width, nil
+}
+
+// 返回字段的编码顺序, 使用order时须所有字段都指定
+func orderFields(opts []*fieldOptions) ([]int, error) {
+	indexes := make([]int, 0, len(opts))
+	ordered := 0
+	for i, opt := range opts {
+		if opt.skip {
+			continue
+		}
+		if opt.hasOrder {
+			ordered++
+		}
+		indexes = append(indexes, i)
+	}
+	if ordered == 0 {
+		return indexes, nil
+	}
+	if ordered != len(indexes) {
+		return nil, errors.New("packet: order must be set on all fields")
+	}
+	sort.SliceStable(indexes, func(i, j int) bool {
+		return opts[indexes[i]].order < opts[indexes[j]].order
+	})
+	for i := 1; i < len(indexes); i++ {
+		if opts[indexes[i]].order == opts[indexes[i-1]].order {
+			return nil, fmt.Errorf("packet: duplicate order %d", opts[indexes[i]].order)
+		}
+	}
+	return indexes, nil
+}
+
+type encodeFunc func(p *Packet, v reflect.Value) error
+type decodeFunc func(p *Packet, v reflect.Value) error
+
+type structField struct {
+	index int
+	enc   encodeFunc
+	dec   decodeFunc
+}
+
+type structCodec struct {
+	fields []*structField
+}
+
+// reflect.Type -> *structCodec
+var structCodecs sync.Map
+
+func getStructCodec(t reflect.Type) (*structCodec, error) {
+	if codec, ok := structCodecs.Load(t); ok {
+		return codec.(*structCodec), nil
+	}
+	codec, err := newStructCodec(t)
+	if err != nil {
+		return nil, err
+	}
+	structCodecs.Store(t, codec)
+	return codec, nil
+}
+
+func newStructCodec(t reflect.Type) (*structCodec, error) {
+	var exported []int
+	var opts []*fieldOptions
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		opt, err := parseTag(field.Tag.Get("packet"))
+		if err != nil {
+			return nil, fmt.Errorf("%v: %s.%s", err, t, field.Name)
+		}
+		exported = append(exported, i)
+		opts = append(opts, opt)
+	}
+	indexes, err := orderFields(opts)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, t)
+	}
+	codec := &structCodec{}
+	for _, i := range indexes {
+		field := t.Field(exported[i])
+		enc, dec, err := newCodec(field.Type, opts[i])
+		if err != nil {
+			return nil, fmt.Errorf("%v: %s.%s", err, t, field.Name)
+		}
+		codec.fields = append(codec.fields, &structField{index: exported[i], enc: enc, dec: dec})
+	}
+	return codec, nil
+}
+
+func (c *structCodec) encode(p *Packet, v reflect.Value) error {
+	for _, field := range c.fields {
+		if err := field.enc(p, v.Field(field.index)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *structCodec) decode(p *Packet, v reflect.Value) error {
+	for _, field := range c.fields {
+		if err := field.dec(p, v.Field(field.index)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newCodec(t reflect.Type, opts *fieldOptions) (encodeFunc, decodeFunc, error) {
+	if t.Kind() == reflect.Struct && reflect.PtrTo(t).Implements(marshalerType) &&
+		reflect.PtrTo(t).Implements(unmarshalerType) {
+		return encodeMarshaler, decodeUnmarshaler, nil
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return encodeBool, decodeBool, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		width, err := opts.intWidth(t.Bits())
+		if err != nil {
+			return nil, nil, err
+		}
+		return intCodec(width, opts.le)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		width, err := opts.intWidth(t.Bits())
+		if err != nil {
+			return nil, nil, err
+		}
+		return uintCodec(width, opts.le)
+	case reflect.Float32, reflect.Float64:
+		return floatCodec(t.Bits(), opts.le)
+	case reflect.String:
+		return stringCodec(opts.lenWidth)
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return bytesCodec(opts.lenWidth)
+		}
+		return sliceCodec(t, opts)
+	case reflect.Array:
+		return arrayCodec(t, opts)
+	case reflect.Ptr:
+		return ptrCodec(t, opts)
+	case reflect.Struct:
+		return encodeStruct, decodeStruct, nil
+	}
+	return nil, nil, fmt.Errorf("packet: unsupported type %s", t)
+}
+
+func encodeMarshaler(p *Packet, v reflect.Value) error {
+	return v.Addr().Interface().(Marshaler).MarshalPacket(p)
+}
+
+func decodeUnmarshaler(p *Packet, v reflect.Value) error {
+	return v.Addr().Interface().(Unmarshaler).UnmarshalPacket(p)
+}
+
+// 结构体的codec在使用时获取, 支持递归类型
+func encodeStruct(p *Packet, v reflect.Value) error {
+	codec, err := getStructCodec(v.Type())
+	if err != nil {
+		return err
+	}
+	return codec.encode(p, v)
+}
+
+func decodeStruct(p *Packet, v reflect.Value) error {
+	codec, err := getStructCodec(v.Type())
+	if err != nil {
+		return err
+	}
+	return codec.decode(p, v)
+}
+
+func encodeBool(p *Packet, v reflect.Value) error {
+	p.WriteBool(v.Bool())
+	return nil
+}
+
+func decodeBool(p *Packet, v reflect.Value) error {
+	b, err := p.ReadBool()
+	if err != nil {
+		return err
+	}
+	v.SetBool(b)
+	return nil
+}
+
+func intCodec(width int, le bool) (encodeFunc, decodeFunc, error) {
+	enc := func(p *Packet, v reflect.Value) error {
+		return p.WriteInt(v.Int(), width, le)
+	}
+	dec := func(p *Packet, v reflect.Value) error {
+		x, err := p.ReadInt(width, le)
+		if err != nil {
+			return err
+		}
+		if v.OverflowInt(x) {
+			return p.fail(ErrValueOverflow)
+		}
+		v.SetInt(x)
+		return nil
+	}
+	return enc, dec, nil
+}
+
+func uintCodec(width int, le bool) (encodeFunc, decodeFunc, error) {
+	enc := func(p *Packet, v reflect.Value) error {
+		return p.WriteUint(v.Uint(), width, le)
+	}
+	dec := func(p *Packet, v reflect.Value) error {
+		x, err := p.ReadUint(width, le)
+		if err != nil {
+			return err
+		}
+		if v.OverflowUint(x) {
+			return p.fail(ErrValueOverflow)
+		}
+		v.SetUint(x)
+		return nil
+	}
+	return enc, dec, nil
+}
+
+func floatCodec(bits int, le bool) (encodeFunc, decodeFunc, error) {
+	if bits == 32 {
+		enc := func(p *Packet, v reflect.Value) error {
+			if le {
+				p.WriteFloat32LE(float32(v.Float()))
+			} else {
+				p.WriteFloat32(float32(v.Float()))
+			}
+			return nil
+		}
+		dec := func(p *Packet, v reflect.Value) error {
+			var f float32
+			var err error
+			if le {
+				f, err = p.ReadFloat32LE()
+			} else {
+				f, err = p.ReadFloat32()
+			}
+			v.SetFloat(float64(f))
+			return err
+		}
+		return enc, dec, nil
+	}
+	enc := func(p *Packet, v reflect.Value) error {
+		if le {
+			p.WriteFloat64LE(v.Float())
+		} else {
+			p.WriteFloat64(v.Float())
+		}
+		return nil
+	}
+	dec := func(p *Packet, v reflect.Value) error {
+		var f float64
+		var err error
+		if le {
+			f, err = p.ReadFloat64LE()
+		} else {
+			f, err = p.ReadFloat64()
+		}
+		v.SetFloat(f)
+		return err
+	}
+	return enc, dec, nil
+}
+
+func stringCodec(lenWidth int) (encodeFunc, decodeFunc, error) {
+	enc := func(p *Packet, v reflect.Value) error {
+		s := v.String()
+		if err := p.WriteLength(len(s), lenWidth); err != nil {
+			return err
+		}
+		p.data = append(p.data, s...)
+		return nil
+	}
+	dec := func(p *Packet, v reflect.Value) error {
+		b, err := p.readPrefixed(lenWidth)
+		if err != nil {
+			return err
+		}
+		v.SetString(string(b))
+		return nil
+	}
+	return enc, dec, nil
+}
+
+// 解码得到的[]byte不引用原数据
+func bytesCodec(lenWidth int) (encodeFunc, decodeFunc, error) {
+	enc := func(p *Packet, v reflect.Value) error {
+		b := v.Bytes()
+		if err := p.WriteLength(len(b), lenWidth); err != nil {
+			return err
+		}
+		p.data = append(p.data, b...)
+		return nil
+	}
+	dec := func(p *Packet, v reflect.Value) error {
+		b, err := p.readPrefixed(lenWidth)
+		if err != nil {
+			return err
+		}
+		v.SetBytes(append([]byte(nil), b...))
+		return nil
+	}
+	return enc, dec, nil
+}
+
+func (p *Packet) readPrefixed(lenWidth int) ([]byte, error) {
+	n, err := p.ReadLength(lenWidth)
+	if err != nil {
+		return nil, err
+	}
+	return p.readN(uint64(n), "read prefixed data failed")
+}
+
+func sliceCodec(t reflect.Type, opts *fieldOptions) (encodeFunc, decodeFunc, error) {
+	elemEnc, elemDec, err := newCodec(t.Elem(), opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	zero := reflect.Zero(t.Elem())
+	enc := func(p *Packet, v reflect.Value) error {
+		if err := p.WriteLength(v.Len(), opts.lenWidth); err != nil {
+			return err
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := elemEnc(p, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	dec := func(p *Packet, v reflect.Value) error {
+		n, err := p.ReadLength(opts.lenWidth)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.MakeSlice(t, 0, PreallocLen(n)))
+		for i := 0; i < n; i++ {
+			v.Set(reflect.Append(v, zero))
+			if err = elemDec(p, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return enc, dec, nil
+}
+
+func arrayCodec(t reflect.Type, opts *fieldOptions) (encodeFunc, decodeFunc, error) {
+	elemEnc, elemDec, err := newCodec(t.Elem(), opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	enc := func(p *Packet, v reflect.Value) error {
+		for i := 0; i < v.Len(); i++ {
+			if err := elemEnc(p, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	dec := func(p *Packet, v reflect.Value) error {
+		for i := 0; i < v.Len(); i++ {
+			if err := elemDec(p, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return enc, dec, nil
+}
+
+func ptrCodec(t reflect.Type, opts *fieldOptions) (encodeFunc, decodeFunc, error) {
+	elemEnc, elemDec, err := newCodec(t.Elem(), opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	enc := func(p *Packet, v reflect.Value) error {
+		if opts.optional {
+			p.WriteBool(!v.IsNil())
+			if v.IsNil() {
+				return nil
+			}
+		} else if v.IsNil() {
+			return ErrNilPointer
+		}
+		return elemEnc(p, v.Elem())
+	}
+	dec := func(p *Packet, v reflect.Value) error {
+		if opts.optional {
+			present, err := p.ReadBool()
+			if err != nil {
+				return err
+			}
+			if !present {
+				v.Set(reflect.Zero(t))
+				return nil
+			}
+		}
+		v.Set(reflect.New(t.Elem()))
+		return elemDec(p, v.Elem())
+	}
+	return enc, dec, nil
+}
@@ -0,0 +1,139 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package packet
+
+import (
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+type treeNode struct {
+	Value    uint32 `packet:"varint"`
+	Name     string `packet:"len=varint"`
+	Children []treeNode
+	Parent   *treeNode `packet:"optional"`
+	skipped  int
+}
+
+func TestMarshalRecursive(t *testing.T) {
+	tree := treeNode{
+		Value:    1,
+		Name:     "root",
+		Children: []treeNode{{Value: 2, Children: []treeNode{}}, {Value: 3, Children: []treeNode{}}},
+		Parent:   &treeNode{Value: 300, Children: []treeNode{}},
+	}
+	// 非指针结构体同样可以编码
+	data, err := Marshal(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := treeNode{}
+	if err = Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, tree) {
+		t.Fatalf("decoded %+v, want %+v", decoded, tree)
+	}
+}
+
+func TestMarshalFieldOrder(t *testing.T) {
+	type ordered struct {
+		A uint8 `packet:"order=2"`
+		B uint8 `packet:"order=1"`
+		C uint8 `packet:"-"`
+	}
+	data, err := Marshal(&ordered{A: 1, B: 2, C: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 2 || data[0] != 2 || data[1] != 1 {
+		t.Fatalf("unexpected data %v", data)
+	}
+}
+
+func TestMarshalInvalid(t *testing.T) {
+	cases := []struct {
+		v   interface{}
+		err string
+	}{
+		{&struct {
+			A int16 `packet:"32"`
+		}{}, "exceeds"},
+		{&struct {
+			A int16 `packet:"big"`
+		}{}, "invalid tag option"},
+		{&struct {
+			A int8 `packet:"order=1"`
+			B int8
+		}{}, "order must be set"},
+		{&struct {
+			A map[string]int
+		}{}, "unsupported type"},
+		{&struct {
+			A *int
+		}{}, ErrNilPointer.Error()},
+		{&struct {
+			A []int8
+		}{A: make([]int8, 1<<16)}, ErrLengthOverflow.Error()},
+		{3, "requires a struct"},
+	}
+	for _, c := range cases {
+		if _, err := Marshal(c.v); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%T: expect error %q, got %v", c.v, c.err, err)
+		}
+	}
+	var v struct {
+		A []uint64
+	}
+	// 元素数超过剩余字节数
+	if err := Unmarshal([]byte{0xff, 0xff, 1}, &v); err != ErrLengthOverflow {
+		t.Fatalf("expect ErrLengthOverflow, got %v", err)
+	}
+	if err := Unmarshal(nil, v); err == nil {
+		t.Fatal("unmarshal into non-pointer succeeded")
+	}
+}
+
+// 每层都声明接近剩余字节数的元素个数, 预分配不能随嵌套层数放大
+func TestUnmarshalNestedLengths(t *testing.T) {
+	writer := Writer()
+	const depth = 2000
+	for i := 0; i < depth; i++ {
+		writer.WriteUvarint(0)
+		writer.WriteUvarint(0)
+		writer.WriteUint16(uint16(4 * (depth - i - 1)))
+	}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if err := Unmarshal(writer.Data(), &treeNode{}); err == nil {
+		t.Fatal("unmarshal truncated data succeeded")
+	}
+	runtime.ReadMemStats(&after)
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 32<<20 {
+		t.Fatalf("unmarshal %d bytes allocated %d bytes", writer.Length(), alloc)
+	}
+}
@@ -32,9 +32,18 @@ const (
 	PacketLimit = 65533 // 2^16 - 1 - 2
 )
 
+var (
+	// 数据长度超过长度头能表示的范围
+	ErrLengthOverflow = errors.New("packet: length overflow")
+	// varint超过64位
+	ErrVarintOverflow = errors.New("packet: varint overflow")
+)
+
 type Packet struct {
-	pos  uint
-	data []byte
+	pos    uint
+	data   []byte
+	err    error
+	sticky bool
 }
 
 func (p *Packet) Data() []byte {
@@ -42,6 +51,9 @@ func (p *Packet) Data() []byte {
 }
 
 func (p *Packet) RemainData() []byte {
+	if p.pos > uint(len(p.data)) {
+		return nil
+	}
 	return p.data[p.pos:]
 }
 
@@ -53,11 +65,21 @@ func (p *Packet) Pos() uint {
 	return p.pos
 }
 
-func (p *Packet) Seek(n uint) {
+// Seek 跳过n字节, 超出数据范围时返回错误且位置不变
+func (p *Packet) Seek(n uint) error {
+	if err := p.failed(); err != nil {
+		return err
+	}
+	if p.pos > uint(len(p.data)) || n > uint(len(p.data))-p.pos {
+		return p.fail(errors.New("seek out of range"))
+	}
 	p.pos += n
+	return nil
 }
 
 //=============================================== Readers
+// 读取失败时位置不变
+
 func (p *Packet) ReadBool() (ret bool, err error) {
 	b, err := p.ReadByte()
 	if err != nil {
@@ -72,8 +94,11 @@ func (p *Packet) ReadBool() (ret bool, err error) {
 }
 
 func (p *Packet) ReadByte() (ret byte, err error) {
+	if err = p.failed(); err != nil {
+		return
+	}
 	if p.pos >= uint(len(p.data)) {
-		err = errors.New("read byte failed")
+		err = p.fail(errors.New("read byte failed"))
 		return
 	}
 
@@ -83,8 +108,11 @@ func (p *Packet) ReadByte() (ret byte, err error) {
 }
 
 func (p *Packet) ReadBytes() (ret []byte, err error) {
+	if err = p.failed(); err != nil {
+		return
+	}
 	if p.pos+2 > uint(len(p.data)) {
-		err = errors.New("read bytes header failed")
+		err = p.fail(errors.New("read bytes header failed"))
 		return
 	}
 	size, err := p.ReadUint16()
@@ -92,7 +120,8 @@ func (p *Packet) ReadBytes() (ret []byte, err error) {
 		return
 	}
 	if p.pos+uint(size) > uint(len(p.data)) {
-		err = errors.New("read bytes data failed")
+		p.pos -= 2
+		err = p.fail(errors.New("read bytes data failed"))
 		return
 	}
 
@@ -102,8 +131,11 @@ func (p *Packet) ReadBytes() (ret []byte, err error) {
 }
 
 func (p *Packet) ReadString() (ret string, err error) {
+	if err = p.failed(); err != nil {
+		return
+	}
 	if p.pos+2 > uint(len(p.data)) {
-		err = errors.New("read string header failed")
+		err = p.fail(errors.New("read string header failed"))
 		return
 	}
 
@@ -112,7 +144,8 @@ func (p *Packet) ReadString() (ret string, err error) {
 		return
 	}
 	if p.pos+uint(size) > uint(len(p.data)) {
-		err = errors.New("read string data failed")
+		p.pos -= 2
+		err = p.fail(errors.New("read string data failed"))
 		return
 	}
 
@@ -122,9 +155,48 @@ func (p *Packet) ReadString() (ret string, err error) {
 	return
 }
 
+// 读取4字节长度头的数据, 与WriteBytes32对应
+func (p *Packet) ReadBytes32() (ret []byte, err error) {
+	size, err := p.ReadUint32()
+	if err != nil {
+		err = p.fail(errors.New("read bytes32 header failed"))
+		return
+	}
+	if ret, err = p.readN(uint64(size), "read bytes32 data failed"); err != nil {
+		p.pos -= 4
+	}
+	return
+}
+
+func (p *Packet) ReadString32() (ret string, err error) {
+	bytes, err := p.ReadBytes32()
+	if err != nil {
+		return
+	}
+	ret = string(bytes)
+	return
+}
+
+// 读取n字节, 不复制
+func (p *Packet) readN(n uint64, msg string) (ret []byte, err error) {
+	if err = p.failed(); err != nil {
+		return
+	}
+	if p.pos > uint(len(p.data)) || n > uint64(uint(len(p.data))-p.pos) {
+		err = p.fail(errors.New(msg))
+		return
+	}
+	ret = p.data[p.pos : p.pos+uint(n)]
+	p.pos += uint(n)
+	return
+}
+
 func (p *Packet) ReadUint16() (ret uint16, err error) {
+	if err = p.failed(); err != nil {
+		return
+	}
 	if p.pos+2 > uint(len(p.data)) {
-		err = errors.New("read uint16 failed")
+		err = p.fail(errors.New("read uint16 failed"))
 		return
 	}
 
@@ -144,8 +216,11 @@ func (p *Packet) ReadInt16() (ret int16, err error) {
 }
 
 func (p *Packet) ReadUint24() (ret uint32, err error) {
+	if err = p.failed(); err != nil {
+		return
+	}
 	if p.pos+3 > uint(len(p.data)) {
-		err = errors.New("read uint24 failed")
+		err = p.fail(errors.New("read uint24 failed"))
 		return
 	}
 
@@ -165,8 +240,11 @@ func (p *Packet) ReadInt24() (ret int32, err error) {
 }
 
 func (p *Packet) ReadUint32() (ret uint32, err error) {
+	if err = p.failed(); err != nil {
+		return
+	}
 	if p.pos+4 > uint(len(p.data)) {
-		err = errors.New("read uint32 failed")
+		err = p.fail(errors.New("read uint32 failed"))
 		return
 	}
 
@@ -186,8 +264,11 @@ func (p *Packet) ReadInt32() (ret int32, err error) {
 }
 
 func (p *Packet) ReadUint64() (ret uint64, err error) {
+	if err = p.failed(); err != nil {
+		return
+	}
 	if p.pos+8 > uint(len(p.data)) {
-		err = errors.New("read uint64 failed")
+		err = p.fail(errors.New("read uint64 failed"))
 		return
 	}
 
@@ -251,19 +332,48 @@ func (p *Packet) WriteByte(v byte) {
 	p.data = append(p.data, v)
 }
 
-func (p *Packet) WriteBytes(v []byte) {
+// 2字节长度头, 超过65535字节时返回ErrLengthOverflow且不写入
+func (p *Packet) WriteBytes(v []byte) error {
+	if len(v) > math.MaxUint16 {
+		return ErrLengthOverflow
+	}
 	p.WriteUint16(uint16(len(v)))
 	p.data = append(p.data, v...)
+	return nil
+}
+
+// 4字节长度头
+func (p *Packet) WriteBytes32(v []byte) error {
+	if uint64(len(v)) > math.MaxUint32 {
+		return ErrLengthOverflow
+	}
+	p.WriteUint32(uint32(len(v)))
+	p.data = append(p.data, v...)
+	return nil
 }
 
 func (p *Packet) WriteRawBytes(v []byte) {
 	p.data = append(p.data, v...)
 }
 
-func (p *Packet) WriteString(v string) {
-	bytes := []byte(v)
-	p.WriteUint16(uint16(len(bytes)))
-	p.data = append(p.data, bytes...)
+// 2字节长度头, 超过65535字节时返回ErrLengthOverflow且不写入
+func (p *Packet) WriteString(v string) error {
+	if len(v) > math.MaxUint16 {
+		return ErrLengthOverflow
+	}
+	p.WriteUint16(uint16(len(v)))
+	p.data = append(p.data, v...)
+	return nil
+}
+
+// 4字节长度头
+func (p *Packet) WriteString32(v string) error {
+	if uint64(len(v)) > math.MaxUint32 {
+		return ErrLengthOverflow
+	}
+	p.WriteUint32(uint32(len(v)))
+	p.data = append(p.data, v...)
+	return nil
 }
 
 func (p *Packet) WriteUint16(v uint16) {
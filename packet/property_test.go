@@ -0,0 +1,303 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package packet
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+	"testing/quick"
+)
+
+// 检查write写入的数据能被read完整读回want, 且截断任意一个字节后读取失败
+func roundTrip(write func(w *Packet) error, read func(r *Packet) (interface{}, error), want interface{}) bool {
+	w := Writer()
+	if err := write(w); err != nil {
+		return false
+	}
+	data := w.Data()
+	r := Reader(data)
+	got, err := read(r)
+	if err != nil || r.Remaining() != 0 || !valueEqual(got, want) {
+		return false
+	}
+	if len(data) == 0 {
+		return true
+	}
+	r = StickyReader(data[:len(data)-1])
+	if _, err = read(r); err == nil || r.Err() == nil {
+		return false
+	}
+	return true
+}
+
+func valueEqual(got, want interface{}) bool {
+	if b, ok := want.([]byte); ok {
+		return bytes.Equal(got.([]byte), b)
+	}
+	return reflect.DeepEqual(got, want)
+}
+
+func TestRoundTripProperties(t *testing.T) {
+	props := map[string]interface{}{
+		"Bool": func(v bool) bool {
+			return roundTrip(func(w *Packet) error { w.WriteBool(v); return nil },
+				func(r *Packet) (interface{}, error) { return r.ReadBool() }, v)
+		},
+		"Byte": func(v byte) bool {
+			return roundTrip(func(w *Packet) error { w.WriteByte(v); return nil },
+				func(r *Packet) (interface{}, error) { return r.ReadByte() }, v)
+		},
+		"Bytes": func(v []byte) bool {
+			return roundTrip(func(w *Packet) error { return w.WriteBytes(v) },
+				func(r *Packet) (interface{}, error) { return r.ReadBytes() }, v)
+		},
+		"String": func(v string) bool {
+			return roundTrip(func(w *Packet) error { return w.WriteString(v) },
+				func(r *Packet) (interface{}, error) { return r.ReadString() }, v)
+		},
+		"Bytes32": func(v []byte) bool {
+			return roundTrip(func(w *Packet) error { return w.WriteBytes32(v) },
+				func(r *Packet) (interface{}, error) { return r.ReadBytes32() }, v)
+		},
+		"String32": func(v string) bool {
+			return roundTrip(func(w *Packet) error { return w.WriteString32(v) },
+				func(r *Packet) (interface{}, error) { return r.ReadString32() }, v)
+		},
+		"RawBytes": func(v []byte) bool {
+			return roundTrip(func(w *Packet) error { w.WriteRawBytes(v); return nil },
+				func(r *Packet) (interface{}, error) { return r.ReadRawBytes(len(v)) }, v)
+		},
+		"Zeros": func(n uint8) bool {
+			return roundTrip(func(w *Packet) error { w.WriteZeros(int(n)); return nil },
+				func(r *Packet) (interface{}, error) { return r.ReadRawBytes(int(n)) }, make([]byte, n))
+		},
+		"Uint16": func(v uint16) bool {
+			return roundTrip(func(w *Packet) error { w.WriteUint16(v); return nil },
+				func(r *Packet) (interface{}, error) { return r.ReadUint16() }, v)
+		},
+		"Int16": func(v int16) bool {
+			return roundTrip(func(w *Packet) error { w.WriteInt16(v); return nil },
+				func(r *Packet) (interface{}, error) { return r.ReadInt16() }, v)
+		},
+		"Uint24": func(v uint32) bool {
+			v &= 1<<24 - 1
+			return roundTrip(func(w *Packet) error { w.WriteUint24(v); return nil },
+				func(r *Packet) (interface{}, error) { return r.ReadUint24() }, v)
+		},
+		"Uint32": func(v uint32) bool {
+			return roundTrip(func(w *Packet) error { w.WriteUint32(v); return nil },
+				func(r *Packet) (interface{}, error) { return r.ReadUint32() }, v)
+		},
+		"Int32": func(v int32) bool {
+			return roundTrip(func(w *Packet) error { w.WriteInt32(v); return nil },
+				func(r *Packet) (interface{}, error) { return r.ReadInt32() }, v)
+		},
+		"Uint64": func(v uint64) bool {
+			return roundTrip(func(w *Packet) error { w.WriteUint64(v); return nil },
+				func(r *Packet) (interface{}, error) { return r.ReadUint64() }, v)
+		},
+		"Int64": func(v int64) bool {
+			return roundTrip(func(w *Packet) error { w.WriteInt64(v); return nil },
+				func(r *Packet) (interface{}, error) { return r.ReadInt64() }, v)
+		},
+		"Float32": func(v float32) bool {
+			return roundTrip(func(w *Packet) error { w.WriteFloat32(v); return nil },
+				func(r *Packet) (interface{}, error) { return r.ReadFloat32() }, v)
+		},
+		"Float64": func(v float64) bool {
+			return roundTrip(func(w *Packet) error { w.WriteFloat64(v); return nil },
+				func(r *Packet) (interface{}, error) { return r.ReadFloat64() }, v)
+		},
+		"Uint16LE": func(v uint16) bool {
+			return roundTrip(func(w *Packet) error { w.WriteUint16LE(v); return nil },
+				func(r *Packet) (interface{}, error) { return r.ReadUint16LE() }, v)
+		},
+		"Int16LE": func(v int16) bool {
+			return roundTrip(func(w *Packet) error { w.WriteInt16LE(v); return nil },
+				func(r *Packet) (interface{}, error) { return r.ReadInt16LE() }, v)
+		},
+		"Uint32LE": func(v uint32) bool {
+			return roundTrip(func(w *Packet) error { w.WriteUint32LE(v); return nil },
+				func(r *Packet) (interface{}, error) { return r.ReadUint32LE() }, v)
+		},
+		"Int32LE": func(v int32) bool {
+			return roundTrip(func(w *Packet) error { w.WriteInt32LE(v); return nil },
+				func(r *Packet) (interface{}, error) { return r.ReadInt32LE() }, v)
+		},
+		"Uint64LE": func(v uint64) bool {
+			return roundTrip(func(w *Packet) error { w.WriteUint64LE(v); return nil },
+				func(r *Packet) (interface{}, error) { return r.ReadUint64LE() }, v)
+		},
+		"Int64LE": func(v int64) bool {
+			return roundTrip(func(w *Packet) error { w.WriteInt64LE(v); return nil },
+				func(r *Packet) (interface{}, error) { return r.ReadInt64LE() }, v)
+		},
+		"Float32LE": func(v float32) bool {
+			return roundTrip(func(w *Packet) error { w.WriteFloat32LE(v); return nil },
+				func(r *Packet) (interface{}, error) { return r.ReadFloat32LE() }, v)
+		},
+		"Float64LE": func(v float64) bool {
+			return roundTrip(func(w *Packet) error { w.WriteFloat64LE(v); return nil },
+				func(r *Packet) (interface{}, error) { return r.ReadFloat64LE() }, v)
+		},
+		"Uvarint": func(v uint64) bool {
+			return roundTrip(func(w *Packet) error { w.WriteUvarint(v); return nil },
+				func(r *Packet) (interface{}, error) { return r.ReadUvarint() }, v)
+		},
+		"Varint": func(v int64) bool {
+			return roundTrip(func(w *Packet) error { w.WriteVarint(v); return nil },
+				func(r *Packet) (interface{}, error) { return r.ReadVarint() }, v)
+		},
+		"Uvarint32": func(v uint32) bool {
+			return roundTrip(func(w *Packet) error { w.WriteUvarint(uint64(v)); return nil },
+				func(r *Packet) (interface{}, error) { return r.ReadUvarint32() }, v)
+		},
+		"Varint32": func(v int32) bool {
+			return roundTrip(func(w *Packet) error { w.WriteVarint(int64(v)); return nil },
+				func(r *Packet) (interface{}, error) { return r.ReadVarint32() }, v)
+		},
+		"VarBytes": func(v []byte) bool {
+			return roundTrip(func(w *Packet) error { w.WriteVarBytes(v); return nil },
+				func(r *Packet) (interface{}, error) { return r.ReadVarBytes() }, v)
+		},
+		"VarString": func(v string) bool {
+			return roundTrip(func(w *Packet) error { w.WriteVarString(v); return nil },
+				func(r *Packet) (interface{}, error) { return r.ReadVarString() }, v)
+		},
+		"Int": func(v int64, sel uint8, le bool) bool {
+			width := []int{8, 16, 32, 64, WidthVarint}[sel%5]
+			if width != WidthVarint && CheckInt(v, width) != nil {
+				v = v >> uint(64-width)
+			}
+			return roundTrip(func(w *Packet) error { return w.WriteInt(v, width, le) },
+				func(r *Packet) (interface{}, error) { return r.ReadInt(width, le) }, v)
+		},
+		"Uint": func(v uint64, sel uint8, le bool) bool {
+			width := []int{8, 16, 32, 64, WidthVarint}[sel%5]
+			if width != WidthVarint && CheckUint(v, width) != nil {
+				v = v >> uint(64-width)
+			}
+			return roundTrip(func(w *Packet) error { return w.WriteUint(v, width, le) },
+				func(r *Packet) (interface{}, error) { return r.ReadUint(width, le) }, v)
+		},
+	}
+	for name, prop := range props {
+		if err := quick.Check(prop, nil); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+// ReadLength只接受不超过剩余字节数的长度
+func TestLengthProperty(t *testing.T) {
+	prop := func(n uint16, sel uint8) bool {
+		width := []int{16, 32, WidthVarint}[sel%3]
+		w := Writer()
+		if err := w.WriteLength(int(n), width); err != nil {
+			return false
+		}
+		w.WriteZeros(int(n))
+		r := Reader(w.Data())
+		if got, err := r.ReadLength(width); err != nil || got != int(n) {
+			return false
+		}
+		if n == 0 {
+			return true
+		}
+		r = Reader(w.Data()[:w.Length()-1])
+		_, err := r.ReadLength(width)
+		return err == ErrLengthOverflow && r.Pos() == 0
+	}
+	if err := quick.Check(prop, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+// 随机顺序写入各类型的值, 按相同顺序全部读回
+func TestMixedSequenceProperty(t *testing.T) {
+	prop := func(ops []uint8, u uint64, s string, f float64) bool {
+		w := Writer()
+		for _, op := range ops {
+			switch op % 6 {
+			case 0:
+				w.WriteUvarint(u)
+			case 1:
+				w.WriteVarint(int64(u))
+			case 2:
+				_ = w.WriteString(s)
+			case 3:
+				w.WriteVarString(s)
+			case 4:
+				w.WriteFloat64(f)
+			case 5:
+				w.WriteUint32LE(uint32(u))
+			}
+		}
+		r := StickyReader(w.Data())
+		for _, op := range ops {
+			var ok bool
+			switch op % 6 {
+			case 0:
+				v, _ := r.ReadUvarint()
+				ok = v == u
+			case 1:
+				v, _ := r.ReadVarint()
+				ok = v == int64(u)
+			case 2:
+				v, _ := r.ReadString()
+				ok = v == s
+			case 3:
+				v, _ := r.ReadVarString()
+				ok = v == s
+			case 4:
+				v, _ := r.ReadFloat64()
+				ok = v == f
+			case 5:
+				v, _ := r.ReadUint32LE()
+				ok = v == uint32(u)
+			}
+			if !ok {
+				return false
+			}
+		}
+		return r.Err() == nil && r.Remaining() == 0
+	}
+	if err := quick.Check(prop, nil); err != nil {
+		t.Error(err)
+	}
+	// 读取时NaN及Inf统一为0
+	w := Writer()
+	w.WriteFloat64(math.NaN())
+	w.WriteFloat32(float32(math.Inf(1)))
+	r := Reader(w.Data())
+	if v, err := r.ReadFloat64(); err != nil || v != 0 {
+		t.Fatalf("ReadFloat64 NaN: %v %v", v, err)
+	}
+	if v, err := r.ReadFloat32(); err != nil || v != 0 {
+		t.Fatalf("ReadFloat32 Inf: %v %v", v, err)
+	}
+}
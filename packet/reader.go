@@ -0,0 +1,208 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package packet
+
+import "errors"
+
+// StickyReader 粘性错误模式的Reader, 第一次读取失败后所有读取都直接返回该错误且不移动位置,
+// 可以连续读取各字段, 最后用Err检查一次
+func StickyReader(data []byte) *Packet {
+	return &Packet{data: data, sticky: true}
+}
+
+// Err 返回第一次读取失败的错误
+func (p *Packet) Err() error {
+	return p.err
+}
+
+// Reset 复用Packet读取新的数据, 清除错误, 保留粘性模式
+func (p *Packet) Reset(data []byte) {
+	p.data = data
+	p.pos = 0
+	p.err = nil
+}
+
+// Remaining 未读取的字节数
+func (p *Packet) Remaining() int {
+	if p.pos > uint(len(p.data)) {
+		return 0
+	}
+	return len(p.data) - int(p.pos)
+}
+
+// Require 检查剩余数据不少于n字节, 之后读取定长字段不会越界
+func (p *Packet) Require(n int) error {
+	if err := p.failed(); err != nil {
+		return err
+	}
+	if n < 0 || p.Remaining() < n {
+		return p.fail(errors.New("require data failed"))
+	}
+	return nil
+}
+
+// 粘性模式下之前的读取已失败时返回该错误
+func (p *Packet) failed() error {
+	if p.sticky {
+		return p.err
+	}
+	return nil
+}
+
+// 记录第一次读取失败的错误
+func (p *Packet) fail(err error) error {
+	if p.err == nil {
+		p.err = err
+	}
+	return err
+}
+
+// 执行read后恢复位置及错误
+func (p *Packet) peek(read func() error) error {
+	pos, err := p.pos, p.err
+	readErr := read()
+	p.pos, p.err = pos, err
+	return readErr
+}
+
+func (p *Packet) PeekByte() (ret byte, err error) {
+	err = p.peek(func() (err error) {
+		ret, err = p.ReadByte()
+		return
+	})
+	return
+}
+
+func (p *Packet) PeekUint16() (ret uint16, err error) {
+	err = p.peek(func() (err error) {
+		ret, err = p.ReadUint16()
+		return
+	})
+	return
+}
+
+func (p *Packet) PeekUint32() (ret uint32, err error) {
+	err = p.peek(func() (err error) {
+		ret, err = p.ReadUint32()
+		return
+	})
+	return
+}
+
+func (p *Packet) PeekUvarint() (ret uint64, err error) {
+	err = p.peek(func() (err error) {
+		ret, err = p.ReadUvarint()
+		return
+	})
+	return
+}
+
+// Peek 返回之后的n字节, 不移动位置, 不复制
+func (p *Packet) Peek(n int) (ret []byte, err error) {
+	err = p.peek(func() (err error) {
+		ret, err = p.ReadRawBytes(n)
+		return
+	})
+	return
+}
+
+// ReadRawBytes 读取n字节, 不复制
+func (p *Packet) ReadRawBytes(n int) ([]byte, error) {
+	if n < 0 {
+		return nil, p.fail(errors.New("read raw bytes failed"))
+	}
+	return p.readN(uint64(n), "read raw bytes failed")
+}
+
+// ReadUint16s 读取n个uint16, 先检查剩余数据长度再分配
+func (p *Packet) ReadUint16s(n int) ([]uint16, error) {
+	if err := p.requireN(n, 2); err != nil {
+		return nil, err
+	}
+	ret := make([]uint16, n)
+	for i := range ret {
+		ret[i], _ = p.ReadUint16()
+	}
+	return ret, nil
+}
+
+// ReadUint32s 读取n个uint32, 先检查剩余数据长度再分配
+func (p *Packet) ReadUint32s(n int) ([]uint32, error) {
+	if err := p.requireN(n, 4); err != nil {
+		return nil, err
+	}
+	ret := make([]uint32, n)
+	for i := range ret {
+		ret[i], _ = p.ReadUint32()
+	}
+	return ret, nil
+}
+
+// ReadInt32s 读取n个int32, 先检查剩余数据长度再分配
+func (p *Packet) ReadInt32s(n int) ([]int32, error) {
+	if err := p.requireN(n, 4); err != nil {
+		return nil, err
+	}
+	ret := make([]int32, n)
+	for i := range ret {
+		ret[i], _ = p.ReadInt32()
+	}
+	return ret, nil
+}
+
+// ReadUint64s 读取n个uint64, 先检查剩余数据长度再分配
+func (p *Packet) ReadUint64s(n int) ([]uint64, error) {
+	if err := p.requireN(n, 8); err != nil {
+		return nil, err
+	}
+	ret := make([]uint64, n)
+	for i := range ret {
+		ret[i], _ = p.ReadUint64()
+	}
+	return ret, nil
+}
+
+// ReadInt64s 读取n个int64, 先检查剩余数据长度再分配
+func (p *Packet) ReadInt64s(n int) ([]int64, error) {
+	if err := p.requireN(n, 8); err != nil {
+		return nil, err
+	}
+	ret := make([]int64, n)
+	for i := range ret {
+		ret[i], _ = p.ReadInt64()
+	}
+	return ret, nil
+}
+
+// 检查剩余数据足够n个size字节的元素, 避免按错误的数量分配内存
+func (p *Packet) requireN(n, size int) error {
+	if n < 0 || n > p.Remaining()/size {
+		if err := p.failed(); err != nil {
+			return err
+		}
+		return p.fail(errors.New("read batch failed"))
+	}
+	return p.Require(n * size)
+}
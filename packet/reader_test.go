@@ -0,0 +1,163 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package packet
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestStickyReader(t *testing.T) {
+	writer := Writer()
+	writer.WriteUint32(7)
+	writer.WriteString("name")
+	writer.WriteByte(1)
+
+	reader := StickyReader(writer.Data())
+	id, _ := reader.ReadUint32()
+	name, _ := reader.ReadString()
+	_, _ = reader.ReadUint64()
+	pos := reader.Pos()
+	// 失败后的读取不再移动位置, 即使剩余数据足够
+	if b, err := reader.ReadByte(); err == nil || b != 0 || reader.Pos() != pos {
+		t.Fatalf("read after error: %d %v pos %d", b, err, reader.Pos())
+	}
+	if id != 7 || name != "name" || reader.Err() == nil {
+		t.Fatalf("unexpected result %d %q %v", id, name, reader.Err())
+	}
+	first := reader.Err()
+	_, _ = reader.ReadVarString()
+	if reader.Err() != first {
+		t.Fatal("first error overwritten")
+	}
+
+	reader.Reset([]byte{1, 2})
+	if v, err := reader.ReadUint16(); err != nil || v != 0x0102 || reader.Err() != nil {
+		t.Fatalf("read after Reset: %d %v", v, err)
+	}
+
+	// 非粘性模式仍记录第一次错误, 之后的读取正常进行
+	reader = Reader([]byte{1})
+	if _, err := reader.ReadUint16(); err == nil {
+		t.Fatal("read past end succeeded")
+	}
+	if b, err := reader.ReadByte(); err != nil || b != 1 || reader.Err() == nil {
+		t.Fatalf("non-sticky read: %d %v %v", b, err, reader.Err())
+	}
+}
+
+func TestSeekAndPeek(t *testing.T) {
+	reader := Reader([]byte{0, 1, 0, 0, 0, 2})
+	if err := reader.Seek(7); err == nil || reader.Pos() != 0 {
+		t.Fatalf("seek out of range: %v pos %d", err, reader.Pos())
+	}
+	if v, err := reader.PeekUint16(); err != nil || v != 1 || reader.Pos() != 0 {
+		t.Fatalf("PeekUint16: %d %v pos %d", v, err, reader.Pos())
+	}
+	if err := reader.Seek(2); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := reader.PeekUint32(); v != 2 {
+		t.Fatalf("PeekUint32: %d", v)
+	}
+	if b, _ := reader.Peek(4); !bytes.Equal(b, []byte{0, 0, 0, 2}) {
+		t.Fatalf("Peek: %v", b)
+	}
+	// Peek失败不记录错误, 不影响后续读取
+	reader.Reset(reader.Data()[2:])
+	if _, err := reader.Peek(5); err == nil {
+		t.Fatal("peek past end succeeded")
+	}
+	if reader.Err() != nil || reader.Remaining() != 4 {
+		t.Fatalf("peek changed state: %v %d", reader.Err(), reader.Remaining())
+	}
+}
+
+func TestBatchReads(t *testing.T) {
+	writer := Writer()
+	for i := uint32(1); i <= 3; i++ {
+		writer.WriteUint32(i)
+	}
+	reader := Reader(writer.Data())
+	if err := reader.Require(12); err != nil {
+		t.Fatal(err)
+	}
+	values, err := reader.ReadUint32s(3)
+	if err != nil || len(values) != 3 || values[2] != 3 {
+		t.Fatalf("ReadUint32s: %v %v", values, err)
+	}
+	reader.Reset(writer.Data())
+	if _, err = reader.ReadUint64s(2); err == nil || reader.Pos() != 0 {
+		t.Fatalf("ReadUint64s beyond data: %v pos %d", err, reader.Pos())
+	}
+	if _, err = reader.ReadInt32s(1 << 30); err == nil {
+		t.Fatal("huge count accepted")
+	}
+	if _, err = reader.ReadRawBytes(-1); err == nil {
+		t.Fatal("negative length accepted")
+	}
+}
+
+// 长度头读取成功但数据不足时同样不移动位置
+func TestFailedReadKeepsPos(t *testing.T) {
+	writer := Writer()
+	writer.WriteUint16(100)
+	writer.WriteUint32(100)
+	writer.WriteUvarint(1 << 40)
+	reads := []func(r *Packet) error{
+		func(r *Packet) error { _, err := r.ReadBytes(); return err },
+		func(r *Packet) error { _, err := r.ReadString(); return err },
+		func(r *Packet) error { _, err := r.ReadString32(); return err },
+		func(r *Packet) error { _, err := r.ReadVarBytes(); return err },
+		func(r *Packet) error { _, err := r.ReadLength(WidthVarint); return err },
+		func(r *Packet) error { _, err := r.ReadUvarint32(); return err },
+		func(r *Packet) error { _, err := r.ReadVarint32(); return err },
+	}
+	offsets := []uint{0, 0, 2, 6, 6, 6, 6}
+	for i, read := range reads {
+		reader := Reader(writer.Data())
+		_ = reader.Seek(offsets[i])
+		if err := read(reader); err == nil || reader.Pos() != offsets[i] {
+			t.Errorf("read %d: %v pos %d", i, err, reader.Pos())
+		}
+	}
+	if data := (&Packet{data: []byte{1}, pos: 2}).RemainData(); data != nil {
+		t.Fatalf("RemainData out of range: %v", data)
+	}
+}
+
+func TestAcquireWriterResetsReaderState(t *testing.T) {
+	writer := AcquireWriter()
+	writer.sticky = true
+	writer.err = ErrLengthOverflow
+	writer.Release()
+	for i := 0; i < 4; i++ {
+		writer = AcquireWriter()
+		if writer.sticky || writer.err != nil {
+			t.Fatal("reused writer keeps reader state")
+		}
+		writer.Release()
+	}
+}
@@ -0,0 +1,145 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package packet
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// ZigZag 有符号数映射为无符号数, 绝对值小的负数编码后也较短
+func ZigZag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func UnZigZag(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+// 与encoding/binary的Uvarint格式相同, 每字节7位, 最多10字节
+func (p *Packet) ReadUvarint() (ret uint64, err error) {
+	if err = p.failed(); err != nil {
+		return
+	}
+	if p.pos >= uint(len(p.data)) {
+		err = p.fail(errors.New("read uvarint failed"))
+		return
+	}
+	ret, n := binary.Uvarint(p.data[p.pos:])
+	if n == 0 {
+		err = p.fail(errors.New("read uvarint failed"))
+		return
+	}
+	if n < 0 {
+		err = p.fail(ErrVarintOverflow)
+		return
+	}
+	p.pos += uint(n)
+	return
+}
+
+// zigzag编码的varint
+func (p *Packet) ReadVarint() (ret int64, err error) {
+	v, err := p.ReadUvarint()
+	if err != nil {
+		return
+	}
+	ret = UnZigZag(v)
+	return
+}
+
+func (p *Packet) ReadUvarint32() (ret uint32, err error) {
+	pos := p.pos
+	v, err := p.ReadUvarint()
+	if err != nil {
+		return
+	}
+	if v > math.MaxUint32 {
+		p.pos = pos
+		err = p.fail(ErrVarintOverflow)
+		return
+	}
+	ret = uint32(v)
+	return
+}
+
+func (p *Packet) ReadVarint32() (ret int32, err error) {
+	pos := p.pos
+	v, err := p.ReadVarint()
+	if err != nil {
+		return
+	}
+	if v < math.MinInt32 || v > math.MaxInt32 {
+		p.pos = pos
+		err = p.fail(ErrVarintOverflow)
+		return
+	}
+	ret = int32(v)
+	return
+}
+
+// 读取varint长度头的数据, 与WriteVarBytes对应
+func (p *Packet) ReadVarBytes() (ret []byte, err error) {
+	pos := p.pos
+	size, err := p.ReadUvarint()
+	if err != nil {
+		return
+	}
+	if ret, err = p.readN(size, "read varbytes data failed"); err != nil {
+		p.pos = pos
+	}
+	return
+}
+
+func (p *Packet) ReadVarString() (ret string, err error) {
+	bytes, err := p.ReadVarBytes()
+	if err != nil {
+		return
+	}
+	ret = string(bytes)
+	return
+}
+
+func (p *Packet) WriteUvarint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	p.data = append(p.data, buf[:n]...)
+}
+
+func (p *Packet) WriteVarint(v int64) {
+	p.WriteUvarint(ZigZag(v))
+}
+
+// varint长度头
+func (p *Packet) WriteVarBytes(v []byte) {
+	p.WriteUvarint(uint64(len(v)))
+	p.data = append(p.data, v...)
+}
+
+func (p *Packet) WriteVarString(v string) {
+	p.WriteUvarint(uint64(len(v)))
+	p.data = append(p.data, v...)
+}
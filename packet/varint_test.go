@@ -0,0 +1,165 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package packet
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestZigZag(t *testing.T) {
+	cases := []struct {
+		v int64
+		u uint64
+	}{
+		{0, 0}, {-1, 1}, {1, 2}, {-2, 3}, {math.MaxInt64, math.MaxUint64 - 1}, {math.MinInt64, math.MaxUint64},
+	}
+	for _, c := range cases {
+		if u := ZigZag(c.v); u != c.u {
+			t.Errorf("ZigZag(%d) = %d, want %d", c.v, u, c.u)
+		}
+		if v := UnZigZag(c.u); v != c.v {
+			t.Errorf("UnZigZag(%d) = %d, want %d", c.u, v, c.v)
+		}
+	}
+}
+
+func TestVarint(t *testing.T) {
+	writer := Writer()
+	uvalues := []uint64{0, 1, 127, 128, 1 << 32, math.MaxUint64}
+	values := []int64{0, -1, 63, -64, math.MinInt64, math.MaxInt64}
+	for i := range uvalues {
+		writer.WriteUvarint(uvalues[i])
+		writer.WriteVarint(values[i])
+	}
+	writer.WriteVarBytes([]byte("bytes"))
+	writer.WriteVarString(strings.Repeat("s", 300))
+
+	reader := Reader(writer.Data())
+	for i := range uvalues {
+		if u, err := reader.ReadUvarint(); err != nil || u != uvalues[i] {
+			t.Fatalf("ReadUvarint: %d %v, want %d", u, err, uvalues[i])
+		}
+		if v, err := reader.ReadVarint(); err != nil || v != values[i] {
+			t.Fatalf("ReadVarint: %d %v, want %d", v, err, values[i])
+		}
+	}
+	if b, err := reader.ReadVarBytes(); err != nil || string(b) != "bytes" {
+		t.Fatalf("ReadVarBytes: %q %v", b, err)
+	}
+	if s, err := reader.ReadVarString(); err != nil || len(s) != 300 {
+		t.Fatalf("ReadVarString: %d %v", len(s), err)
+	}
+	if _, err := reader.ReadUvarint(); err == nil {
+		t.Fatal("read past end succeeded")
+	}
+}
+
+func TestVarintInvalid(t *testing.T) {
+	overflow := bytes.Repeat([]byte{0xff}, 10)
+	overflow = append(overflow, 0x01)
+	if _, err := Reader(overflow).ReadUvarint(); err != ErrVarintOverflow {
+		t.Fatalf("expect ErrVarintOverflow, got %v", err)
+	}
+	if _, err := Reader([]byte{0x80, 0x80}).ReadUvarint(); err == nil {
+		t.Fatal("truncated varint accepted")
+	}
+	writer := Writer()
+	writer.WriteUvarint(math.MaxUint32 + 1)
+	writer.WriteVarint(math.MinInt32 - 1)
+	reader := Reader(writer.Data())
+	if _, err := reader.ReadUvarint32(); err != ErrVarintOverflow {
+		t.Fatalf("expect ErrVarintOverflow, got %v", err)
+	}
+	if _, err := reader.ReadVarint32(); err != ErrVarintOverflow {
+		t.Fatalf("expect ErrVarintOverflow, got %v", err)
+	}
+	// 长度头超出剩余数据
+	writer = Writer()
+	writer.WriteUvarint(math.MaxUint64)
+	writer.WriteRawBytes([]byte("abc"))
+	if _, err := Reader(writer.Data()).ReadVarBytes(); err == nil {
+		t.Fatal("oversized length accepted")
+	}
+}
+
+func TestLengthPrefix(t *testing.T) {
+	long := strings.Repeat("x", math.MaxUint16+1)
+	writer := Writer()
+	if err := writer.WriteString(long); err != ErrLengthOverflow {
+		t.Fatalf("expect ErrLengthOverflow, got %v", err)
+	}
+	if err := writer.WriteBytes([]byte(long)); err != ErrLengthOverflow {
+		t.Fatalf("expect ErrLengthOverflow, got %v", err)
+	}
+	if writer.Length() != 0 {
+		t.Fatalf("overflowed write left %d bytes", writer.Length())
+	}
+	if err := writer.WriteString32(long); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.WriteBytes32([]byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	reader := Reader(writer.Data())
+	if s, err := reader.ReadString32(); err != nil || s != long {
+		t.Fatalf("ReadString32: %d %v", len(s), err)
+	}
+	if b, err := reader.ReadBytes32(); err != nil || string(b) != "abc" {
+		t.Fatalf("ReadBytes32: %q %v", b, err)
+	}
+	if _, err := Reader([]byte{0, 0, 0, 9, 'a'}).ReadBytes32(); err == nil {
+		t.Fatal("oversized length accepted")
+	}
+}
+
+func TestLittleEndian(t *testing.T) {
+	writer := Writer()
+	writer.WriteUint16LE(0x0102)
+	writer.WriteInt32LE(-2)
+	writer.WriteUint64LE(0x0102030405060708)
+	writer.WriteFloat64LE(1.5)
+	if data := writer.Data(); data[0] != 0x02 || data[1] != 0x01 || data[6] != 0x08 {
+		t.Fatalf("not little endian: %v", data[:8])
+	}
+	reader := Reader(writer.Data())
+	if v, _ := reader.ReadUint16LE(); v != 0x0102 {
+		t.Fatalf("ReadUint16LE: %x", v)
+	}
+	if v, _ := reader.ReadInt32LE(); v != -2 {
+		t.Fatalf("ReadInt32LE: %d", v)
+	}
+	if v, _ := reader.ReadUint64LE(); v != 0x0102030405060708 {
+		t.Fatalf("ReadUint64LE: %x", v)
+	}
+	if v, _ := reader.ReadFloat64LE(); v != 1.5 {
+		t.Fatalf("ReadFloat64LE: %v", v)
+	}
+	if _, err := reader.ReadUint32LE(); err == nil {
+		t.Fatal("read past end succeeded")
+	}
+}
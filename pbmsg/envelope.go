@@ -0,0 +1,238 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package pbmsg
+
+import (
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/mafei198/glib/logger"
+	"github.com/mafei198/glib/packet"
+	"sync"
+	"time"
+)
+
+// 带请求id和状态码的消息封装:
+// [kind byte][id uint32][code int32][error string][type name string][protobuf]
+const (
+	KindRequest  byte = 1
+	KindResponse byte = 2
+	KindPush     byte = 3 // 服务器主动推送, id为0
+)
+
+const CodeOK int32 = 0
+
+// 非StatusError的错误只在服务器记录, 响应中统一使用该描述
+const InternalError = "internal error"
+
+var (
+	ErrInvalidKind = errors.New("invalid envelope kind")
+	ErrCallTimeout = errors.New("call timeout")
+	ErrCallsClosed = errors.New("connection closed")
+)
+
+type Envelope struct {
+	Kind  byte
+	Id    uint32
+	Code  int32
+	Error string
+	Msg   proto.Message
+}
+
+// 业务错误, 以错误响应返回给请求方
+type StatusError struct {
+	Code int32
+	Msg  string
+}
+
+func NewError(code int32, msg string) *StatusError {
+	return &StatusError{Code: code, Msg: msg}
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("status %d: %s", e.Code, e.Msg)
+}
+
+// 返回响应携带的错误, 成功时为nil
+func (e *Envelope) Err() error {
+	if e.Code == CodeOK {
+		return nil
+	}
+	return &StatusError{Code: e.Code, Msg: e.Error}
+}
+
+func EncodeEnvelope(env *Envelope) ([]byte, error) {
+	if env.Kind < KindRequest || env.Kind > KindPush {
+		return nil, ErrInvalidKind
+	}
+	buffer := packet.Writer()
+	buffer.WriteByte(env.Kind)
+	buffer.WriteUint32(env.Id)
+	buffer.WriteInt32(env.Code)
+	if err := buffer.WriteString(env.Error); err != nil {
+		return nil, err
+	}
+	if env.Msg == nil {
+		buffer.WriteString("")
+		return buffer.Data(), nil
+	}
+	data, err := proto.Marshal(env.Msg)
+	if err != nil {
+		return nil, err
+	}
+	buffer.WriteString(GetType(env.Msg))
+	buffer.WriteRawBytes(data)
+	return buffer.Data(), nil
+}
+
+func DecodeEnvelope(data []byte) (*Envelope, error) {
+	buffer := packet.StickyReader(data)
+	env := &Envelope{}
+	var err error
+	if env.Kind, err = buffer.ReadByte(); err != nil {
+		return nil, err
+	}
+	if env.Kind < KindRequest || env.Kind > KindPush {
+		return nil, ErrInvalidKind
+	}
+	env.Id, _ = buffer.ReadUint32()
+	env.Code, _ = buffer.ReadInt32()
+	env.Error, _ = buffer.ReadString()
+	name, _ := buffer.ReadString()
+	if err = buffer.Err(); err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return env, nil
+	}
+	factory, ok := msgFactories[name]
+	if !ok {
+		return nil, ErrUnregisteredMsg
+	}
+	env.Msg = factory()
+	return env, proto.Unmarshal(buffer.RemainData(), env.Msg)
+}
+
+// err为StatusError时返回其状态码及描述, 其他错误只记录日志, 返回InternalError
+func NewResponse(id uint32, msg proto.Message, err error) *Envelope {
+	env := &Envelope{Kind: KindResponse, Id: id, Msg: msg}
+	if err != nil {
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) {
+			env.Code = statusErr.Code
+			env.Error = statusErr.Msg
+		} else {
+			logger.ERR("pbmsg request ", id, " failed: ", err)
+			env.Code = -1
+			env.Error = InternalError
+		}
+	}
+	return env
+}
+
+// 等待响应的请求表, 按请求id匹配响应
+type Calls struct {
+	mutex   sync.Mutex
+	seq     uint32
+	pending map[uint32]chan *Envelope
+	closed  chan struct{}
+	err     error
+}
+
+func NewCalls() *Calls {
+	return &Calls{pending: map[uint32]chan *Envelope{}, closed: make(chan struct{})}
+}
+
+// 连接断开时调用, 等待中及之后的请求立即返回err, 为nil时返回ErrCallsClosed
+func (c *Calls) Close(err error) {
+	if err == nil {
+		err = ErrCallsClosed
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	close(c.closed)
+}
+
+// 发送请求并等待响应, 响应由Dispatch交付
+func (c *Calls) Call(send func([]byte) error, msg proto.Message, timeout time.Duration) (*Envelope, error) {
+	c.mutex.Lock()
+	if c.err != nil {
+		c.mutex.Unlock()
+		return nil, c.err
+	}
+	c.seq++
+	if c.seq == 0 {
+		c.seq++
+	}
+	id := c.seq
+	ch := make(chan *Envelope, 1)
+	c.pending[id] = ch
+	c.mutex.Unlock()
+	defer func() {
+		c.mutex.Lock()
+		delete(c.pending, id)
+		c.mutex.Unlock()
+	}()
+
+	data, err := EncodeEnvelope(&Envelope{Kind: KindRequest, Id: id, Msg: msg})
+	if err != nil {
+		return nil, err
+	}
+	if err = send(data); err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case env := <-ch:
+		return env, env.Err()
+	case <-timer.C:
+		return nil, ErrCallTimeout
+	case <-c.closed:
+		return nil, c.err
+	}
+}
+
+// 将响应交给等待中的请求, 没有对应请求时返回false
+func (c *Calls) Dispatch(env *Envelope) bool {
+	if env.Kind != KindResponse {
+		return false
+	}
+	c.mutex.Lock()
+	ch, ok := c.pending[env.Id]
+	c.mutex.Unlock()
+	if !ok {
+		return false
+	}
+	select {
+	case ch <- env:
+	default: // 重复的响应
+	}
+	return true
+}
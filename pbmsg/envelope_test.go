@@ -0,0 +1,75 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package pbmsg
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCallsClose(t *testing.T) {
+	calls := NewCalls()
+	sent := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		_, err := calls.Call(func([]byte) error { close(sent); return nil }, nil, time.Minute)
+		done <- err
+	}()
+	<-sent
+	closeErr := errors.New("conn reset")
+	calls.Close(closeErr)
+	select {
+	case err := <-done:
+		if err != closeErr {
+			t.Fatalf("expect close error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pending call not released by Close")
+	}
+	if _, err := calls.Call(func([]byte) error { return nil }, nil, time.Minute); err != closeErr {
+		t.Fatalf("call after Close: %v", err)
+	}
+}
+
+func TestNewResponseWrappedStatus(t *testing.T) {
+	err := fmt.Errorf("handler: %w", NewError(404, "not found"))
+	env := NewResponse(1, nil, err)
+	if env.Code != 404 || env.Error != "not found" {
+		t.Fatalf("unexpected response %d %q", env.Code, env.Error)
+	}
+}
+
+// 内部错误的细节不返回给请求方
+func TestNewResponseInternalError(t *testing.T) {
+	env := NewResponse(1, nil, errors.New("dial db 10.0.0.1:3306 failed"))
+	if env.Code != -1 || env.Error != InternalError {
+		t.Fatalf("unexpected response %d %q", env.Code, env.Error)
+	}
+	if env = NewResponse(1, nil, nil); env.Code != CodeOK || env.Error != "" {
+		t.Fatalf("unexpected response %d %q", env.Code, env.Error)
+	}
+}
@@ -0,0 +1,116 @@
+//go:build go1.18
+// +build go1.18
+
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package pbmsg
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+)
+
+// 解码的都是客户端发来的数据, 任何输入都不能panic
+func addDecodeSeeds(f *testing.F) {
+	msg := &wrappers.StringValue{Value: "hello"}
+	data, err := Encode(msg)
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(data)
+	data, err = EncodeEnvelope(&Envelope{Kind: KindRequest, Id: 7, Msg: msg})
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(data)
+	data, err = EncodeEnvelope(&Envelope{Kind: KindResponse, Id: 7, Code: 3, Error: "failed"})
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(data)
+	f.Add([]byte{})
+	f.Add([]byte{0, 11, 'S', 't', 'r', 'i', 'n', 'g', 'V', 'a', 'l', 'u', 'e', 0x0a, 0xff, 0xff, 0xff, 0xff, 0x0f})
+}
+
+func FuzzDecode(f *testing.F) {
+	addDecodeSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg, err := Decode(data)
+		if err != nil {
+			return
+		}
+		// 解码成功的消息重新编码后必须能再次解码
+		encoded, err := Encode(msg)
+		if err != nil {
+			t.Fatalf("re-encode %v: %v", msg, err)
+		}
+		again, err := Decode(encoded)
+		if err != nil || !proto.Equal(again.(proto.Message), msg.(proto.Message)) {
+			t.Fatalf("decode re-encoded data: %v %v", again, err)
+		}
+	})
+}
+
+func FuzzDecodeWithOut(f *testing.F) {
+	addDecodeSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_ = DecodeWithOut(data, &wrappers.StringValue{})
+		_ = DecodeWithOut(data, &wrappers.Int64Value{})
+	})
+}
+
+func FuzzDecodeEnvelope(f *testing.F) {
+	addDecodeSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		env, err := DecodeEnvelope(data)
+		if err != nil {
+			return
+		}
+		encoded, err := EncodeEnvelope(env)
+		if err != nil {
+			t.Fatalf("re-encode %+v: %v", env, err)
+		}
+		again, err := DecodeEnvelope(encoded)
+		if err != nil || again.Kind != env.Kind || again.Id != env.Id || again.Code != env.Code || again.Error != env.Error {
+			t.Fatalf("decode re-encoded envelope: %+v %v", again, err)
+		}
+		if (env.Msg == nil) != (again.Msg == nil) || (env.Msg != nil && !proto.Equal(env.Msg, again.Msg)) {
+			t.Fatalf("message mismatch %v != %v", again.Msg, env.Msg)
+		}
+	})
+}
+
+func FuzzTypeName(f *testing.F) {
+	addDecodeSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if name, ok := TypeName(data); ok {
+			if _, err := NewMessage(name); err != nil {
+				t.Fatalf("unregistered type name %q", name)
+			}
+		}
+	})
+}
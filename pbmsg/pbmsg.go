@@ -48,6 +48,15 @@ func Register(factory Factory) {
 	msgFactories[name] = factory
 }
 
+// 按类型名创建已注册的消息
+func NewMessage(name string) (proto.Message, error) {
+	factory, ok := msgFactories[name]
+	if !ok {
+		return nil, ErrUnregisteredMsg
+	}
+	return factory(), nil
+}
+
 func Encode(pb interface{}) ([]byte, error) {
 	if msg, ok := pb.(proto.Message); ok {
 		buffer := packet.Writer()
@@ -85,6 +94,28 @@ func DecodeWithOut(data []byte, out proto.Message) error {
 	return proto.Unmarshal(buffer.RemainData(), out)
 }
 
+// TypeName 不解码消息体, 返回Encode或EncodeEnvelope编码的数据中已注册的消息类型名
+func TypeName(data []byte) (string, bool) {
+	buffer := packet.Reader(data)
+	if name, err := buffer.ReadString(); err == nil {
+		if _, ok := msgFactories[name]; ok {
+			return name, true
+		}
+	}
+	buffer = packet.StickyReader(data)
+	if kind, err := buffer.ReadByte(); err != nil || kind < KindRequest || kind > KindPush {
+		return "", false
+	}
+	_ = buffer.Seek(8)
+	_, _ = buffer.ReadString()
+	name, _ := buffer.ReadString()
+	if buffer.Err() != nil {
+		return "", false
+	}
+	_, ok := msgFactories[name]
+	return name, ok
+}
+
 func GetType(msg interface{}) string {
 	if t := reflect.TypeOf(msg); t.Kind() == reflect.Ptr {
 		return t.Elem().Name()
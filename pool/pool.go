@@ -27,10 +27,12 @@ import (
 	"container/list"
 	"github.com/mafei198/glib/gen_server"
 	"github.com/mafei198/glib/logger"
+	"sync"
 )
 
 type Pool struct {
-	server *gen_server.GenServer
+	server  *gen_server.GenServer
+	manager *Manager
 }
 
 type TaskHandler func(msg interface{}) (interface{}, error)
@@ -41,19 +43,22 @@ type Task struct {
 	Reply  bool
 }
 
+// 任务经由manager的邮箱提交, worker完成后直接加锁归还, 不经过邮箱,
+// 邮箱被任务占满时归还也不会失败
 type Manager struct {
+	mutex       sync.Mutex
 	tasks       *list.List
 	idleWorkers *list.List
 	workers     []*Worker
 }
 
 func New(size int, handler TaskHandler) (pool *Pool, err error) {
-	pool = &Pool{}
 	manager := &Manager{
 		tasks:       list.New(),
 		idleWorkers: list.New(),
 		workers:     make([]*Worker, size),
 	}
+	pool = &Pool{manager: manager}
 	// init manager
 	pool.server, err = gen_server.New(manager, size, handler)
 	if err != nil {
@@ -77,18 +82,41 @@ func (p *Pool) Process(args interface{}) (interface{}, error) {
 }
 
 func (p *Pool) ProcessAsync(args interface{}) {
-	err := p.server.Cast(&TaskParams{args})
+	err := p.Cast(args)
 	if err != nil {
 		logger.ERR("pool ProcessAsync failed: ", err)
 	}
 }
 
-type ReturnWorkerParams struct{ idx int }
+// 与ProcessAsync相同, 队列满时返回错误
+func (p *Pool) Cast(args interface{}) error {
+	return p.server.Cast(&TaskParams{args})
+}
 
+// 取出一个排队的任务由该worker执行, 没有任务时放回闲置列表
 func (p *Pool) ReturnWorker(idx int) {
-	err := p.server.Cast(&ReturnWorkerParams{idx})
-	if err != nil {
-		logger.ERR("pool ReturnWorker failed: ", err)
+	m := p.manager
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	task := m.tasks.Front()
+	if task != nil {
+		m.tasks.Remove(task)
+		m.workers[idx].Process(task.Value.(*Task))
+	} else {
+		m.idleWorkers.PushBack(m.workers[idx])
+	}
+}
+
+// 有闲置worker时直接执行, 否则排队
+func (m *Manager) dispatch(task *Task) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	worker := m.idleWorkers.Front()
+	if worker != nil {
+		m.idleWorkers.Remove(worker)
+		worker.Value.(*Worker).Process(task)
+	} else {
+		m.tasks.PushBack(task)
 	}
 }
 
@@ -99,19 +127,11 @@ func (m *Manager) Init([]interface{}) (err error) {
 func (m *Manager) HandleCall(req *gen_server.Request) (interface{}, error) {
 	switch params := req.Msg.(type) {
 	case *TaskParams:
-		task := &Task{
+		m.dispatch(&Task{
 			Params: params.Msg,
 			Client: req,
 			Reply:  true,
-		}
-		// 取出一个闲置worker处理任务
-		worker := m.idleWorkers.Front()
-		if worker != nil {
-			m.idleWorkers.Remove(worker)
-			worker.Value.(*Worker).Process(task)
-		} else {
-			m.tasks.PushBack(task)
-		}
+		})
 	}
 	return nil, nil
 }
@@ -121,29 +141,11 @@ type TaskParams struct{ Msg interface{} }
 func (m *Manager) HandleCast(req *gen_server.Request) {
 	switch params := req.Msg.(type) {
 	case *TaskParams: // worker处理task
-		task := &Task{
+		m.dispatch(&Task{
 			Params: params.Msg,
 			Client: req,
 			Reply:  false,
-		}
-		worker := m.idleWorkers.Front()
-		if worker != nil {
-			m.idleWorkers.Remove(worker)
-			worker.Value.(*Worker).Process(task)
-		} else {
-			m.tasks.PushBack(task)
-		}
-		break
-	case *ReturnWorkerParams: // 取出一个任务由worker执行
-		task := m.tasks.Front()
-		if task != nil {
-			m.tasks.Remove(task)
-			task := task.Value.(*Task)
-			m.workers[params.idx].Process(task)
-		} else {
-			m.idleWorkers.PushBack(m.workers[params.idx])
-		}
-		break
+		})
 	default:
 		logger.ERR("unhandle pool cast: ", params)
 	}
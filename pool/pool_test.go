@@ -0,0 +1,71 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package pool
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// 邮箱被任务占满时worker归还不能丢失, 所有任务执行完后worker全部闲置
+func TestReturnWorkerWithFullMailbox(t *testing.T) {
+	const size, total = 4, 5000
+	var wg sync.WaitGroup
+	p, err := New(size, func(msg interface{}) (interface{}, error) {
+		if _, ok := msg.(int); !ok {
+			return msg, nil
+		}
+		time.Sleep(10 * time.Microsecond)
+		wg.Done()
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg.Add(total)
+	for i := 0; i < total; i++ {
+		for p.Cast(i) != nil {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	wg.Wait()
+	deadline := time.Now().Add(time.Second)
+	for {
+		p.manager.mutex.Lock()
+		idle := p.manager.idleWorkers.Len()
+		p.manager.mutex.Unlock()
+		if idle == size {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of %d workers idle", idle, size)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if result, err := p.Process("ping"); err != nil || result != "ping" {
+		t.Fatalf("process after load: %v %v", result, err)
+	}
+}
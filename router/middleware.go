@@ -0,0 +1,131 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package router
+
+import (
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/mafei198/glib/gnet"
+	"github.com/mafei198/glib/logger"
+	"github.com/mafei198/glib/pbmsg"
+	"runtime/debug"
+	"time"
+)
+
+var (
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrPanic        = errors.New("router handler panic")
+)
+
+// 捕获处理函数中的panic, 记录堆栈并断开该连接,
+// 启用Envelope时请求方先收到pbmsg.InternalError响应
+func Recovery() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) (reply proto.Message, err error) {
+			defer func() {
+				if x := recover(); x != nil {
+					logger.ERR("router caught panic in ", ctx.Name, ": ", x, "\n", string(debug.Stack()))
+					reply, err = nil, fmt.Errorf("%w: %v", ErrPanic, x)
+				}
+			}()
+			return next(ctx)
+		}
+	}
+}
+
+func Logging() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) (proto.Message, error) {
+			logger.DEBUG("router recv: ", ctx.Conn.Id(), " ", ctx.Name)
+			reply, err := next(ctx)
+			if err != nil {
+				logger.WARN("router handle ", ctx.Name, " failed: ", err)
+			}
+			return reply, err
+		}
+	}
+}
+
+// 处理时间超过threshold时输出警告
+func Timing(threshold time.Duration) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) (proto.Message, error) {
+			start := time.Now()
+			reply, err := next(ctx)
+			if elapsed := time.Since(start); elapsed > threshold {
+				logger.WARN("router slow handler: ", ctx.Name, " ", elapsed)
+			}
+			return reply, err
+		}
+	}
+}
+
+const authedKey = "router.authed"
+
+// 标记连接已通过认证
+func SetAuthed(ctx *Context) {
+	ctx.Set(authedKey, true)
+}
+
+func IsAuthed(ctx *Context) bool {
+	authed, _ := ctx.Get(authedKey)
+	return authed == true
+}
+
+// 未认证的连接只能发送allows中的消息
+func Auth(allows ...proto.Message) Middleware {
+	allowed := map[string]bool{}
+	for _, msg := range allows {
+		allowed[pbmsg.GetType(msg)] = true
+	}
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) (proto.Message, error) {
+			if !allowed[ctx.Name] && !IsAuthed(ctx) {
+				return nil, ErrUnauthorized
+			}
+			return next(ctx)
+		}
+	}
+}
+
+const rateLimitKey = "router.rate_limit"
+
+// 单连接每秒处理消息数限制, 超出时断开连接
+func RateLimit(rate, burst int) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) (proto.Message, error) {
+			value, ok := ctx.Get(rateLimitKey)
+			if !ok {
+				value = gnet.NewTokenBucket(rate, burst)
+				ctx.Set(rateLimitKey, value)
+			}
+			if !value.(*gnet.TokenBucket).Allow(1) {
+				return nil, gnet.ErrRateLimited
+			}
+			return next(ctx)
+		}
+	}
+}
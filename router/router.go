@@ -0,0 +1,285 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package router
+
+import (
+	"errors"
+	"github.com/golang/protobuf/proto"
+	"github.com/mafei198/glib/gnet"
+	"github.com/mafei198/glib/logger"
+	"github.com/mafei198/glib/pbmsg"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 没有处理函数时同样经过中间件, 中间件返回nil错误即可忽略该消息,
+// 否则返回给gnet, 由连接按OnData错误处理(默认断开)
+var ErrNoHandler = errors.New("no handler for message")
+
+// 未处理消息的WARN日志间隔, 间隔内的其他消息只计数
+const unhandledLogInterval = time.Second
+
+// 返回的reply不为nil时使用pbmsg.Encode回复给客户端
+type HandlerFunc func(ctx *Context) (reply proto.Message, err error)
+
+type Middleware func(next HandlerFunc) HandlerFunc
+
+type Context struct {
+	Conn gnet.Conn
+	Name string
+	Msg  proto.Message
+	// 启用Envelope时的请求id, 客户端推送的消息为0
+	RequestId uint32
+	state     *connState
+}
+
+// 连接级别的状态, 同一连接的所有请求共享
+type connState struct {
+	mutex  sync.RWMutex
+	values map[string]interface{}
+	calls  *pbmsg.Calls
+}
+
+func (ctx *Context) Set(key string, value interface{}) {
+	ctx.state.mutex.Lock()
+	ctx.state.values[key] = value
+	ctx.state.mutex.Unlock()
+}
+
+func (ctx *Context) Get(key string) (interface{}, bool) {
+	ctx.state.mutex.RLock()
+	defer ctx.state.mutex.RUnlock()
+	value, ok := ctx.state.values[key]
+	return value, ok
+}
+
+type Router struct {
+	unhandled   uint64
+	lastWarn    int64
+	handlers    map[string]HandlerFunc
+	middlewares []Middleware
+	chains      map[string]HandlerFunc
+	notFound    HandlerFunc
+	onConnect   func(ctx *Context)
+	onClose     func(ctx *Context, err error)
+	envelope    bool
+}
+
+func New() *Router {
+	return &Router{
+		handlers: map[string]HandlerFunc{},
+	}
+}
+
+// 按protobuf消息类型注册处理函数, msg仅用于获取类型名
+func (r *Router) Handle(msg proto.Message, handler HandlerFunc) {
+	name := pbmsg.GetType(msg)
+	if _, ok := r.handlers[name]; ok {
+		panic("duplicate router handler: " + name)
+	}
+	r.handlers[name] = handler
+	r.chains = nil
+}
+
+// 中间件按添加顺序由外到内执行
+func (r *Router) Use(middlewares ...Middleware) {
+	r.middlewares = append(r.middlewares, middlewares...)
+	r.chains = nil
+}
+
+// 使用pbmsg.Envelope收发消息, 响应携带请求id及状态码,
+// 处理函数返回*pbmsg.StatusError时只回复错误, 不断开连接
+func (r *Router) UseEnvelope() {
+	r.envelope = true
+}
+
+func (r *Router) OnConnect(cb func(ctx *Context)) {
+	r.onConnect = cb
+}
+
+func (r *Router) OnClose(cb func(ctx *Context, err error)) {
+	r.onClose = cb
+}
+
+// 所有Handle/Use须在Factory之前完成
+func (r *Router) Factory() gnet.HandlerFactory {
+	r.build()
+	return func(conn gnet.Conn) gnet.ConnHandler {
+		handler := &connHandler{
+			router: r,
+			conn:   conn,
+			state: &connState{
+				values: map[string]interface{}{},
+				calls:  pbmsg.NewCalls(),
+			},
+		}
+		if r.onConnect != nil {
+			r.onConnect(handler.context("", nil))
+		}
+		return handler
+	}
+}
+
+func (r *Router) build() {
+	r.chains = make(map[string]HandlerFunc, len(r.handlers))
+	for name, handler := range r.handlers {
+		r.chains[name] = r.wrap(handler)
+	}
+	r.notFound = r.wrap(r.onUnhandled)
+}
+
+func (r *Router) wrap(handler HandlerFunc) HandlerFunc {
+	for i := len(r.middlewares) - 1; i >= 0; i-- {
+		handler = r.middlewares[i](handler)
+	}
+	return handler
+}
+
+// 解码消息, 执行中间件及处理函数, 回复处理结果
+func (r *Router) Dispatch(ctx *Context, data []byte) error {
+	if r.envelope {
+		return r.dispatchEnvelope(ctx, data)
+	}
+	msg, err := pbmsg.Decode(data)
+	if err != nil {
+		return err
+	}
+	reply, err := r.handle(ctx, msg.(proto.Message))
+	if err != nil {
+		return err
+	}
+	if reply == nil {
+		return nil
+	}
+	return Reply(ctx.Conn, reply)
+}
+
+func (r *Router) dispatchEnvelope(ctx *Context, data []byte) error {
+	env, err := pbmsg.DecodeEnvelope(data)
+	if err != nil {
+		return err
+	}
+	if env.Kind == pbmsg.KindResponse {
+		ctx.state.calls.Dispatch(env)
+		return nil
+	}
+	if env.Msg == nil {
+		return pbmsg.ErrInvalidMsgType
+	}
+	ctx.RequestId = env.Id
+	reply, err := r.handle(ctx, env.Msg)
+	if env.Kind != pbmsg.KindRequest {
+		return err
+	}
+	// 请求总是有响应, 以便客户端结束等待
+	if sendErr := send(ctx.Conn, pbmsg.NewResponse(env.Id, reply, err)); sendErr != nil {
+		return sendErr
+	}
+	var statusErr *pbmsg.StatusError
+	if errors.As(err, &statusErr) {
+		return nil
+	}
+	return err
+}
+
+func (r *Router) handle(ctx *Context, msg proto.Message) (proto.Message, error) {
+	ctx.Msg = msg
+	ctx.Name = pbmsg.GetType(msg)
+	handler, ok := r.chains[ctx.Name]
+	if !ok {
+		handler = r.notFound
+	}
+	return handler(ctx)
+}
+
+// 客户端可发送任意已注册的消息, 限制日志频率避免刷屏
+func (r *Router) onUnhandled(ctx *Context) (proto.Message, error) {
+	total := atomic.AddUint64(&r.unhandled, 1)
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&r.lastWarn)
+	if now-last >= int64(unhandledLogInterval) && atomic.CompareAndSwapInt64(&r.lastWarn, last, now) {
+		logger.WARN("router no handler: ", ctx.Name, ", total unhandled: ", total)
+	}
+	return nil, ErrNoHandler
+}
+
+// 没有处理函数的消息总数
+func (r *Router) Unhandled() uint64 {
+	return atomic.LoadUint64(&r.unhandled)
+}
+
+func Reply(conn gnet.Conn, msg proto.Message) error {
+	data, err := pbmsg.Encode(msg)
+	if err != nil {
+		return err
+	}
+	return conn.SendData(data)
+}
+
+// 以KindPush推送消息, 需启用Envelope
+func Push(conn gnet.Conn, msg proto.Message) error {
+	return send(conn, &pbmsg.Envelope{Kind: pbmsg.KindPush, Msg: msg})
+}
+
+// 向客户端发起请求并等待响应, 需启用Envelope.
+// 响应由该连接的读goroutine交付, 不能在同一连接的处理函数中同步调用
+func Call(ctx *Context, msg proto.Message, timeout time.Duration) (*pbmsg.Envelope, error) {
+	return ctx.state.calls.Call(ctx.Conn.SendData, msg, timeout)
+}
+
+func send(conn gnet.Conn, env *pbmsg.Envelope) error {
+	data, err := pbmsg.EncodeEnvelope(env)
+	if err != nil {
+		return err
+	}
+	return conn.SendData(data)
+}
+
+type connHandler struct {
+	router *Router
+	conn   gnet.Conn
+	state  *connState
+}
+
+func (h *connHandler) context(name string, msg proto.Message) *Context {
+	return &Context{
+		Conn:  h.conn,
+		Name:  name,
+		Msg:   msg,
+		state: h.state,
+	}
+}
+
+func (h *connHandler) OnData(data []byte) error {
+	return h.router.Dispatch(h.context("", nil), data)
+}
+
+func (h *connHandler) OnClose(err error) {
+	h.state.calls.Close(err)
+	if h.router.onClose != nil {
+		h.router.onClose(h.context("", nil), err)
+	}
+}
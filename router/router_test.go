@@ -0,0 +1,261 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2018 SavinMax. All rights reserved.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package router
+
+import (
+	"errors"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/mafei198/glib/gnet"
+	"github.com/mafei198/glib/pbmsg"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func init() {
+	pbmsg.Register(func() proto.Message { return &wrappers.StringValue{} })
+	pbmsg.Register(func() proto.Message { return &wrappers.Int32Value{} })
+	pbmsg.Register(func() proto.Message { return &wrappers.BoolValue{} })
+}
+
+type fakeConn struct {
+	mutex sync.Mutex
+	sent  [][]byte
+}
+
+func (c *fakeConn) Id() int64            { return 1 }
+func (c *fakeConn) LocalAddr() net.Addr  { return nil }
+func (c *fakeConn) RemoteAddr() net.Addr { return nil }
+func (c *fakeConn) Close(string) error   { return nil }
+
+func (c *fakeConn) SendData(data []byte) error {
+	c.mutex.Lock()
+	c.sent = append(c.sent, append([]byte(nil), data...))
+	c.mutex.Unlock()
+	return nil
+}
+
+func (c *fakeConn) last(t *testing.T) []byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.sent) == 0 {
+		t.Fatal("nothing sent")
+	}
+	return c.sent[len(c.sent)-1]
+}
+
+func connect(r *Router) (*fakeConn, gnet.ConnHandler) {
+	conn := &fakeConn{}
+	return conn, r.Factory()(conn)
+}
+
+func encode(t *testing.T, msg proto.Message) []byte {
+	data, err := pbmsg.Encode(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func request(t *testing.T, id uint32, msg proto.Message) []byte {
+	data, err := pbmsg.EncodeEnvelope(&pbmsg.Envelope{Kind: pbmsg.KindRequest, Id: id, Msg: msg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func response(t *testing.T, conn *fakeConn) *pbmsg.Envelope {
+	env, err := pbmsg.DecodeEnvelope(conn.last(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Kind != pbmsg.KindResponse {
+		t.Fatalf("expect response, got kind %d", env.Kind)
+	}
+	return env
+}
+
+func TestDispatchByType(t *testing.T) {
+	r := New()
+	r.Handle(&wrappers.StringValue{}, func(ctx *Context) (proto.Message, error) {
+		msg := ctx.Msg.(*wrappers.StringValue)
+		return &wrappers.StringValue{Value: strings.ToUpper(msg.Value)}, nil
+	})
+	r.Handle(&wrappers.Int32Value{}, func(ctx *Context) (proto.Message, error) {
+		if ctx.Name != "Int32Value" {
+			t.Fatalf("unexpected name %q", ctx.Name)
+		}
+		return &wrappers.Int32Value{Value: ctx.Msg.(*wrappers.Int32Value).Value + 1}, nil
+	})
+	conn, handler := connect(r)
+
+	if err := handler.OnData(encode(t, &wrappers.StringValue{Value: "hi"})); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := pbmsg.Decode(conn.last(t))
+	if err != nil || reply.(*wrappers.StringValue).Value != "HI" {
+		t.Fatalf("string reply: %v %v", reply, err)
+	}
+	if err = handler.OnData(encode(t, &wrappers.Int32Value{Value: 1})); err != nil {
+		t.Fatal(err)
+	}
+	reply, err = pbmsg.Decode(conn.last(t))
+	if err != nil || reply.(*wrappers.Int32Value).Value != 2 {
+		t.Fatalf("int reply: %v %v", reply, err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("duplicate handler should panic")
+		}
+	}()
+	r.Handle(&wrappers.StringValue{}, nil)
+}
+
+func TestUnknownMessage(t *testing.T) {
+	r := New()
+	r.Handle(&wrappers.StringValue{}, func(*Context) (proto.Message, error) { return nil, nil })
+	conn, handler := connect(r)
+	// 已注册但没有处理函数
+	if err := handler.OnData(encode(t, &wrappers.BoolValue{})); err != ErrNoHandler {
+		t.Fatalf("got %v, want ErrNoHandler", err)
+	}
+	// 未注册的类型
+	if err := handler.OnData([]byte{0, 3, 'F', 'o', 'o'}); err != pbmsg.ErrUnregisteredMsg {
+		t.Fatalf("got %v, want ErrUnregisteredMsg", err)
+	}
+	if len(conn.sent) != 0 {
+		t.Fatalf("unexpected reply %q", conn.sent)
+	}
+	if n := r.Unhandled(); n != 1 {
+		t.Fatalf("unhandled count %d", n)
+	}
+
+	// 中间件可忽略没有处理函数的消息
+	r = New()
+	r.Use(func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) (proto.Message, error) {
+			reply, err := next(ctx)
+			if err == ErrNoHandler {
+				return nil, nil
+			}
+			return reply, err
+		}
+	})
+	_, handler = connect(r)
+	if err := handler.OnData(encode(t, &wrappers.BoolValue{})); err != nil {
+		t.Fatalf("middleware did not intercept: %v", err)
+	}
+	if n := r.Unhandled(); n != 1 {
+		t.Fatalf("unhandled count %d", n)
+	}
+
+	// 请求总是有响应
+	r = New()
+	r.UseEnvelope()
+	conn, handler = connect(r)
+	if err := handler.OnData(request(t, 7, &wrappers.BoolValue{})); err != ErrNoHandler {
+		t.Fatalf("got %v, want ErrNoHandler", err)
+	}
+	if env := response(t, conn); env.Id != 7 || env.Code != -1 || env.Error != pbmsg.InternalError {
+		t.Fatalf("unexpected response %d %d %q", env.Id, env.Code, env.Error)
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	var trace []string
+	mark := func(name string) Middleware {
+		return func(next HandlerFunc) HandlerFunc {
+			return func(ctx *Context) (proto.Message, error) {
+				trace = append(trace, name+">")
+				defer func() { trace = append(trace, "<"+name) }()
+				return next(ctx)
+			}
+		}
+	}
+	r := New()
+	r.Use(mark("a"), mark("b"))
+	r.Handle(&wrappers.StringValue{}, func(*Context) (proto.Message, error) {
+		trace = append(trace, "handler")
+		return nil, nil
+	})
+	// Handle之后添加的中间件同样生效
+	r.Use(mark("c"))
+	_, handler := connect(r)
+	if err := handler.OnData(encode(t, &wrappers.StringValue{})); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(trace, " "); got != "a> b> c> handler <c <b <a" {
+		t.Fatalf("unexpected order: %s", got)
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	for _, envelope := range []bool{false, true} {
+		r := New()
+		if envelope {
+			r.UseEnvelope()
+		}
+		r.Use(Recovery())
+		r.Handle(&wrappers.StringValue{}, func(*Context) (proto.Message, error) {
+			panic("boom")
+		})
+		conn, handler := connect(r)
+		data := encode(t, &wrappers.StringValue{})
+		if envelope {
+			data = request(t, 3, &wrappers.StringValue{})
+		}
+		err := handler.OnData(data)
+		if !errors.Is(err, ErrPanic) {
+			t.Fatalf("envelope %v: got %v, want ErrPanic", envelope, err)
+		}
+		if !envelope {
+			continue
+		}
+		// 不向客户端暴露panic内容
+		if env := response(t, conn); env.Id != 3 || env.Code != -1 || env.Error != pbmsg.InternalError {
+			t.Fatalf("unexpected response %d %d %q", env.Id, env.Code, env.Error)
+		}
+	}
+}
+
+// 业务错误只回复错误, 不断开连接
+func TestStatusError(t *testing.T) {
+	r := New()
+	r.UseEnvelope()
+	r.Handle(&wrappers.StringValue{}, func(*Context) (proto.Message, error) {
+		return nil, pbmsg.NewError(404, "not found")
+	})
+	conn, handler := connect(r)
+	if err := handler.OnData(request(t, 5, &wrappers.StringValue{})); err != nil {
+		t.Fatalf("status error should not close the conn: %v", err)
+	}
+	if env := response(t, conn); env.Id != 5 || env.Code != 404 || env.Error != "not found" {
+		t.Fatalf("unexpected response %d %d %q", env.Id, env.Code, env.Error)
+	}
+}